@@ -0,0 +1,145 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Address describes an interface address in a mostly "ip addr" compatible form.
+//
+// Notes:
+//   - IP and Device are required and together form the address identity, as in
+//     the kernel; IP carries the prefix length ("10.0.0.5/24"), a bare IP means a
+//     host prefix.
+//   - Scope is a number or a reserved name ("link"); Normalize stores a number,
+//     or nothing for the default global scope. Names from rt_scopes are resolved
+//     when a snapshot is loaded (see NameResolver.ResolveSnapshot).
+//   - Flags are the settable "ip addr" flags (noprefixroute, nodad, home,
+//     mngtmpaddr, optimistic, autojoin); Normalize lowercases and sorts them.
+//   - ValidLft/PreferredLft are lifetimes in seconds; 0 means forever.
+type Address struct {
+	IP           string   `json:"ip"`
+	Device       string   `json:"device"`
+	Label        string   `json:"label,omitempty"`
+	Scope        string   `json:"scope,omitempty"`
+	Flags        []string `json:"flags,omitempty"`
+	ValidLft     int      `json:"valid_lft,omitempty"`
+	PreferredLft int      `json:"preferred_lft,omitempty"`
+}
+
+// addressFlags are the IFA_F_* flags that can be set on an address.
+var addressFlags = map[string]int{
+	"nodad":         0x02,
+	"optimistic":    0x04,
+	"home":          0x10,
+	"mngtmpaddr":    0x100,
+	"noprefixroute": 0x200,
+	"autojoin":      0x400,
+}
+
+// Normalize canonicalizes fields so diffing is stable.
+// It returns a copy of a.
+func (a Address) Normalize() (Address, error) {
+	out := a
+
+	out.IP = strings.ToLower(strings.TrimSpace(out.IP))
+	out.Device = strings.TrimSpace(out.Device)
+	out.Label = strings.TrimSpace(out.Label)
+	out.Scope = strings.TrimSpace(out.Scope)
+
+	if out.IP == "" {
+		return Address{}, fmt.Errorf("address.ip is required")
+	}
+	if out.Device == "" {
+		return Address{}, fmt.Errorf("address.device is required")
+	}
+	if !strings.Contains(out.IP, "/") {
+		ip := net.ParseIP(out.IP)
+		if ip == nil {
+			return Address{}, fmt.Errorf("invalid address.ip %q", a.IP)
+		}
+		if ip.To4() != nil {
+			out.IP += "/32"
+		} else {
+			out.IP += "/128"
+		}
+	}
+	ip, ipNet, err := net.ParseCIDR(out.IP)
+	if err != nil {
+		return Address{}, fmt.Errorf("invalid address.ip %q: %w", a.IP, err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	out.IP = fmt.Sprintf("%s/%d", ip, ones)
+
+	// The kernel requires IPv4 labels to start with the device name.
+	if out.Label != "" && !strings.HasPrefix(out.Label, out.Device) {
+		return Address{}, fmt.Errorf("address.label %q must start with the device name", out.Label)
+	}
+	if out.Label == out.Device {
+		out.Label = ""
+	}
+	if out.Label != "" && ip.To4() == nil {
+		return Address{}, fmt.Errorf("address.label is only supported for IPv4 addresses")
+	}
+
+	if out.Scope, err = canonicalName(out.Scope, builtinNames.Scope); err != nil {
+		return Address{}, fmt.Errorf("invalid address.scope: %w", err)
+	}
+	if out.Scope == "0" {
+		// global is the kernel default.
+		out.Scope = ""
+	}
+
+	if out.ValidLft < 0 || out.PreferredLft < 0 {
+		return Address{}, fmt.Errorf("address lifetimes must be >= 0")
+	}
+	if out.ValidLft != 0 && out.PreferredLft > out.ValidLft {
+		return Address{}, fmt.Errorf("address.preferred_lft must not exceed address.valid_lft")
+	}
+
+	if len(out.Flags) > 0 {
+		seen := make(map[string]bool, len(out.Flags))
+		flags := make([]string, 0, len(out.Flags))
+		for _, f := range out.Flags {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if _, ok := addressFlags[f]; !ok {
+				return Address{}, fmt.Errorf("unsupported address flag %q", f)
+			}
+			if !seen[f] {
+				seen[f] = true
+				flags = append(flags, f)
+			}
+		}
+		sort.Strings(flags)
+		out.Flags = flags
+	} else {
+		out.Flags = nil
+	}
+
+	return out, nil
+}
+
+// identity returns the kernel identity of a normalized address.
+func (a Address) identity() string {
+	return "ip=" + a.IP + "|dev=" + a.Device
+}
+
+// Key returns a deterministic full-key identity string for an address.
+func (a Address) Key() (string, error) {
+	n, err := a.Normalize()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|label=%s|scope=%s|flags=%s|valid_lft=%d|preferred_lft=%d",
+		n.identity(), n.Label, n.Scope, strings.Join(n.Flags, ","), n.ValidLft, n.PreferredLft), nil
+}
+
+func sortAddresses(aa []Address) {
+	sort.Slice(aa, func(i, j int) bool {
+		ki, _ := aa[i].Key()
+		kj, _ := aa[j].Key()
+		return ki < kj
+	})
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// IPAddressManager implements AddressManager using netlink.
+//
+// The kernel only updates the lifetimes of an existing IPv4 address in place, so
+// Add re-creates one whose label, scope or flags change; the routes using it as
+// their source, which the kernel drops with it, are added back right after.
+type IPAddressManager struct {
+	// Handle, when set, is used instead of the global netlink handle;
+	// share IPRouteManager.Handle to manage addresses in the same network namespace.
+	Handle *netlink.Handle
+}
+
+func (m IPAddressManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPAddressManager) List(ctx context.Context) ([]Address, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	nlAddrs, err := m.handle().AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]Address, 0, len(nlAddrs))
+	for _, na := range nlAddrs {
+		a, err := fromNetlinkAddr(m.handle(), na)
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+func (m IPAddressManager) Add(ctx context.Context, a Address) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := a.Normalize()
+	if err != nil {
+		return err
+	}
+	link, err := m.handle().LinkByName(n.Device)
+	if err != nil {
+		return err
+	}
+	na := toNetlinkAddr(n)
+	var dropped []netlink.Route
+	if na.IP.To4() != nil {
+		if dropped, err = m.recreateChanged(link, n); err != nil {
+			return err
+		}
+	}
+	if err := m.handle().AddrReplace(link, na); err != nil {
+		return err
+	}
+	var errs []error
+	for _, r := range dropped {
+		if err := m.handle().RouteAdd(&r); err != nil && !errors.Is(err, syscall.EEXIST) {
+			errs = append(errs, fmt.Errorf("restore route %s: %w", r, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recreateChanged deletes the existing IPv4 address of a when attributes the
+// kernel cannot replace differ. It returns the routes the kernel drops with the
+// address, those using it as their source, so Add can restore them; the
+// connected routes are re-created by the kernel itself.
+func (m IPAddressManager) recreateChanged(link netlink.Link, a Address) ([]netlink.Route, error) {
+	nlAddrs, err := m.handle().AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	for _, na := range nlAddrs {
+		cur, err := fromNetlinkAddr(m.handle(), na)
+		if err != nil || cur.identity() != a.identity() {
+			continue
+		}
+		if cur.Label == a.Label && cur.Scope == a.Scope && strings.Join(cur.Flags, ",") == strings.Join(a.Flags, ",") {
+			return nil, nil
+		}
+		routes, err := m.handle().RouteListFiltered(netlink.FAMILY_V4,
+			&netlink.Route{Table: unix.RT_TABLE_UNSPEC, Src: na.IP}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_SRC)
+		if err != nil {
+			return nil, fmt.Errorf("list routes from %s: %w", na.IP, err)
+		}
+		var dropped []netlink.Route
+		for _, r := range routes {
+			if r.Protocol != unix.RTPROT_KERNEL {
+				dropped = append(dropped, r)
+			}
+		}
+		return dropped, m.handle().AddrDel(link, &na)
+	}
+	return nil, nil
+}
+
+func (m IPAddressManager) Delete(ctx context.Context, a Address) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := a.Normalize()
+	if err != nil {
+		return err
+	}
+	link, err := m.handle().LinkByName(n.Device)
+	if err != nil {
+		// The address went away with its device.
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	err = m.handle().AddrDel(link, toNetlinkAddr(n))
+	if errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	return err
+}
+
+// toNetlinkAddr converts a normalized address.
+func toNetlinkAddr(a Address) *netlink.Addr {
+	ip, ipNet, _ := net.ParseCIDR(a.IP)
+	ipNet.IP = ip
+	na := &netlink.Addr{
+		IPNet:       ipNet,
+		Label:       a.Label,
+		ValidLft:    a.ValidLft,
+		PreferedLft: a.PreferredLft,
+	}
+	if a.Scope != "" {
+		na.Scope, _ = strconv.Atoi(a.Scope)
+	}
+	for _, f := range a.Flags {
+		na.Flags |= addressFlags[f]
+	}
+	// The kernel wants both lifetimes once one is set.
+	if na.ValidLft != 0 && na.PreferedLft == 0 {
+		na.PreferedLft = na.ValidLft
+	}
+	if na.PreferedLft != 0 && na.ValidLft == 0 {
+		na.ValidLft = -1 // sent as all ones: infinity
+	}
+	return na
+}
+
+func fromNetlinkAddr(h *netlink.Handle, na netlink.Addr) (Address, error) {
+	a := Address{
+		IP:     na.IPNet.String(),
+		Device: linkName(h, na.LinkIndex),
+		Label:  na.Label,
+		Scope:  strconv.Itoa(na.Scope),
+	}
+	for name, bit := range addressFlags {
+		if na.Flags&bit != 0 {
+			a.Flags = append(a.Flags, name)
+		}
+	}
+	// Lifetimes are reported as the time left, and infinity as all ones.
+	if uint32(na.ValidLft) != math.MaxUint32 {
+		a.ValidLft = na.ValidLft
+	}
+	if uint32(na.PreferedLft) != math.MaxUint32 {
+		a.PreferredLft = na.PreferedLft
+	}
+	return a.Normalize()
+}
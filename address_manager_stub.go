@@ -0,0 +1,27 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPAddressManager is not supported on non-Linux platforms.
+type IPAddressManager struct {
+	Handle *netlink.Handle
+}
+
+func (m IPAddressManager) List(ctx context.Context) ([]Address, error) {
+	return nil, fmt.Errorf("IPAddressManager is supported only on linux")
+}
+
+func (m IPAddressManager) Add(ctx context.Context, a Address) error {
+	return fmt.Errorf("IPAddressManager is supported only on linux")
+}
+
+func (m IPAddressManager) Delete(ctx context.Context, a Address) error {
+	return fmt.Errorf("IPAddressManager is supported only on linux")
+}
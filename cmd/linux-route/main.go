@@ -0,0 +1,399 @@
+// Command linux-route applies, diffs and inspects Linux routes using the linuxroute library.
+//
+// Usage:
+//
+//	linux-route diff   [flags] <desired.json>   print the plan vs. the baseline (or --live)
+//	linux-route apply  [flags] <desired.json>   reconcile the system to desired.json
+//	linux-route show   [flags]                  dump live routes in Route JSON format
+//	linux-route export [flags] [file]           write the baseline to file (default stdout)
+//	linux-route import [flags] <file>           replace the baseline with file
+//	                                            (--ip-json: file is "ip -j -d route show table all" output)
+//
+// desired.json is either a JSON array of routes or a snapshot object
+// {"routes": [...], "rules": [...], "nexthops": [...], "addresses": [...],
+// "neighbors": [...], "links": [...]}, the same formats FileStore uses.
+// Any other file is read as an "ip route" script, one route per line.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	linuxroute "github.com/jursonmo/linux_route"
+)
+
+const defaultStore = "/var/lib/linux-route/baseline.json"
+
+type options struct {
+	store  string
+	netns  string
+	dryRun bool
+	live   bool
+	output string
+	ipJSON bool
+
+	force            bool
+	maxDeletes       int
+	maxDeletePercent float64
+	protect          string
+	refuseEmpty      bool
+	checkGateways    bool
+	strategy         string
+	identity         string
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1], os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "linux-route:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: linux-route <diff|apply|show|export|import> [flags] [args]")
+}
+
+func run(ctx context.Context, cmd string, args []string, out io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.StringVar(&opts.store, "store", defaultStore, "baseline file path")
+	fs.StringVar(&opts.netns, "netns", "", "network namespace name or path (e.g. foo or /var/run/netns/foo)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "apply: plan (safety guards included) and print it without changing the system")
+	fs.BoolVar(&opts.live, "live", false, "diff/apply: compare against live routes instead of the baseline")
+	fs.StringVar(&opts.output, "output", "table", "output format: json|table|ip")
+	fs.BoolVar(&opts.ipJSON, "ip-json", false, "import: file is \"ip -j -d route show table all\" output")
+	fs.BoolVar(&opts.force, "force", false, "diff/apply: bypass the safety guards")
+	fs.IntVar(&opts.maxDeletes, "max-deletes", 0, "diff/apply: refuse plans deleting more routes (0 = unlimited)")
+	fs.Float64Var(&opts.maxDeletePercent, "max-delete-percent", 0, "diff/apply: refuse plans deleting more than this percentage of the baseline (0 = unlimited)")
+	fs.StringVar(&opts.protect, "protect", "", "diff/apply: comma-separated routes never to delete (\"default\" or CIDR prefixes)")
+	fs.BoolVar(&opts.refuseEmpty, "refuse-empty", false, "diff/apply: refuse an empty desired set")
+	fs.StringVar(&opts.identity, "identity", "full", "diff/apply: route identity, full|dst-table-metric|kernel; routes changing under one identity are replaced in place")
+	fs.StringVar(&opts.strategy, "strategy", "delete-first", "diff/apply: delete-first|add-first|replace-when-possible")
+	fs.BoolVar(&opts.checkGateways, "check-gateways", false, "diff/apply: refuse routes whose gateway no on-link route or address reaches")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.output != "json" && opts.output != "table" && opts.output != "ip" {
+		return fmt.Errorf("unsupported --output %q", opts.output)
+	}
+	if opts.identity != "full" && opts.identity != "dst-table-metric" && opts.identity != "kernel" {
+		return fmt.Errorf("unsupported --identity %q", opts.identity)
+	}
+
+	switch cmd {
+	case "diff":
+		return cmdDiff(ctx, opts, fs.Args(), out)
+	case "apply":
+		return cmdApply(ctx, opts, fs.Args(), out)
+	case "show":
+		return cmdShow(ctx, opts, out)
+	case "export":
+		return cmdExport(opts, fs.Args(), out)
+	case "import":
+		return cmdImport(opts, fs.Args())
+	case "help", "-h", "--help":
+		usage(out)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func newController(opts options) (*linuxroute.Controller, func(), error) {
+	mgr := &linuxroute.IPRouteManager{}
+	if opts.netns != "" {
+		path := opts.netns
+		if !strings.Contains(path, "/") {
+			path = "/var/run/netns/" + path
+		}
+		var err error
+		mgr, err = linuxroute.NewIPRouteManagerAtPath(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	c := linuxroute.NewController(mgr, &linuxroute.FileStore{Path: opts.store})
+	c.RuleManager = linuxroute.IPRuleManager{Handle: mgr.Handle}
+	c.NexthopManager = mgr.NexthopManager()
+	c.AddressManager = linuxroute.IPAddressManager{Handle: mgr.Handle}
+	c.NeighborManager = linuxroute.IPNeighborManager{Handle: mgr.Handle}
+	c.LinkManager = linuxroute.IPLinkManager{Handle: mgr.Handle}
+	c.Force = opts.force
+	c.MaxDeletes = opts.maxDeletes
+	c.MaxDeletePercent = opts.maxDeletePercent
+	c.RefuseEmptyDesired = opts.refuseEmpty
+	c.CheckGateways = opts.checkGateways
+	c.ApplyStrategy = linuxroute.ApplyStrategy(opts.strategy)
+	switch opts.identity {
+	case "dst-table-metric":
+		c.Identity = linuxroute.DstTableMetricIdentity
+	case "kernel":
+		c.Identity = linuxroute.KernelIdentity
+	}
+	for _, p := range strings.Split(opts.protect, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			c.ProtectedRoutes = append(c.ProtectedRoutes, p)
+		}
+	}
+	return c, mgr.Close, nil
+}
+
+func loadSnapshot(path string) (linuxroute.Snapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return linuxroute.Snapshot{}, err
+	}
+	if trimmed := strings.TrimSpace(string(b)); trimmed != "" && trimmed[0] != '[' && trimmed[0] != '{' {
+		routes, err := linuxroute.ParseIPRouteScript(strings.NewReader(string(b)))
+		if err != nil {
+			return linuxroute.Snapshot{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return linuxroute.Snapshot{Routes: routes}, nil
+	}
+
+	snap, err := linuxroute.FileStore{Path: path}.LoadSnapshot()
+	if err != nil {
+		return linuxroute.Snapshot{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return snap, nil
+}
+
+func oneArg(args []string, what string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected exactly one %s argument", what)
+	}
+	return args[0], nil
+}
+
+// cmdDiff prints the plan of apply --dry-run: it goes through the same
+// PlanSnapshot/PlanLive path, so every section and guard is included.
+func cmdDiff(ctx context.Context, opts options, args []string, out io.Writer) error {
+	opts.dryRun = true
+	return cmdApply(ctx, opts, args, out)
+}
+
+func cmdApply(ctx context.Context, opts options, args []string, out io.Writer) error {
+	path, err := oneArg(args, "desired file")
+	if err != nil {
+		return err
+	}
+	desired, err := loadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	c, closeFn, err := newController(opts)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	// --dry-run plans exactly like apply, guards included, but changes nothing.
+	if opts.live {
+		reconcile := c.ReconcileLive
+		if opts.dryRun {
+			reconcile = c.PlanLive
+		}
+		rep, err := reconcile(ctx, desired.Routes)
+		if perr := printDrift(out, opts.output, rep); perr != nil && err == nil {
+			err = perr
+		}
+		return err
+	}
+
+	reconcile := c.ReconcileSnapshot
+	if opts.dryRun {
+		reconcile = c.PlanSnapshot
+	}
+	res, err := reconcile(ctx, desired)
+	if perr := printResult(out, opts.output, res); perr != nil && err == nil {
+		err = perr
+	}
+	return err
+}
+
+func cmdShow(ctx context.Context, opts options, out io.Writer) error {
+	c, closeFn, err := newController(opts)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	routes, err := c.Manager.List(ctx)
+	if err != nil {
+		return err
+	}
+	switch opts.output {
+	case "json":
+		return writeJSON(out, routes)
+	case "ip":
+		for _, r := range routes {
+			fmt.Fprintln(out, r.IPRouteString())
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, r := range routes {
+		k, _ := r.Key()
+		fmt.Fprintln(tw, k)
+	}
+	return tw.Flush()
+}
+
+func cmdExport(opts options, args []string, out io.Writer) error {
+	if len(args) > 1 {
+		return errors.New("expected at most one file argument")
+	}
+	snap, err := loadSnapshot(opts.store)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return saveSnapshot(args[0], snap)
+	}
+	if onlyRoutes(snap) {
+		if snap.Routes == nil {
+			snap.Routes = []linuxroute.Route{}
+		}
+		return writeJSON(out, snap.Routes)
+	}
+	return writeJSON(out, snap)
+}
+
+func cmdImport(opts options, args []string) error {
+	path, err := oneArg(args, "file")
+	if err != nil {
+		return err
+	}
+	if opts.ipJSON {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		routes, err := linuxroute.DecodeIPRouteJSON(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return saveSnapshot(opts.store, linuxroute.Snapshot{Routes: routes})
+	}
+	snap, err := loadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	return saveSnapshot(opts.store, snap)
+}
+
+func saveSnapshot(path string, snap linuxroute.Snapshot) error {
+	return linuxroute.FileStore{Path: path}.SaveSnapshot(snap)
+}
+
+// onlyRoutes reports whether snap is written as a plain route array, like FileStore does.
+func onlyRoutes(snap linuxroute.Snapshot) bool {
+	return len(snap.Rules) == 0 && len(snap.Nexthops) == 0 &&
+		len(snap.Addresses) == 0 && len(snap.Neighbors) == 0 && len(snap.Links) == 0
+}
+
+func printDiff(out io.Writer, format string, diff linuxroute.DiffResult) error {
+	switch format {
+	case "json":
+		return writeJSON(out, diff)
+	case "ip":
+		for _, cmd := range diff.IPRouteCommands() {
+			fmt.Fprintln(out, cmd)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, r := range diff.ToDel {
+		k, _ := r.Key()
+		fmt.Fprintf(tw, "DEL\t%s\n", k)
+	}
+	for _, rc := range diff.ToReplace {
+		k, _ := rc.New.Key()
+		fmt.Fprintf(tw, "REPLACE\t%s\t%s\n", k, strings.Join(rc.Changed, ","))
+	}
+	for _, r := range diff.ToAdd {
+		k, _ := r.Key()
+		fmt.Fprintf(tw, "ADD\t%s\n", k)
+	}
+	fmt.Fprintf(tw, "# ToDel=%d ToReplace=%d ToAdd=%d Unchanged=%d\n",
+		len(diff.ToDel), len(diff.ToReplace), len(diff.ToAdd), len(diff.Unchanged))
+	return tw.Flush()
+}
+
+// printResult prints the route plan of a snapshot reconcile; the table
+// format also sums up the other sections that change.
+func printResult(out io.Writer, format string, res linuxroute.ReconcileResult) error {
+	if err := printDiff(out, format, res.Diff); err != nil || format != "table" {
+		return err
+	}
+	if r := res.Rules; len(r.ToDel) > 0 || len(r.ToAdd) > 0 {
+		fmt.Fprintf(out, "# rules: ToDel=%d ToAdd=%d Unchanged=%d\n", len(r.ToDel), len(r.ToAdd), len(r.Unchanged))
+	}
+	printSection(out, "nexthops", res.Nexthops)
+	printSection(out, "addresses", res.Addresses)
+	printSection(out, "neighbors", res.Neighbors)
+	printSection(out, "links", res.Links)
+	return nil
+}
+
+func printSection[T any](out io.Writer, name string, d linuxroute.SectionDiff[T]) {
+	if len(d.ToDel) > 0 || len(d.ToReplace) > 0 || len(d.ToAdd) > 0 {
+		fmt.Fprintf(out, "# %s: ToDel=%d ToReplace=%d ToAdd=%d Unchanged=%d\n",
+			name, len(d.ToDel), len(d.ToReplace), len(d.ToAdd), len(d.Unchanged))
+	}
+}
+
+func printDrift(out io.Writer, format string, rep linuxroute.DriftReport) error {
+	switch format {
+	case "json":
+		return writeJSON(out, rep)
+	case "ip":
+		diff := linuxroute.DiffResult{ToDel: rep.Extra, ToReplace: rep.Modified, ToAdd: rep.Missing}
+		for _, cmd := range diff.IPRouteCommands() {
+			fmt.Fprintln(out, cmd)
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, r := range rep.Extra {
+		k, _ := r.Key()
+		fmt.Fprintf(tw, "EXTRA\t%s\n", k)
+	}
+	for _, rc := range rep.Modified {
+		k, _ := rc.New.Key()
+		fmt.Fprintf(tw, "MODIFIED\t%s\n", k)
+	}
+	for _, r := range rep.Missing {
+		k, _ := r.Key()
+		fmt.Fprintf(tw, "MISSING\t%s\n", k)
+	}
+	fmt.Fprintf(tw, "# Missing=%d Extra=%d Modified=%d\n", len(rep.Missing), len(rep.Extra), len(rep.Modified))
+	return tw.Flush()
+}
+
+func writeJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	linuxroute "github.com/jursonmo/linux_route"
+)
+
+func TestRunFlags(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		args []string
+		err  string
+	}{
+		{cmd: "bogus", err: `unknown command "bogus"`},
+		{cmd: "diff", args: []string{"--output", "yaml", "x.json"}, err: `unsupported --output "yaml"`},
+		{cmd: "apply", args: []string{"--identity", "dst"}, err: `unsupported --identity "dst"`},
+		{cmd: "apply", args: []string{"--no-such-flag"}, err: "flag provided but not defined"},
+		{cmd: "diff", err: "expected exactly one desired file argument"},
+		{cmd: "import", args: []string{"a", "b"}, err: "expected exactly one file argument"},
+		{cmd: "export", args: []string{"a", "b"}, err: "expected at most one file argument"},
+		{cmd: "help"},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		err := run(context.Background(), tc.cmd, tc.args, &out)
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("run(%s %v) error: %v", tc.cmd, tc.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("run(%s %v) error = %v, want %q", tc.cmd, tc.args, err, tc.err)
+		}
+	}
+}
+
+func TestRunImportExport(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := filepath.Join(dir, "baseline.json")
+
+	cases := []struct {
+		name string
+		file string
+		want linuxroute.Snapshot
+	}{
+		{
+			name: "snapshot keeps every section",
+			file: `{"routes": [{"dst": "10.1.0.0/16", "nhid": 1}],
+				"rules": [{"priority": 100, "table": 100}],
+				"nexthops": [{"id": 1, "gateway": "10.0.0.1", "device": "eth0"}],
+				"addresses": [{"ip": "10.0.0.5/24", "device": "eth0"}],
+				"neighbors": [{"ip": "10.0.0.1", "lladdr": "52:54:00:00:00:01", "device": "eth0"}],
+				"links": [{"name": "br0", "kind": "bridge"}]}`,
+			want: linuxroute.Snapshot{
+				Routes: make([]linuxroute.Route, 1), Rules: make([]linuxroute.Rule, 1),
+				Nexthops: make([]linuxroute.NexthopObject, 1), Addresses: make([]linuxroute.Address, 1),
+				Neighbors: make([]linuxroute.Neighbor, 1), Links: make([]linuxroute.Link, 1),
+			},
+		},
+		{
+			name: "route array",
+			file: `[{"dst": "default", "gateway": "10.0.0.1"}, {"dst": "10.2.0.0/16", "device": "eth0"}]`,
+			want: linuxroute.Snapshot{Routes: make([]linuxroute.Route, 2)},
+		},
+		{
+			name: "ip route script",
+			file: "# comment\n10.3.0.0/16 via 10.0.0.1 dev eth0\n",
+			want: linuxroute.Snapshot{Routes: make([]linuxroute.Route, 1)},
+		},
+	}
+	for _, tc := range cases {
+		in := filepath.Join(dir, "in")
+		if err := os.WriteFile(in, []byte(tc.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := run(ctx, "import", []string{"--store", store, in}, nil); err != nil {
+			t.Fatalf("%s: import error: %v", tc.name, err)
+		}
+
+		exported := filepath.Join(dir, "out.json")
+		if err := run(ctx, "export", []string{"--store", store, exported}, nil); err != nil {
+			t.Fatalf("%s: export error: %v", tc.name, err)
+		}
+		var stdout bytes.Buffer
+		if err := run(ctx, "export", []string{"--store", store}, &stdout); err != nil {
+			t.Fatalf("%s: export to stdout error: %v", tc.name, err)
+		}
+		file, _ := os.ReadFile(exported)
+		if stdout.String() != string(file) {
+			t.Fatalf("%s: stdout export %s differs from file export %s", tc.name, stdout.String(), file)
+		}
+
+		got, err := loadSnapshot(exported)
+		if err != nil {
+			t.Fatalf("%s: loadSnapshot error: %v", tc.name, err)
+		}
+		if len(got.Routes) != len(tc.want.Routes) || len(got.Rules) != len(tc.want.Rules) ||
+			len(got.Nexthops) != len(tc.want.Nexthops) || len(got.Addresses) != len(tc.want.Addresses) ||
+			len(got.Neighbors) != len(tc.want.Neighbors) || len(got.Links) != len(tc.want.Links) {
+			t.Fatalf("%s: exported %+v", tc.name, got)
+		}
+		if onlyRoutes(tc.want) != strings.HasPrefix(string(file), "[") {
+			t.Fatalf("%s: unexpected export format %s", tc.name, file)
+		}
+	}
+
+	// A missing baseline exports as an empty route array.
+	var stdout bytes.Buffer
+	if err := run(ctx, "export", []string{"--store", filepath.Join(dir, "none.json")}, &stdout); err != nil || strings.TrimSpace(stdout.String()) != "[]" {
+		t.Fatalf("export of a missing baseline = %q, %v", stdout.String(), err)
+	}
+}
+
+func TestRunApplyDryRun(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := filepath.Join(dir, "baseline.json")
+	if err := os.WriteFile(store, []byte(`[{"dst": "10.1.0.0/16", "device": "eth0"}, {"dst": "10.2.0.0/16", "device": "eth0"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(store)
+	desired := filepath.Join(dir, "desired.json")
+	if err := os.WriteFile(desired, []byte(`{"routes": [{"dst": "10.3.0.0/16", "device": "eth0"}],
+		"nexthops": [{"id": 1, "blackhole": true}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := run(ctx, "apply", []string{"--dry-run", "--store", store, desired}, &out); err != nil {
+		t.Fatalf("apply --dry-run error: %v", err)
+	}
+	for _, want := range []string{"DEL", "dst=10.1.0.0/16", "ADD", "dst=10.3.0.0/16", "# nexthops: ToDel=0 ToReplace=0 ToAdd=1"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("apply --dry-run output %q lacks %q", out.String(), want)
+		}
+	}
+
+	// The safety guards are checked like a real apply.
+	out.Reset()
+	err := run(ctx, "apply", []string{"--dry-run", "--max-deletes", "1", "--store", store, desired}, &out)
+	var guardErr *linuxroute.GuardError
+	if !errors.As(err, &guardErr) {
+		t.Fatalf("apply --dry-run --max-deletes 1 error = %v, want a guard error", err)
+	}
+	if !strings.Contains(out.String(), "ToDel=2") {
+		t.Fatalf("refused plan not printed: %q", out.String())
+	}
+
+	if after, _ := os.ReadFile(store); !bytes.Equal(before, after) {
+		t.Fatalf("dry run changed the baseline: %s", after)
+	}
+}
+
+func TestRunDiffMatchesDryRun(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := filepath.Join(dir, "baseline.json")
+	if err := os.WriteFile(store, []byte(`[{"dst": "10.1.0.0/16", "device": "eth0"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	desired := filepath.Join(dir, "desired.json")
+	if err := os.WriteFile(desired, []byte(`{"routes": [{"dst": "10.3.0.0/16", "device": "eth0"}],
+		"nexthops": [{"id": 1, "blackhole": true}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []string{"table", "ip", "json"} {
+		var diffOut, dryOut bytes.Buffer
+		if err := run(ctx, "diff", []string{"--output", output, "--store", store, desired}, &diffOut); err != nil {
+			t.Fatalf("diff --output %s error: %v", output, err)
+		}
+		if err := run(ctx, "apply", []string{"--dry-run", "--output", output, "--store", store, desired}, &dryOut); err != nil {
+			t.Fatalf("apply --dry-run --output %s error: %v", output, err)
+		}
+		if diffOut.String() != dryOut.String() {
+			t.Fatalf("diff --output %s = %q, apply --dry-run = %q", output, diffOut.String(), dryOut.String())
+		}
+	}
+
+	// diff checks the same guards as apply.
+	var out bytes.Buffer
+	err := run(ctx, "diff", []string{"--protect", "10.1.0.0/16", "--store", store, desired}, &out)
+	var guardErr *linuxroute.GuardError
+	if !errors.As(err, &guardErr) {
+		t.Fatalf("diff --protect error = %v, want a guard error", err)
+	}
+	if !strings.Contains(out.String(), "ToDel=1") {
+		t.Fatalf("refused plan not printed: %q", out.String())
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 )
 
 // Controller ties together a RouteManager and a RouteStore
@@ -12,10 +14,100 @@ import (
 type Controller struct {
 	Manager RouteManager
 	Store   RouteStore
+
+	// RuleManager manages policy routing rules for ReconcileSnapshot.
+	// It is optional when snapshots carry no rules.
+	RuleManager RuleManager
+
+	// NexthopManager manages nexthop objects for ReconcileSnapshot.
+	// It is optional when snapshots carry no nexthop objects.
+	NexthopManager NexthopManager
+
+	// AddressManager manages interface addresses for ReconcileSnapshot.
+	// It is optional when snapshots carry no addresses.
+	AddressManager AddressManager
+
+	// NeighborManager manages static neighbor entries for ReconcileSnapshot.
+	// It is optional when snapshots carry no neighbors.
+	NeighborManager NeighborManager
+
+	// LinkManager manages network devices for ReconcileSnapshot.
+	// It is optional when snapshots carry no links.
+	LinkManager LinkManager
+
+	// VRFManager, when set, makes reconciles check that the VRF devices used by
+	// routes exist before applying anything, creating the missing ones listed in VRFs.
+	VRFManager VRFManager
+	// VRFs are VRF devices to create when missing; an existing device with
+	// another table is an error.
+	VRFs []VRF
+
+	// Identity, when set, turns a deleted+added pair with the same identity
+	// into an in-place replacement (see DiffRoutesWithIdentity), so e.g. a
+	// default route next-hop change never leaves a window without a default route.
+	// Built-ins are DstTableMetricIdentity and KernelIdentity.
+	Identity IdentityFunc
+
+	// OwnerProto, when set, marks routes created by this controller with a
+	// route protocol (e.g. a custom number registered in /etc/iproute2/rt_protos).
+	// Desired routes without a proto are stamped with it, and live routes with a
+	// different proto are never treated as ours (see DetectDrift, RebuildBaseline).
+	OwnerProto string
+
+	// Names resolves iproute2 names in OwnerProto and desired routes, nexthop
+	// objects and addresses before they are normalized; nil means DefaultNameResolver().
+	Names *NameResolver
+
+	// CheckGateways, when set, refuses a plan adding a route whose gateway is not
+	// reachable through an on-link route ("10.1.0.0/24 dev eth1 scope link") of the
+	// desired set or, in ReconcileSnapshot, the prefix of a desired address.
+	// Only set it when connected routes are managed too; routes are always ordered
+	// so that on-link routes are added before the routes using them as a gateway.
+	CheckGateways bool
+
+	// ApplyStrategy orders the deletes of a reconcile against its adds; the zero
+	// value is DeleteFirst. AddFirst and ReplaceWhenPossible only delete first the
+	// routes in the way of an added one, so traffic keeps flowing meanwhile.
+	ApplyStrategy ApplyStrategy
+
+	// Transactional, when set, makes Reconcile all-or-nothing: if any operation
+	// fails, the already applied ones are undone in reverse order, the old baseline
+	// is kept and a *TransactionError is returned.
+	Transactional bool
+
+	// Safety guards, checked before any RouteManager call; a violating plan
+	// is refused with a *GuardError unless Force is set.
+	//
+	// MaxDeletes limits the number of deleted routes (0 = unlimited).
+	MaxDeletes int
+	// MaxDeletePercent limits deleted routes as a percentage of the baseline (0 = unlimited).
+	MaxDeletePercent float64
+	// ProtectedRoutes are never deleted: "default" or CIDR prefixes covering the dst.
+	ProtectedRoutes []string
+	// RefuseEmptyDesired refuses an empty desired set while the baseline is not empty.
+	RefuseEmptyDesired bool
+	// Force bypasses the safety guards.
+	Force bool
+
+	// WatchDebounce is how long Run waits for route/link events to settle
+	// before repairing; 0 means 1s.
+	WatchDebounce time.Duration
+	// OnRepairError, when set, receives repair errors from Run.
+	OnRepairError func(error)
 }
 
 type ReconcileResult struct {
 	Diff DiffResult
+	// Rules is the rule plan; only set by ReconcileSnapshot.
+	Rules RuleDiffResult
+	// Nexthops is the nexthop object plan; only set by ReconcileSnapshot.
+	Nexthops NexthopDiffResult
+	// Addresses is the interface address plan; only set by ReconcileSnapshot.
+	Addresses AddressDiffResult
+	// Neighbors is the neighbor entry plan; only set by ReconcileSnapshot.
+	Neighbors NeighborDiffResult
+	// Links is the device plan; only set by ReconcileSnapshot.
+	Links LinkDiffResult
 }
 
 func NewController(manager RouteManager, store RouteStore) *Controller {
@@ -31,8 +123,13 @@ func NewControllerWithFileStore(path string) *Controller {
 
 // Reconcile compares desiredRoutes with previously saved routes, then:
 // - deletes routes that should no longer exist
+// - replaces routes in place whose identity is unchanged (only if Identity is set)
 // - adds routes that are missing
+// - orders the above by ApplyStrategy and gateway dependency (see planOps)
 // - saves desiredRoutes as the new baseline (only if apply succeeded)
+//
+// By default failed operations are skipped and the baseline records what actually
+// succeeded; see Transactional for all-or-nothing behavior.
 func (c Controller) Reconcile(ctx context.Context, desiredRoutes []Route) (ReconcileResult, error) {
 	if c.Manager == nil {
 		return ReconcileResult{}, fmt.Errorf("manager is nil")
@@ -41,16 +138,53 @@ func (c Controller) Reconcile(ctx context.Context, desiredRoutes []Route) (Recon
 		return ReconcileResult{}, fmt.Errorf("store is nil")
 	}
 
+	diff, ops, err := c.plan(ctx, desiredRoutes, routeDeps{})
+	if err != nil {
+		return ReconcileResult{}, err
+	}
+	if err := c.ensureVRFs(ctx, diff); err != nil {
+		return ReconcileResult{Diff: diff}, err
+	}
+	return ReconcileResult{Diff: diff}, c.applyDiff(ctx, diff, ops)
+}
+
+// plan loads the baseline and computes the diff to desiredRoutes and its ordered ops,
+// enforcing the safety guards before anything is applied. Routes in a VRF table
+// are compared by VRF name, whether they give the table or the name.
+func (c Controller) plan(ctx context.Context, desiredRoutes []Route, deps routeDeps) (DiffResult, []RouteOp, error) {
 	oldRoutes, err := c.Store.Load()
 	if err != nil {
-		return ReconcileResult{}, fmt.Errorf("load old routes: %w", err)
+		return DiffResult{}, nil, fmt.Errorf("load old routes: %w", err)
 	}
 
-	diff, err := DiffRoutes(oldRoutes, desiredRoutes)
+	desiredRoutes, err = c.stamp(desiredRoutes)
 	if err != nil {
-		return ReconcileResult{}, err
+		return DiffResult{}, nil, err
+	}
+	vrfs, err := c.vrfNames(ctx)
+	if err != nil {
+		return DiffResult{}, nil, err
+	}
+	oldRoutes, desiredRoutes = inVRFs(oldRoutes, vrfs), inVRFs(desiredRoutes, vrfs)
+
+	diff, err := DiffRoutesWithIdentity(oldRoutes, desiredRoutes, c.Identity)
+	if err != nil {
+		return DiffResult{}, nil, err
+	}
+
+	if err := c.checkDeletes(len(oldRoutes), len(desiredRoutes), diff.ToDel); err != nil {
+		return diff, nil, err
 	}
+	deps.checkGateways, deps.strategy = c.CheckGateways, c.ApplyStrategy
+	ops, err := planOps(diff, deps)
+	if err != nil {
+		return diff, nil, err
+	}
+	return diff, ops, nil
+}
 
+// applyDiff applies the ops of diff through Manager and saves what succeeded as the new baseline.
+func (c Controller) applyDiff(ctx context.Context, diff DiffResult, ops []RouteOp) error {
 	// Track the actual set applied to RouteManager, so Store stays consistent
 	// with what really succeeded.
 	applied := make(map[string]Route, len(diff.Unchanged)+len(diff.ToDel)+len(diff.ToAdd))
@@ -62,24 +196,34 @@ func (c Controller) Reconcile(ctx context.Context, desiredRoutes []Route) (Recon
 		k, _ := r.Key()
 		applied[k] = r
 	}
+	for _, rc := range diff.ToReplace {
+		k, _ := rc.Old.Key()
+		applied[k] = rc.Old
+	}
 
-	// Deletes first to avoid "file exists" / conflicts.
+	done, err := c.applyOps(ctx, ops)
+	var txErr *TransactionError
+	if errors.As(err, &txErr) {
+		return err
+	}
 	var applyErrs []error
-	for _, r := range diff.ToDel {
-		if err := c.Manager.Delete(ctx, r); err != nil {
-			applyErrs = append(applyErrs, fmt.Errorf("delete route (%+v): %w", r, err))
-			continue
-		}
-		k, _ := r.Key()
-		delete(applied, k)
+	if err != nil {
+		applyErrs = append(applyErrs, err)
 	}
-	for _, r := range diff.ToAdd {
-		if err := c.Manager.Add(ctx, r); err != nil {
-			applyErrs = append(applyErrs, fmt.Errorf("add route (%+v): %w", r, err))
-			continue
+	for _, op := range done {
+		switch op.Kind {
+		case OpDelete:
+			k, _ := op.Route.Key()
+			delete(applied, k)
+		case OpReplace:
+			k, _ := op.Old.Key()
+			delete(applied, k)
+			k, _ = op.Route.Key()
+			applied[k] = op.Route
+		case OpAdd:
+			k, _ := op.Route.Key()
+			applied[k] = op.Route
 		}
-		k, _ := r.Key()
-		applied[k] = r
 	}
 
 	appliedRoutes := make([]Route, 0, len(applied))
@@ -96,5 +240,100 @@ func (c Controller) Reconcile(ctx context.Context, desiredRoutes []Route) (Recon
 		applyErrs = append(applyErrs, fmt.Errorf("save applied routes: %w", err))
 	}
 
-	return ReconcileResult{Diff: diff}, errors.Join(applyErrs...)
+	return errors.Join(applyErrs...)
+}
+
+// replace updates rc.Old to rc.New, atomically if the manager supports it.
+func (c Controller) replace(ctx context.Context, rc RouteChange) error {
+	if rp, ok := c.Manager.(RouteReplacer); ok {
+		return rp.Replace(ctx, rc.Old, rc.New)
+	}
+	if err := c.Manager.Delete(ctx, rc.Old); err != nil {
+		return err
+	}
+	return c.Manager.Add(ctx, rc.New)
+}
+
+// RebuildBaseline recovers a lost baseline from the system: it lists live routes,
+// keeps the ones carrying OwnerProto and saves them to Store.
+// It refuses to run without OwnerProto, since other routes cannot be told apart.
+func (c Controller) RebuildBaseline(ctx context.Context) ([]Route, error) {
+	if c.Manager == nil {
+		return nil, fmt.Errorf("manager is nil")
+	}
+	if c.Store == nil {
+		return nil, fmt.Errorf("store is nil")
+	}
+	if c.ownerProto() == "" {
+		return nil, fmt.Errorf("owner proto is required to rebuild the baseline")
+	}
+
+	live, err := c.Manager.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list live routes: %w", err)
+	}
+	live, err = normalizeRoutes(live)
+	if err != nil {
+		return nil, fmt.Errorf("live routes: %w", err)
+	}
+
+	owned := make([]Route, 0, len(live))
+	for _, r := range live {
+		if c.ownedByProto(r) {
+			owned = append(owned, r)
+		}
+	}
+	sortRoutes(owned)
+
+	if err := c.Store.Save(owned); err != nil {
+		return nil, fmt.Errorf("save rebuilt routes: %w", err)
+	}
+	return owned, nil
+}
+
+// names returns the resolver for iproute2 names.
+func (c Controller) names() *NameResolver {
+	if c.Names != nil {
+		return c.Names
+	}
+	return DefaultNameResolver()
+}
+
+// ownerProto returns OwnerProto in the numeric form Normalize uses,
+// so "bird" and "12" are the same owner.
+func (c Controller) ownerProto() string {
+	p := strings.ToLower(strings.TrimSpace(c.OwnerProto))
+	if n, err := canonicalName(p, c.names().Proto); err == nil {
+		return n
+	}
+	return p
+}
+
+// stamp resolves the names of routes and sets OwnerProto on those that do not
+// specify a proto. A route with a different explicit proto is rejected, since
+// we would not own it.
+func (c Controller) stamp(routes []Route) ([]Route, error) {
+	names := c.names()
+	owner := c.ownerProto()
+	out := make([]Route, 0, len(routes))
+	for i, r := range routes {
+		r, err := names.ResolveRoute(r)
+		if err != nil {
+			return nil, fmt.Errorf("desiredRoutes[%d]: %w", i, err)
+		}
+		switch {
+		case owner == "", r.Proto == owner:
+		case r.Proto == "":
+			r.Proto = owner
+		default:
+			return nil, fmt.Errorf("desiredRoutes[%d]: proto %q conflicts with owner proto %q", i, routes[i].Proto, owner)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// ownedByProto reports whether a normalized live route carries OwnerProto.
+func (c Controller) ownedByProto(r Route) bool {
+	return r.Proto == c.ownerProto()
 }
@@ -2,16 +2,22 @@ package linuxroute
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type fakeManager struct {
-	ops   []string
-	addE  error
-	delE  error
-	list  []Route
-	listE error
+	ops  []string
+	addE error
+	// addFailDst makes Add fail with addE only for routes with this dst.
+	addFailDst string
+	delE       error
+	list       []Route
+	listE      error
 }
 
 func (m *fakeManager) List(ctx context.Context) ([]Route, error) {
@@ -24,6 +30,9 @@ func (m *fakeManager) List(ctx context.Context) ([]Route, error) {
 func (m *fakeManager) Add(ctx context.Context, r Route) error {
 	k, _ := r.Key()
 	m.ops = append(m.ops, "add "+k)
+	if m.addFailDst != "" && r.Dst != m.addFailDst {
+		return nil
+	}
 	return m.addE
 }
 
@@ -33,6 +42,16 @@ func (m *fakeManager) Delete(ctx context.Context, r Route) error {
 	return m.delE
 }
 
+type fakeReplaceManager struct {
+	fakeManager
+}
+
+func (m *fakeReplaceManager) Replace(ctx context.Context, old, new Route) error {
+	k, _ := new.Key()
+	m.ops = append(m.ops, "replace "+k)
+	return nil
+}
+
 func TestControllerReconcile_OrderAndSave(t *testing.T) {
 	ctx := context.Background()
 
@@ -72,3 +91,739 @@ func TestControllerReconcile_OrderAndSave(t *testing.T) {
 		t.Fatalf("store not updated, got=%+v", after)
 	}
 }
+
+func TestControllerReconcile_Replace(t *testing.T) {
+	ctx := context.Background()
+
+	store := &MemoryStore{}
+	if err := store.Save([]Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+	}); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	mgr := &fakeReplaceManager{}
+	c := Controller{Manager: mgr, Store: store, Identity: DstTableMetricIdentity}
+
+	got, err := c.Reconcile(ctx, []Route{
+		{Dst: "default", Gateway: "10.0.0.254", Device: "eth0"},
+	})
+	if err != nil {
+		t.Fatalf("Reconcile() error: %v", err)
+	}
+	if len(got.Diff.ToReplace) != 1 {
+		t.Fatalf("unexpected diff: %+v", got.Diff)
+	}
+	if len(mgr.ops) != 1 || !strings.HasPrefix(mgr.ops[0], "replace ") {
+		t.Fatalf("expected a single replace, got ops=%v", mgr.ops)
+	}
+
+	after, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load() error: %v", err)
+	}
+	if len(after) != 1 || after[0].Gateway != "10.0.0.254" {
+		t.Fatalf("store not updated, got=%+v", after)
+	}
+}
+
+func TestControllerReconcile_GatewayOrder(t *testing.T) {
+	ctx := context.Background()
+
+	store := &MemoryStore{}
+	if err := store.Save([]Route{
+		{Dst: "10.0.0.0/24", Device: "eth0", Scope: "link"},
+		{Dst: "10.5.0.0/16", Gateway: "10.0.0.1"},
+	}); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	mgr := &fakeManager{}
+	c := Controller{Manager: mgr, Store: store, CheckGateways: true}
+
+	// Key order would delete the on-link route first and add the route via
+	// 10.9.0.1 before the on-link route reaching it.
+	_, err := c.Reconcile(ctx, []Route{
+		{Dst: "10.0.0.0/16", Gateway: "10.9.0.1"},
+		{Dst: "10.9.0.0/24", Device: "eth1", Scope: "link"},
+		{Dst: "2001:db8::/32", Gateway: "fe80::1", Device: "eth1"},
+	})
+	if err != nil {
+		t.Fatalf("Reconcile() error: %v", err)
+	}
+	want := []string{"del dst=10.5.0.0/16", "del dst=10.0.0.0/24", "add dst=10.9.0.0/24", "add dst=10.0.0.0/16", "add dst=2001:db8::/32"}
+	if len(mgr.ops) != len(want) {
+		t.Fatalf("unexpected ops=%v", mgr.ops)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(mgr.ops[i], w) {
+			t.Fatalf("op[%d]=%q, want prefix %q (ops=%v)", i, mgr.ops[i], w, mgr.ops)
+		}
+	}
+
+	for _, desired := range [][]Route{
+		// Device routes without scope link do not reach IPv4 gateways.
+		{{Dst: "10.9.0.0/24", Device: "eth1"}, {Dst: "10.0.0.0/16", Gateway: "10.9.0.1"}},
+		// The on-link route is pinned to another device.
+		{{Dst: "10.9.0.0/24", Device: "eth1", Scope: "link"}, {Dst: "10.0.0.0/16", Gateway: "10.9.0.1", Device: "eth0"}},
+		{{Dst: "default", Nexthops: []Nexthop{{Gateway: "10.9.0.1"}, {Gateway: "10.8.0.1"}}}, {Dst: "10.9.0.0/24", Device: "eth1", Scope: "link"}},
+	} {
+		mgr.ops, c.Store = nil, &MemoryStore{}
+		if _, err := c.Reconcile(ctx, desired); err == nil || !strings.Contains(err.Error(), "not reachable") {
+			t.Fatalf("Reconcile(%+v) error = %v, want an unreachable gateway", desired, err)
+		}
+		if len(mgr.ops) != 0 {
+			t.Fatalf("expected nothing applied, got ops=%v", mgr.ops)
+		}
+	}
+
+	// The prefix of a snapshot address reaches gateways too.
+	mgr.ops = nil
+	c = withSectionManagers(c, &mgr.ops)
+	if _, err := c.ReconcileSnapshot(ctx, Snapshot{
+		Routes:    []Route{{Dst: "10.0.0.0/16", Gateway: "10.9.0.1", Device: "eth1"}},
+		Addresses: []Address{{IP: "10.9.0.5/24", Device: "eth1"}},
+	}); err != nil {
+		t.Fatalf("ReconcileSnapshot() error: %v", err)
+	}
+}
+
+func TestControllerReconcile_ApplyStrategy(t *testing.T) {
+	ctx := context.Background()
+
+	old := []Route{
+		{Dst: "10.1.0.0/24", Gateway: "10.0.0.1", Device: "eth0"},
+		{Dst: "10.2.0.0/24", Gateway: "10.0.0.1", Device: "eth0"},
+	}
+	desired := []Route{
+		{Dst: "10.1.0.0/24", Gateway: "10.0.0.2", Device: "eth0"},             // takes the slot of an old route
+		{Dst: "10.2.0.0/24", Gateway: "10.0.0.1", Device: "eth0", Metric: 10}, // a new slot
+		{Dst: "10.3.0.0/24", Gateway: "10.0.0.1", Device: "eth0"},
+	}
+
+	cases := []struct {
+		strategy ApplyStrategy
+		want     []string
+	}{
+		{"", []string{"del dst=10.1.0.0/24", "del dst=10.2.0.0/24", "add dst=10.1.0.0/24", "add dst=10.2.0.0/24", "add dst=10.3.0.0/24"}},
+		{AddFirst, []string{"add dst=10.2.0.0/24", "add dst=10.3.0.0/24", "del dst=10.1.0.0/24", "add dst=10.1.0.0/24", "del dst=10.2.0.0/24"}},
+		{ReplaceWhenPossible, []string{"replace dst=10.1.0.0/24|gw=10.0.0.2", "add dst=10.2.0.0/24", "add dst=10.3.0.0/24", "del dst=10.2.0.0/24"}},
+	}
+	for _, tc := range cases {
+		store := &MemoryStore{}
+		if err := store.Save(old); err != nil {
+			t.Fatalf("seed store: %v", err)
+		}
+		mgr := &fakeReplaceManager{}
+		c := Controller{Manager: mgr, Store: store, ApplyStrategy: tc.strategy}
+		if _, err := c.Reconcile(ctx, desired); err != nil {
+			t.Fatalf("%q: Reconcile() error: %v", tc.strategy, err)
+		}
+		if len(mgr.ops) != len(tc.want) {
+			t.Fatalf("%q: unexpected ops=%v", tc.strategy, mgr.ops)
+		}
+		for i, w := range tc.want {
+			if !strings.HasPrefix(mgr.ops[i], w) {
+				t.Fatalf("%q: op[%d]=%q, want prefix %q (ops=%v)", tc.strategy, i, mgr.ops[i], w, mgr.ops)
+			}
+		}
+		saved, _ := store.Load()
+		if len(saved) != len(desired) {
+			t.Fatalf("%q: store not updated, got=%+v", tc.strategy, saved)
+		}
+	}
+
+	c := Controller{Manager: &fakeManager{}, Store: &MemoryStore{}, ApplyStrategy: "break-before-make"}
+	if _, err := c.Reconcile(ctx, desired); err == nil {
+		t.Fatalf("expected an unknown strategy to be rejected")
+	}
+}
+
+func TestControllerDetectDriftAndRepair(t *testing.T) {
+	ctx := context.Background()
+
+	store := &MemoryStore{}
+	if err := store.Save([]Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0"},
+		{Dst: "10.20.0.0/16", Gateway: "10.0.0.3", Device: "eth0"},
+	}); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	mgr := &fakeReplaceManager{fakeManager{list: []Route{
+		// in sync; kernel fills in table/scope/proto
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 254, Scope: "global", Proto: "boot"},
+		// gateway changed by hand
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.9", Device: "eth0", Table: 254, Proto: "boot"},
+		// owned but no longer desired
+		{Dst: "10.20.0.0/16", Gateway: "10.0.0.3", Device: "eth0", Table: 254, Proto: "boot"},
+		// not ours
+		{Dst: "10.0.0.0/24", Device: "eth0", Table: 254, Scope: "link", Proto: "kernel"},
+	}}}
+	c := Controller{Manager: mgr, Store: store}
+
+	desired := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0"},
+		{Dst: "192.168.2.0/24", Gateway: "10.0.0.4", Device: "eth0"},
+	}
+	rep, err := c.DetectDrift(ctx, desired)
+	if err != nil {
+		t.Fatalf("DetectDrift() error: %v", err)
+	}
+	if len(rep.Missing) != 1 || rep.Missing[0].Dst != "192.168.2.0/24" {
+		t.Fatalf("unexpected missing: %+v", rep.Missing)
+	}
+	if len(rep.Extra) != 1 || rep.Extra[0].Dst != "10.20.0.0/16" {
+		t.Fatalf("unexpected extra: %+v", rep.Extra)
+	}
+	if len(rep.Modified) != 1 || rep.Modified[0].Old.Gateway != "10.0.0.9" || rep.Modified[0].New.Gateway != "10.0.0.2" {
+		t.Fatalf("unexpected modified: %+v", rep.Modified)
+	}
+	if len(mgr.ops) != 0 {
+		t.Fatalf("DetectDrift must not change routes, got ops=%v", mgr.ops)
+	}
+
+	if _, err := c.ReconcileLive(ctx, desired); err != nil {
+		t.Fatalf("ReconcileLive() error: %v", err)
+	}
+	if len(mgr.ops) != 3 ||
+		!strings.HasPrefix(mgr.ops[0], "del dst=10.20.0.0/16") ||
+		!strings.HasPrefix(mgr.ops[1], "replace dst=10.10.0.0/16") ||
+		!strings.HasPrefix(mgr.ops[2], "add dst=192.168.2.0/24") {
+		t.Fatalf("unexpected repair ops=%v", mgr.ops)
+	}
+}
+
+func TestControllerOwnerProto(t *testing.T) {
+	ctx := context.Background()
+
+	store := &MemoryStore{}
+	mgr := &fakeManager{list: []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 254, Proto: "dhcp"},
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0", Table: 254, Proto: "200"},
+	}}
+	c := Controller{Manager: mgr, Store: store, OwnerProto: "200"}
+
+	rebuilt, err := c.RebuildBaseline(ctx)
+	if err != nil {
+		t.Fatalf("RebuildBaseline() error: %v", err)
+	}
+	if len(rebuilt) != 1 || rebuilt[0].Dst != "10.10.0.0/16" {
+		t.Fatalf("unexpected rebuilt baseline: %+v", rebuilt)
+	}
+
+	rep, err := c.DetectDrift(ctx, nil)
+	if err != nil {
+		t.Fatalf("DetectDrift() error: %v", err)
+	}
+	if len(rep.Extra) != 1 || rep.Extra[0].Proto != "200" {
+		t.Fatalf("only owned routes may be extra, got %+v", rep.Extra)
+	}
+
+	if _, err := c.Reconcile(ctx, []Route{{Dst: "192.168.2.0/24", Gateway: "10.0.0.4", Device: "eth0"}}); err != nil {
+		t.Fatalf("Reconcile() error: %v", err)
+	}
+	last := mgr.ops[len(mgr.ops)-1]
+	if !strings.HasPrefix(last, "add ") || !strings.HasSuffix(last, "proto=200") {
+		t.Fatalf("added route not stamped, ops=%v", mgr.ops)
+	}
+
+	if _, err := c.Reconcile(ctx, []Route{{Dst: "default", Gateway: "10.0.0.1", Proto: "static"}}); err == nil {
+		t.Fatalf("expected conflicting proto to be rejected")
+	}
+}
+
+func TestControllerReconcile_TransactionalRollback(t *testing.T) {
+	ctx := context.Background()
+
+	baseline := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+	}
+	store := &MemoryStore{}
+	if err := store.Save(baseline); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	addErr := errors.New("boom")
+	mgr := &fakeManager{addE: addErr, addFailDst: "192.168.3.0/24"}
+	c := Controller{Manager: mgr, Store: store, Transactional: true}
+
+	_, err := c.Reconcile(ctx, []Route{
+		{Dst: "192.168.2.0/24", Gateway: "10.0.0.2", Device: "eth0"},
+		{Dst: "192.168.3.0/24", Gateway: "10.0.0.3", Device: "eth0"},
+	})
+	var txErr *TransactionError
+	if !errors.As(err, &txErr) {
+		t.Fatalf("expected *TransactionError, got %v", err)
+	}
+	if !errors.Is(err, addErr) {
+		t.Fatalf("expected error to wrap the add failure, got %v", err)
+	}
+	if txErr.Failed.Kind != OpAdd || txErr.Failed.Route.Dst != "192.168.3.0/24" {
+		t.Fatalf("unexpected failed op: %v", txErr.Failed)
+	}
+	if len(txErr.RolledBack) != 2 ||
+		txErr.RolledBack[0].Kind != OpDelete || txErr.RolledBack[0].Route.Dst != "192.168.2.0/24" ||
+		txErr.RolledBack[1].Kind != OpAdd || txErr.RolledBack[1].Route.Dst != "default" {
+		t.Fatalf("unexpected rollback: %v", txErr.RolledBack)
+	}
+
+	after, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load() error: %v", err)
+	}
+	if len(after) != 1 || after[0].Gateway != "10.0.0.1" {
+		t.Fatalf("baseline must be kept, got=%+v", after)
+	}
+}
+
+type fakeRuleManager struct {
+	ops []string
+}
+
+func (m *fakeRuleManager) List(ctx context.Context) ([]Rule, error) {
+	return nil, nil
+}
+
+func (m *fakeRuleManager) Add(ctx context.Context, r Rule) error {
+	k, _ := r.Key()
+	m.ops = append(m.ops, "add rule "+k)
+	return nil
+}
+
+func (m *fakeRuleManager) Delete(ctx context.Context, r Rule) error {
+	k, _ := r.Key()
+	m.ops = append(m.ops, "del rule "+k)
+	return nil
+}
+
+func TestControllerReconcileSnapshot_Rules(t *testing.T) {
+	ctx := context.Background()
+
+	store := &MemoryStore{}
+	if err := store.SaveRules([]Rule{{Priority: 100, From: "10.0.0.0/8", Table: 100}}); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	mgr := &fakeManager{}
+	rules := &fakeRuleManager{}
+	c := Controller{Manager: mgr, Store: store, RuleManager: rules}
+
+	got, err := c.ReconcileSnapshot(ctx, Snapshot{
+		Routes: []Route{{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 200}},
+		Rules:  []Rule{{Priority: 200, From: "10.0.0.0/8", Table: 200}},
+	})
+	if err != nil {
+		t.Fatalf("ReconcileSnapshot() error: %v", err)
+	}
+	if len(got.Diff.ToAdd) != 1 || len(got.Rules.ToAdd) != 1 || len(got.Rules.ToDel) != 1 {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+	if len(rules.ops) != 2 || !strings.HasPrefix(rules.ops[0], "del rule pref=100") || !strings.HasPrefix(rules.ops[1], "add rule pref=200") {
+		t.Fatalf("unexpected rule ops=%v", rules.ops)
+	}
+
+	savedRules, _ := store.LoadRules()
+	savedRoutes, _ := store.Load()
+	if len(savedRules) != 1 || savedRules[0].Priority != 200 || len(savedRoutes) != 1 {
+		t.Fatalf("store not updated, rules=%+v routes=%+v", savedRules, savedRoutes)
+	}
+
+	// Rolled back routes bring the deleted rules back instead of adding the new ones.
+	rules.ops = nil
+	c.Transactional = true
+	mgr.addE = errors.New("boom")
+	_, err = c.ReconcileSnapshot(ctx, Snapshot{
+		Routes: []Route{{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 300}},
+		Rules:  []Rule{{Priority: 300, From: "10.0.0.0/8", Table: 300}},
+	})
+	var txErr *TransactionError
+	if !errors.As(err, &txErr) {
+		t.Fatalf("expected *TransactionError, got %v", err)
+	}
+	if len(rules.ops) != 2 || !strings.HasPrefix(rules.ops[0], "del rule pref=200") || !strings.HasPrefix(rules.ops[1], "add rule pref=200") {
+		t.Fatalf("unexpected rule ops=%v", rules.ops)
+	}
+	if savedRules, _ = store.LoadRules(); len(savedRules) != 1 || savedRules[0].Priority != 200 {
+		t.Fatalf("rule baseline must be kept, rules=%+v", savedRules)
+	}
+}
+
+// fakeSectionManager manages the objects of a snapshot section, recording into
+// ops, which may be shared with a fakeManager to check the order of operations
+// across sections; name describes an object ("nh 1", "addr 10.0.0.5/24").
+type fakeSectionManager[T any] struct {
+	ops  *[]string
+	name func(T) string
+}
+
+func (m *fakeSectionManager[T]) List(ctx context.Context) ([]T, error) {
+	return nil, nil
+}
+
+func (m *fakeSectionManager[T]) Add(ctx context.Context, v T) error {
+	*m.ops = append(*m.ops, "add "+m.name(v))
+	return nil
+}
+
+func (m *fakeSectionManager[T]) Delete(ctx context.Context, v T) error {
+	*m.ops = append(*m.ops, "del "+m.name(v))
+	return nil
+}
+
+// withSectionManagers sets fake managers for every snapshot section on c, recording into ops.
+func withSectionManagers(c Controller, ops *[]string) Controller {
+	c.NexthopManager = &fakeSectionManager[NexthopObject]{ops, func(nh NexthopObject) string { return fmt.Sprintf("nh %d", nh.ID) }}
+	c.AddressManager = &fakeSectionManager[Address]{ops, func(a Address) string { return "addr " + a.IP }}
+	c.NeighborManager = &fakeSectionManager[Neighbor]{ops, func(n Neighbor) string { return "neigh " + n.IP }}
+	c.LinkManager = &fakeSectionManager[Link]{ops, func(l Link) string { return "link " + l.Name }}
+	return c
+}
+
+func TestControllerReconcileSnapshot_Sections(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name string
+		// old is the baseline saved before the reconcile.
+		old  Snapshot
+		snap Snapshot
+		// want are the prefixes of the operations, in order.
+		want []string
+		// check inspects the plan and the new baseline.
+		check func(res ReconcileResult, saved Snapshot) error
+	}{
+		{
+			name: "nexthops",
+			old: Snapshot{
+				Routes: []Route{{Dst: "10.1.0.0/16", NexthopID: 5}},
+				Nexthops: []NexthopObject{
+					{ID: 1, Gateway: "10.0.0.1", Device: "eth0"},
+					{ID: 5, Blackhole: true},
+				},
+			},
+			snap: Snapshot{
+				Routes: []Route{{Dst: "10.2.0.0/16", NexthopID: 10}},
+				Nexthops: []NexthopObject{
+					{ID: 10, Group: []NexthopGroupEntry{{ID: 2}, {ID: 1, Weight: 3}}},
+					{ID: 2, Gateway: "10.0.0.2", Device: "eth0"},
+					{ID: 1, Gateway: "10.0.0.9", Device: "eth0"},
+				},
+			},
+			want: []string{"add nh 2", "add nh 10", "add nh 1", "del dst=10.1.0.0/16", "add dst=10.2.0.0/16", "del nh 5"},
+			check: func(res ReconcileResult, saved Snapshot) error {
+				if d := res.Nexthops; len(d.ToAdd) != 2 || len(d.ToReplace) != 1 || len(d.ToDel) != 1 {
+					return fmt.Errorf("unexpected nexthop diff: %+v", d)
+				}
+				if nhs := saved.Nexthops; len(nhs) != 3 || nhs[0].ID != 1 || nhs[0].Gateway != "10.0.0.9" || nhs[2].ID != 10 {
+					return fmt.Errorf("store not updated, nexthops=%+v", nhs)
+				}
+				return nil
+			},
+		},
+		{
+			name: "addresses",
+			old:  Snapshot{Addresses: []Address{{IP: "10.0.0.5/24", Device: "eth0"}}},
+			snap: Snapshot{
+				Routes:    []Route{{Dst: "default", Gateway: "10.1.0.1", Device: "eth0", Src: "10.1.0.5"}},
+				Addresses: []Address{{IP: "10.1.0.5/24", Device: "eth0"}},
+			},
+			want: []string{"add addr 10.1.0.5/24", "add dst=default", "del addr 10.0.0.5/24"},
+			check: func(res ReconcileResult, saved Snapshot) error {
+				if d := res.Addresses; len(d.ToAdd) != 1 || len(d.ToDel) != 1 {
+					return fmt.Errorf("unexpected address diff: %+v", d)
+				}
+				if aa := saved.Addresses; len(aa) != 1 || aa[0].IP != "10.1.0.5/24" {
+					return fmt.Errorf("store not updated, addresses=%+v", aa)
+				}
+				return nil
+			},
+		},
+		{
+			name: "neighbors",
+			old: Snapshot{
+				Routes:    []Route{{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"}},
+				Neighbors: []Neighbor{{IP: "10.0.0.1", LLAddr: "52:54:00:00:00:01", Device: "eth0"}},
+			},
+			snap: Snapshot{
+				Routes:    []Route{{Dst: "default", Gateway: "10.0.0.2", Device: "eth0"}},
+				Addresses: []Address{{IP: "10.0.0.5/24", Device: "eth0"}},
+				Neighbors: []Neighbor{{IP: "10.0.0.2", LLAddr: "52:54:00:00:00:02", Device: "eth0"}},
+			},
+			want: []string{"add addr 10.0.0.5/24", "add neigh 10.0.0.2", "del dst=default|gw=10.0.0.1", "add dst=default|gw=10.0.0.2", "del neigh 10.0.0.1"},
+			check: func(res ReconcileResult, saved Snapshot) error {
+				if d := res.Neighbors; len(d.ToAdd) != 1 || len(d.ToDel) != 1 {
+					return fmt.Errorf("unexpected neighbor diff: %+v", d)
+				}
+				if nn := saved.Neighbors; len(nn) != 1 || nn[0].IP != "10.0.0.2" {
+					return fmt.Errorf("store not updated, neighbors=%+v", nn)
+				}
+				return nil
+			},
+		},
+		{
+			name: "links",
+			old: Snapshot{
+				Routes: []Route{{Dst: "10.9.0.0/16", Device: "old0"}},
+				Links:  []Link{{Name: "old0", Kind: "dummy"}},
+			},
+			snap: Snapshot{
+				Routes:    []Route{{Dst: "10.10.0.0/16", Device: "vlan10"}},
+				Addresses: []Address{{IP: "10.10.0.1/16", Device: "vlan10"}},
+				Links: []Link{
+					{Name: "vlan10", Kind: "vlan", Parent: "eth0", VLANID: 10, Master: "br0"},
+					{Name: "br0", Kind: "bridge"},
+				},
+			},
+			want: []string{"add link br0", "add link vlan10", "add addr 10.10.0.1/16", "del dst=10.9.0.0/16", "add dst=10.10.0.0/16", "del link old0"},
+			check: func(res ReconcileResult, saved Snapshot) error {
+				if d := res.Links; len(d.ToAdd) != 2 || len(d.ToDel) != 1 {
+					return fmt.Errorf("unexpected link diff: %+v", d)
+				}
+				if ll := saved.Links; len(ll) != 2 || ll[0].Name != "br0" || ll[1].Name != "vlan10" {
+					return fmt.Errorf("store not updated, links=%+v", ll)
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		store := &MemoryStore{}
+		store.Save(tc.old.Routes)
+		store.SaveNexthops(tc.old.Nexthops)
+		store.SaveAddresses(tc.old.Addresses)
+		store.SaveNeighbors(tc.old.Neighbors)
+		store.SaveLinks(tc.old.Links)
+
+		mgr := &fakeManager{}
+		c := withSectionManagers(Controller{Manager: mgr, Store: store}, &mgr.ops)
+		res, err := c.ReconcileSnapshot(ctx, tc.snap)
+		if err != nil {
+			t.Fatalf("%s: ReconcileSnapshot() error: %v", tc.name, err)
+		}
+		if len(mgr.ops) != len(tc.want) {
+			t.Fatalf("%s: unexpected ops=%v", tc.name, mgr.ops)
+		}
+		for i, w := range tc.want {
+			if !strings.HasPrefix(mgr.ops[i], w) {
+				t.Fatalf("%s: op[%d]=%q, want prefix %q (ops=%v)", tc.name, i, mgr.ops[i], w, mgr.ops)
+			}
+		}
+
+		var saved Snapshot
+		saved.Nexthops, _ = store.LoadNexthops()
+		saved.Addresses, _ = store.LoadAddresses()
+		saved.Neighbors, _ = store.LoadNeighbors()
+		saved.Links, _ = store.LoadLinks()
+		if err := tc.check(res, saved); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestControllerReconcileSnapshot_NexthopKindChange(t *testing.T) {
+	ctx := context.Background()
+
+	old := []NexthopObject{{ID: 1, Device: "eth0"}, {ID: 2, Device: "eth1"}, {ID: 10, Group: []NexthopGroupEntry{{ID: 1}}}}
+	for _, desired := range [][]NexthopObject{
+		{{ID: 1, Device: "eth0"}, {ID: 2, Group: []NexthopGroupEntry{{ID: 1}}}, {ID: 10, Group: []NexthopGroupEntry{{ID: 1}}}},
+		{{ID: 1, Device: "eth0"}, {ID: 2, Device: "eth1"}, {ID: 10, Device: "eth0"}},
+	} {
+		store := &MemoryStore{}
+		store.Save([]Route{{Dst: "10.1.0.0/16", NexthopID: 10}})
+		store.SaveNexthops(old)
+		mgr := &fakeManager{}
+		c := withSectionManagers(Controller{Manager: mgr, Store: store}, &mgr.ops)
+
+		_, err := c.ReconcileSnapshot(ctx, Snapshot{Routes: []Route{{Dst: "10.1.0.0/16", NexthopID: 10}}, Nexthops: desired})
+		if err == nil || !strings.Contains(err.Error(), "use a new id") {
+			t.Fatalf("ReconcileSnapshot(%+v) error = %v, want a kind change error", desired, err)
+		}
+		if len(mgr.ops) != 0 {
+			t.Fatalf("nothing must be applied, got ops=%v", mgr.ops)
+		}
+	}
+}
+
+type fakeVRFManager struct {
+	live  []VRF
+	added []VRF
+}
+
+func (m *fakeVRFManager) List(ctx context.Context) ([]VRF, error) {
+	return append([]VRF(nil), m.live...), nil
+}
+
+func (m *fakeVRFManager) Add(ctx context.Context, v VRF) error {
+	m.added = append(m.added, v)
+	m.live = append(m.live, v)
+	return nil
+}
+
+func TestControllerReconcile_VRFs(t *testing.T) {
+	ctx := context.Background()
+
+	mgr := &fakeManager{}
+	vrfs := &fakeVRFManager{live: []VRF{{Name: "vrf-red", Table: 20}}}
+	c := Controller{Manager: mgr, Store: &MemoryStore{}, VRFManager: vrfs, VRFs: []VRF{{Name: "vrf-blue", Table: 10}}}
+
+	desired := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", VRF: "vrf-blue"},
+		{Dst: "default", Gateway: "10.0.1.1", Device: "eth1", VRF: "vrf-red"},
+	}
+	if _, err := c.Reconcile(ctx, desired); err != nil {
+		t.Fatalf("Reconcile() error: %v", err)
+	}
+	if len(vrfs.added) != 1 || vrfs.added[0].Name != "vrf-blue" || len(mgr.ops) != 2 {
+		t.Fatalf("unexpected vrfs added=%+v ops=%v", vrfs.added, mgr.ops)
+	}
+
+	mgr.ops = nil
+	desired = append(desired, Route{Dst: "default", Gateway: "10.0.2.1", Device: "eth2", VRF: "vrf-green"})
+	if _, err := c.Reconcile(ctx, desired); err == nil || !strings.Contains(err.Error(), "vrf-green") {
+		t.Fatalf("expected unknown vrf error, got %v", err)
+	}
+	if len(mgr.ops) != 0 {
+		t.Fatalf("nothing must be applied, got ops=%v", mgr.ops)
+	}
+
+	// A route given by table matches the live route reported by VRF name.
+	mgr.list = []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", VRF: "vrf-blue"},
+		{Dst: "default", Gateway: "10.0.1.1", Device: "eth1", VRF: "vrf-red"},
+	}
+	byTable := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 10},
+		{Dst: "default", Gateway: "10.0.1.1", Device: "eth1", Table: 20},
+	}
+	if rep, err := c.DetectDrift(ctx, byTable); err != nil || !rep.InSync() {
+		t.Fatalf("DetectDrift() by table = %+v, %v; want in sync", rep, err)
+	}
+	if res, err := c.Reconcile(ctx, byTable); err != nil || len(mgr.ops) != 0 || len(res.Diff.Unchanged) != 2 {
+		t.Fatalf("Reconcile() by table = %+v, %v, ops=%v; want unchanged", res.Diff, err, mgr.ops)
+	}
+
+	c.VRFs = []VRF{{Name: "vrf-red", Table: 30}}
+	if _, err := c.Reconcile(ctx, desired[:2]); err == nil {
+		t.Fatalf("expected vrf table mismatch error")
+	}
+}
+
+type watchManager struct {
+	mu     sync.Mutex
+	live   []Route
+	events chan RouteEvent
+	added  chan Route
+}
+
+func (m *watchManager) List(ctx context.Context) ([]Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Route(nil), m.live...), nil
+}
+
+func (m *watchManager) Add(ctx context.Context, r Route) error {
+	m.mu.Lock()
+	m.live = append(m.live, r)
+	m.mu.Unlock()
+	m.added <- r
+	return nil
+}
+
+func (m *watchManager) Delete(ctx context.Context, r Route) error {
+	return nil
+}
+
+func (m *watchManager) Watch(ctx context.Context) (<-chan RouteEvent, error) {
+	return m.events, nil
+}
+
+func TestControllerRun_RepairsDeletedRoute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	route := Route{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0"}
+	store := &MemoryStore{}
+	if err := store.Save([]Route{route}); err != nil {
+		t.Fatalf("seed store: %v", err)
+	}
+
+	mgr := &watchManager{
+		live:   []Route{route},
+		events: make(chan RouteEvent),
+		added:  make(chan Route, 1),
+	}
+	c := Controller{Manager: mgr, Store: store, WatchDebounce: 10 * time.Millisecond}
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- c.Run(ctx) }()
+
+	// Someone runs "ip route del" by hand.
+	mgr.mu.Lock()
+	mgr.live = nil
+	mgr.mu.Unlock()
+	mgr.events <- RouteEvent{Route: route, Deleted: true}
+
+	select {
+	case got := <-mgr.added:
+		if got.Dst != route.Dst {
+			t.Fatalf("re-added %+v, want %+v", got, route)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("deleted route was not re-applied")
+	}
+
+	cancel()
+	if err := <-runErr; err != context.Canceled {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestControllerReconcile_Guards(t *testing.T) {
+	ctx := context.Background()
+
+	baseline := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0"},
+		{Dst: "10.20.0.0/16", Gateway: "10.0.0.3", Device: "eth0"},
+	}
+	cases := []struct {
+		name    string
+		c       Controller
+		desired []Route
+	}{
+		{"empty desired", Controller{RefuseEmptyDesired: true}, nil},
+		{"max deletes", Controller{MaxDeletes: 1}, baseline[:1]},
+		{"max delete percent", Controller{MaxDeletePercent: 50}, baseline[:1]},
+		{"protected default", Controller{ProtectedRoutes: []string{"default"}}, baseline[1:]},
+		{"protected prefix", Controller{ProtectedRoutes: []string{"10.0.0.0/8"}}, baseline[:2]},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &MemoryStore{}
+			if err := store.Save(baseline); err != nil {
+				t.Fatalf("seed store: %v", err)
+			}
+			mgr := &fakeManager{}
+			c := tc.c
+			c.Manager, c.Store = mgr, store
+
+			_, err := c.Reconcile(ctx, tc.desired)
+			var guardErr *GuardError
+			if !errors.As(err, &guardErr) {
+				t.Fatalf("expected *GuardError, got %v", err)
+			}
+			if len(mgr.ops) != 0 {
+				t.Fatalf("no route may be touched, got ops=%v", mgr.ops)
+			}
+
+			c.Force = true
+			if _, err := c.Reconcile(ctx, tc.desired); err != nil {
+				t.Fatalf("Reconcile() with Force error: %v", err)
+			}
+		})
+	}
+}
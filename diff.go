@@ -3,16 +3,112 @@ package linuxroute
 import (
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // DiffResult is the plan computed from oldRoutes -> desiredRoutes.
+//
+// With an identity (see DiffRoutesWithIdentity), ToDel and ToAdd are the routes
+// whose identity changed (it went away or appeared), and ToReplace the routes whose
+// attributes changed under the same identity.
 type DiffResult struct {
 	// ToAdd are routes in desired but not in old (after normalization).
-	ToAdd []Route
+	ToAdd []Route `json:"to_add,omitempty"`
 	// ToDel are routes in old but not in desired (after normalization).
-	ToDel []Route
+	ToDel []Route `json:"to_del,omitempty"`
 	// Unchanged are routes present in both sets (after normalization).
-	Unchanged []Route
+	Unchanged []Route `json:"unchanged,omitempty"`
+	// ToReplace are old routes that should be updated in place to a desired route
+	// sharing the same identity. Only populated by DiffRoutesWithIdentity.
+	ToReplace []RouteChange `json:"to_replace,omitempty"`
+}
+
+// RouteChange pairs an existing route with the desired route that replaces it.
+type RouteChange struct {
+	Old Route `json:"old"`
+	New Route `json:"new"`
+	// Changed names the attributes that differ, by their JSON name
+	// (e.g. "gateway", "device"). Only set by DiffRoutesWithIdentity.
+	Changed []string `json:"changed,omitempty"`
+}
+
+// changedAttributes returns the JSON names of the fields in which two
+// normalized routes differ.
+func changedAttributes(old, new Route) []string {
+	hops := func(r Route) string {
+		s := make([]string, 0, len(r.Nexthops))
+		for _, nh := range r.Nexthops {
+			s = append(s, nh.key())
+		}
+		return strings.Join(s, ";")
+	}
+	encap := func(e *Encap) string {
+		if e == nil {
+			return ""
+		}
+		return e.String()
+	}
+	metrics := func(m *RouteMetrics) string {
+		if m == nil {
+			return ""
+		}
+		return m.String()
+	}
+
+	var changed []string
+	for _, f := range []struct {
+		name     string
+		old, new string
+	}{
+		{"dst", old.Dst, new.Dst},
+		{"gateway", old.Gateway, new.Gateway},
+		{"device", old.Device, new.Device},
+		{"table", strconv.Itoa(old.Table), strconv.Itoa(new.Table)},
+		{"metric", strconv.Itoa(old.Metric), strconv.Itoa(new.Metric)},
+		{"src", old.Src, new.Src},
+		{"tos", strconv.Itoa(old.TOS), strconv.Itoa(new.TOS)},
+		{"scope", old.Scope, new.Scope},
+		{"type", old.Type, new.Type},
+		{"proto", old.Proto, new.Proto},
+		{"nexthops", hops(old), hops(new)},
+		{"encap", encap(old.Encap), encap(new.Encap)},
+		{"metrics", metrics(old.Metrics), metrics(new.Metrics)},
+		{"family", old.Family, new.Family},
+		{"newdst", formatMPLSLabels(old.NewDst), formatMPLSLabels(new.NewDst)},
+		{"nhid", strconv.FormatUint(uint64(old.NexthopID), 10), strconv.FormatUint(uint64(new.NexthopID), 10)},
+		{"vrf", old.VRF, new.VRF},
+	} {
+		if f.old != f.new {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
+
+// IdentityFunc returns the identity of a normalized route.
+// Routes in old and desired with the same identity but different full keys
+// are reported as in-place replacements instead of delete+add.
+type IdentityFunc func(r Route) string
+
+// DstTableMetricIdentity identifies a route by dst+table+metric (and VRF),
+// so changing only the gateway/device of a route becomes a replace.
+func DstTableMetricIdentity(r Route) string {
+	id := fmt.Sprintf("dst=%s|table=%d|metric=%d", r.Dst, r.Table, r.Metric)
+	if r.VRF != "" {
+		id += "|vrf=" + r.VRF
+	}
+	return id
+}
+
+// KernelIdentity identifies a route the way the kernel tells routes apart:
+// by family, then IPv4 by dst+tos+priority+table and IPv6 by dst+src+metric+table,
+// where src is the source prefix Route does not support (see Route), so it is
+// always the default. Unlike DstTableMetricIdentity, table 0 is main and an
+// IPv6 metric 0 is 1024. Two routes with the same kernel identity cannot coexist.
+func KernelIdentity(r Route) string {
+	return fmt.Sprintf("%s|dst=%s|table=%d|vrf=%s|metric=%d|tos=%d",
+		routeFamily(r), r.Dst, effectiveTable(r.Table), r.VRF, effectiveMetric(r), r.TOS)
 }
 
 // DiffRoutes computes a set-diff between oldRoutes and desiredRoutes.
@@ -56,17 +152,222 @@ func DiffRoutes(oldRoutes, desiredRoutes []Route) (DiffResult, error) {
 	}
 
 	// Make output deterministic.
-	sortRoutes := func(rr []Route) {
-		sort.Slice(rr, func(i, j int) bool {
-			ki, _ := rr[i].Key()
-			kj, _ := rr[j].Key()
-			return ki < kj
-		})
-	}
-
 	sortRoutes(res.ToDel)
 	sortRoutes(res.ToAdd)
 	sortRoutes(res.Unchanged)
 
 	return res, nil
 }
+
+// DiffRoutesWithIdentity is like DiffRoutes, but pairs a deleted and an added route
+// sharing the same identity into ToReplace. Pairing is 1:1: if several old or desired
+// routes share one identity, they are left as delete+add.
+// A nil identity behaves exactly like DiffRoutes.
+func DiffRoutesWithIdentity(oldRoutes, desiredRoutes []Route, identity IdentityFunc) (DiffResult, error) {
+	res, err := DiffRoutes(oldRoutes, desiredRoutes)
+	if err != nil || identity == nil {
+		return res, err
+	}
+
+	// Count identities over the full sets, so a route that is unchanged
+	// never gets paired with an unrelated add/delete.
+	oldCount := make(map[string]int, len(res.ToDel)+len(res.Unchanged))
+	newCount := make(map[string]int, len(res.ToAdd)+len(res.Unchanged))
+	for _, r := range res.Unchanged {
+		oldCount[identity(r)]++
+		newCount[identity(r)]++
+	}
+	for _, r := range res.ToDel {
+		oldCount[identity(r)]++
+	}
+	addByID := make(map[string]Route, len(res.ToAdd))
+	for _, r := range res.ToAdd {
+		id := identity(r)
+		newCount[id]++
+		addByID[id] = r
+	}
+
+	var toDel []Route
+	paired := make(map[string]bool)
+	for _, oldR := range res.ToDel {
+		id := identity(oldR)
+		newR, ok := addByID[id]
+		if !ok || oldCount[id] != 1 || newCount[id] != 1 {
+			toDel = append(toDel, oldR)
+			continue
+		}
+		res.ToReplace = append(res.ToReplace, RouteChange{Old: oldR, New: newR, Changed: changedAttributes(oldR, newR)})
+		paired[id] = true
+	}
+	var toAdd []Route
+	for _, r := range res.ToAdd {
+		if !paired[identity(r)] {
+			toAdd = append(toAdd, r)
+		}
+	}
+	res.ToDel = toDel
+	res.ToAdd = toAdd
+
+	return res, nil
+}
+
+func sortRoutes(rr []Route) {
+	sort.Slice(rr, func(i, j int) bool {
+		ki, _ := rr[i].Key()
+		kj, _ := rr[j].Key()
+		return ki < kj
+	})
+}
+
+// RuleDiffResult is the plan computed from oldRules -> desiredRules.
+type RuleDiffResult struct {
+	ToAdd     []Rule `json:"to_add,omitempty"`
+	ToDel     []Rule `json:"to_del,omitempty"`
+	Unchanged []Rule `json:"unchanged,omitempty"`
+}
+
+// DiffRules computes a set-diff between oldRules and desiredRules using Rule.Key() semantics.
+func DiffRules(oldRules, desiredRules []Rule) (RuleDiffResult, error) {
+	oldMap := make(map[string]Rule, len(oldRules))
+	for i, r := range oldRules {
+		n, err := r.Normalize()
+		if err != nil {
+			return RuleDiffResult{}, fmt.Errorf("oldRules[%d]: %w", i, err)
+		}
+		k, _ := n.Key()
+		oldMap[k] = n
+	}
+
+	newMap := make(map[string]Rule, len(desiredRules))
+	for i, r := range desiredRules {
+		n, err := r.Normalize()
+		if err != nil {
+			return RuleDiffResult{}, fmt.Errorf("desiredRules[%d]: %w", i, err)
+		}
+		k, _ := n.Key()
+		newMap[k] = n
+	}
+
+	var res RuleDiffResult
+	for k, oldR := range oldMap {
+		if _, ok := newMap[k]; ok {
+			res.Unchanged = append(res.Unchanged, oldR)
+		} else {
+			res.ToDel = append(res.ToDel, oldR)
+		}
+	}
+	for k, newR := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			res.ToAdd = append(res.ToAdd, newR)
+		}
+	}
+
+	sortRules(res.ToDel)
+	sortRules(res.ToAdd)
+	sortRules(res.Unchanged)
+
+	return res, nil
+}
+
+func sortRules(rr []Rule) {
+	sort.Slice(rr, func(i, j int) bool {
+		ki, _ := rr[i].Key()
+		kj, _ := rr[j].Key()
+		return ki < kj
+	})
+}
+
+// NexthopDiffResult is the plan computed from oldNexthops -> desiredNexthops.
+// ToReplace are objects whose ID is kept but whose attributes change;
+// they are updated in place so routes using them never lose their nexthop.
+type NexthopDiffResult = SectionDiff[NexthopObject]
+
+// NexthopChange pairs an existing nexthop object with the desired one replacing it.
+type NexthopChange = Change[NexthopObject]
+
+// DiffNexthops computes a diff between oldNexthops and desiredNexthops by ID.
+// ToAdd is ordered so group members come before their groups, ToDel the reverse.
+func DiffNexthops(oldNexthops, desiredNexthops []NexthopObject) (NexthopDiffResult, error) {
+	res, err := diffSection(oldNexthops, desiredNexthops, "oldNexthops", "desiredNexthops")
+	if err != nil {
+		return NexthopDiffResult{}, err
+	}
+	sortNexthopsForAdd(res.ToAdd)
+	sortNexthopsForDelete(res.ToDel)
+	sortNexthopsForAdd(res.Unchanged)
+	sort.Slice(res.ToReplace, func(i, j int) bool { return res.ToReplace[i].New.ID < res.ToReplace[j].New.ID })
+	return res, nil
+}
+
+// AddressDiffResult is the plan computed from oldAddresses -> desiredAddresses.
+// ToReplace are addresses on the same device whose attributes (label, scope,
+// flags, lifetimes) change; they are updated in place.
+type AddressDiffResult = SectionDiff[Address]
+
+// AddressChange pairs an existing address with the desired one replacing it.
+type AddressChange = Change[Address]
+
+// DiffAddresses computes a diff between oldAddresses and desiredAddresses
+// by ip+device, the kernel's identity of an address.
+func DiffAddresses(oldAddresses, desiredAddresses []Address) (AddressDiffResult, error) {
+	res, err := diffSection(oldAddresses, desiredAddresses, "oldAddresses", "desiredAddresses")
+	if err != nil {
+		return AddressDiffResult{}, err
+	}
+	sortAddresses(res.ToAdd)
+	sortAddresses(res.ToDel)
+	sortAddresses(res.Unchanged)
+	return res, nil
+}
+
+// NeighborDiffResult is the plan computed from oldNeighbors -> desiredNeighbors.
+// ToReplace are entries whose lladdr or state changes; they are updated in place.
+type NeighborDiffResult = SectionDiff[Neighbor]
+
+// NeighborChange pairs an existing neighbor entry with the desired one replacing it.
+type NeighborChange = Change[Neighbor]
+
+// DiffNeighbors computes a diff between oldNeighbors and desiredNeighbors
+// by ip+device(+proxy), the kernel's identity of a neighbor entry.
+func DiffNeighbors(oldNeighbors, desiredNeighbors []Neighbor) (NeighborDiffResult, error) {
+	res, err := diffSection(oldNeighbors, desiredNeighbors, "oldNeighbors", "desiredNeighbors")
+	if err != nil {
+		return NeighborDiffResult{}, err
+	}
+	sortNeighbors(res.ToAdd)
+	sortNeighbors(res.ToDel)
+	sortNeighbors(res.Unchanged)
+	return res, nil
+}
+
+// LinkDiffResult is the plan computed from oldLinks -> desiredLinks.
+// ToReplace are devices whose attributes change; MTU, state and master are
+// updated in place, other changes re-create the device.
+type LinkDiffResult = SectionDiff[Link]
+
+// LinkChange pairs an existing device with the desired one replacing it.
+type LinkChange = Change[Link]
+
+// DiffLinks computes a diff between oldLinks and desiredLinks by name.
+// ToAdd is ordered so parents and masters come before the devices using them,
+// ToDel the reverse; a master/parent cycle is an error.
+func DiffLinks(oldLinks, desiredLinks []Link) (LinkDiffResult, error) {
+	res, err := diffSection(oldLinks, desiredLinks, "oldLinks", "desiredLinks")
+	if err != nil {
+		return LinkDiffResult{}, err
+	}
+
+	desired := append(append([]Link(nil), res.ToAdd...), res.Unchanged...)
+	for _, lc := range res.ToReplace {
+		desired = append(desired, lc.New)
+	}
+	if err := sortLinksForAdd(desired); err != nil {
+		return LinkDiffResult{}, err
+	}
+	sortLinksForAdd(res.ToAdd)     // a subset of desired, checked above
+	sortLinksForAdd(res.Unchanged) // likewise
+	if err := sortLinksForDelete(res.ToDel); err != nil {
+		return LinkDiffResult{}, err
+	}
+	return res, nil
+}
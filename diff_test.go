@@ -1,6 +1,9 @@
 package linuxroute
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestRouteNormalize(t *testing.T) {
 	r, err := (Route{
@@ -17,6 +20,13 @@ func TestRouteNormalize(t *testing.T) {
 	if r.Dst != "10.0.0.0/24" {
 		t.Fatalf("Dst not normalized, got %q", r.Dst)
 	}
+
+	// unicast is the kernel default, which live routes report as "".
+	k1, _ := Route{Dst: "10.0.0.0/24", Device: "eth0", Type: "Unicast"}.Key()
+	k2, _ := Route{Dst: "10.0.0.0/24", Device: "eth0"}.Key()
+	if k1 != k2 {
+		t.Fatalf("unicast route keys differ:\n%s\n%s", k1, k2)
+	}
 }
 
 func TestDiffRoutes(t *testing.T) {
@@ -48,3 +58,312 @@ func TestDiffRoutes(t *testing.T) {
 		t.Fatalf("ToDel[0].Dst = %q, want %q", res.ToDel[0].Dst, "default")
 	}
 }
+
+func TestRouteNexthopsCanonical(t *testing.T) {
+	a := Route{Dst: "default", Nexthops: []Nexthop{
+		{Gateway: "10.0.0.2", Device: "eth1", Weight: 2},
+		{Gateway: "10.0.0.1", Device: "eth0"},
+	}}
+	b := Route{Dst: "default", Nexthops: []Nexthop{
+		{Gateway: "10.0.0.1", Device: "eth0", Weight: 1},
+		{Gateway: "10.0.0.2", Device: "eth1", Weight: 2},
+	}}
+
+	res, err := DiffRoutes([]Route{a}, []Route{b})
+	if err != nil {
+		t.Fatalf("DiffRoutes() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 0 || len(res.ToDel) != 0 {
+		t.Fatalf("nexthop order should not matter, got %+v", res)
+	}
+
+	single, err := (Route{Dst: "default", Nexthops: []Nexthop{{Gateway: "10.0.0.1", Device: "eth0"}}}).Normalize()
+	if err != nil {
+		t.Fatalf("Normalize() error: %v", err)
+	}
+	if single.Gateway != "10.0.0.1" || single.Device != "eth0" || single.Nexthops != nil {
+		t.Fatalf("single nexthop not collapsed, got %+v", single)
+	}
+}
+
+func TestDiffRoutesWithIdentity(t *testing.T) {
+	oldRoutes := []Route{
+		{Dst: "default", Gateway: "10.0.0.1", Device: "eth0"},
+		{Dst: "10.10.0.0/16", Gateway: "10.0.0.2", Device: "eth0"},
+	}
+	desiredRoutes := []Route{
+		{Dst: "default", Gateway: "10.0.0.254", Device: "eth0"},
+	}
+
+	res, err := DiffRoutesWithIdentity(oldRoutes, desiredRoutes, DstTableMetricIdentity)
+	if err != nil {
+		t.Fatalf("DiffRoutesWithIdentity() error: %v", err)
+	}
+	if len(res.ToReplace) != 1 || len(res.ToAdd) != 0 || len(res.ToDel) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToReplace[0].Old.Gateway != "10.0.0.1" || res.ToReplace[0].New.Gateway != "10.0.0.254" {
+		t.Fatalf("unexpected replace pair: %+v", res.ToReplace[0])
+	}
+	if res.ToDel[0].Dst != "10.10.0.0/16" {
+		t.Fatalf("ToDel[0].Dst = %q, want %q", res.ToDel[0].Dst, "10.10.0.0/16")
+	}
+}
+
+func TestDiffRules(t *testing.T) {
+	oldRules := []Rule{
+		{Priority: 100, From: "10.0.0.0/8", Table: 100},
+		{Priority: 200, FwMark: 0x1, Table: 200},
+	}
+	desiredRules := []Rule{
+		{Priority: 100, From: "10.1.2.3/8", Table: 100, Action: "lookup"}, // unchanged after normalization
+		{Priority: 300, To: "192.168.0.1", IPProto: "6", DPort: "443", Table: 300},
+	}
+
+	res, err := DiffRules(oldRules, desiredRules)
+	if err != nil {
+		t.Fatalf("DiffRules() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 1 || len(res.ToDel) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToDel[0].FwMask != 0xffffffff {
+		t.Fatalf("fwmask not defaulted, got %#x", res.ToDel[0].FwMask)
+	}
+	add := res.ToAdd[0]
+	if add.To != "192.168.0.1/32" || add.IPProto != "tcp" || add.DPort != "443" {
+		t.Fatalf("rule not normalized, got %+v", add)
+	}
+
+	if _, err := (Rule{Priority: 10}).Normalize(); err == nil {
+		t.Fatalf("expected rule without table or action to be rejected")
+	}
+}
+
+func TestDiffNexthops(t *testing.T) {
+	old := []NexthopObject{
+		{ID: 1, Gateway: "10.0.0.1", Device: "eth0"},
+		{ID: 2, Gateway: "10.0.0.2", Device: "eth0"},
+		{ID: 3, Blackhole: true},
+	}
+	desired := []NexthopObject{
+		{ID: 1, Family: "inet", Gateway: "10.0.0.1", Device: "eth0"}, // unchanged after normalization
+		{ID: 2, Gateway: "10.0.0.3", Device: "eth0"},
+		{ID: 4, Group: []NexthopGroupEntry{{ID: 2, Weight: 2}, {ID: 1}}},
+	}
+
+	res, err := DiffNexthops(old, desired)
+	if err != nil {
+		t.Fatalf("DiffNexthops() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 1 || len(res.ToDel) != 1 || len(res.ToReplace) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToReplace[0].Old.Gateway != "10.0.0.2" || res.ToReplace[0].New.Gateway != "10.0.0.3" {
+		t.Fatalf("unexpected replace: %+v", res.ToReplace[0])
+	}
+	if g := res.ToAdd[0].groupString(); g != "1/2,2" {
+		t.Fatalf("group not canonical, got %q", g)
+	}
+
+	if _, err := DiffNexthops(nil, []NexthopObject{{ID: 1, Blackhole: true}, {ID: 1, Device: "eth0"}}); err == nil {
+		t.Fatalf("expected conflicting duplicate IDs to be rejected")
+	}
+	if _, err := (NexthopObject{ID: 1, Device: "eth0", Group: []NexthopGroupEntry{{ID: 2}}}).Normalize(); err == nil {
+		t.Fatalf("expected group with device to be rejected")
+	}
+}
+
+func TestDiffAddresses(t *testing.T) {
+	old := []Address{
+		{IP: "10.0.0.5/24", Device: "eth0"},
+		{IP: "10.0.1.5/24", Device: "eth0", Label: "eth0:1"},
+		{IP: "2001:db8::5/64", Device: "eth1"},
+	}
+	desired := []Address{
+		{IP: "10.0.0.5/24", Device: "eth0", Label: "eth0", Scope: "global"}, // unchanged after normalization
+		{IP: "10.0.1.5/24", Device: "eth0", Label: "eth0:2"},
+		{IP: "2001:DB8::6/64", Device: "eth1", Flags: []string{"NoDAD", "noprefixroute", "nodad"}},
+	}
+
+	res, err := DiffAddresses(old, desired)
+	if err != nil {
+		t.Fatalf("DiffAddresses() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 1 || len(res.ToDel) != 1 || len(res.ToReplace) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToReplace[0].New.Label != "eth0:2" {
+		t.Fatalf("unexpected replace: %+v", res.ToReplace[0])
+	}
+	add := res.ToAdd[0]
+	if add.IP != "2001:db8::6/64" || len(add.Flags) != 2 || add.Flags[0] != "nodad" {
+		t.Fatalf("address not normalized, got %+v", add)
+	}
+
+	for _, a := range []Address{
+		{IP: "10.0.0.5/24"},
+		{IP: "10.0.0.5/24", Device: "eth0", Label: "wan"},
+		{IP: "10.0.0.5/24", Device: "eth0", Flags: []string{"permanent"}},
+		{IP: "10.0.0.5/24", Device: "eth0", ValidLft: 10, PreferredLft: 20},
+	} {
+		if _, err := a.Normalize(); err == nil {
+			t.Fatalf("expected %+v to be rejected", a)
+		}
+	}
+}
+
+func TestDiffNeighbors(t *testing.T) {
+	old := []Neighbor{
+		{IP: "10.0.0.1", LLAddr: "52:54:00:00:00:01", Device: "eth0"},
+		{IP: "10.0.0.2", LLAddr: "52:54:00:00:00:02", Device: "eth0"},
+		{IP: "10.0.0.9", Device: "eth0", Proxy: true},
+	}
+	desired := []Neighbor{
+		{IP: "10.0.0.1", LLAddr: "52-54-00-00-00-01", Device: "eth0", State: "PERMANENT"}, // unchanged after normalization
+		{IP: "10.0.0.2", LLAddr: "52:54:00:00:00:03", Device: "eth0"},
+		{IP: "fe80::1", LLAddr: "52:54:00:00:00:04", Device: "eth1", State: "noarp"},
+	}
+
+	res, err := DiffNeighbors(old, desired)
+	if err != nil {
+		t.Fatalf("DiffNeighbors() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 1 || len(res.ToDel) != 1 || len(res.ToReplace) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToReplace[0].New.LLAddr != "52:54:00:00:00:03" || !res.ToDel[0].Proxy {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+
+	for _, n := range []Neighbor{
+		{IP: "10.0.0.1", Device: "eth0"},
+		{IP: "10.0.0.1", LLAddr: "52:54:00:00:00:01", Device: "eth0", State: "reachable"},
+		{IP: "10.0.0.1", LLAddr: "52:54:00:00:00:01", Device: "eth0", Proxy: true},
+	} {
+		if _, err := n.Normalize(); err == nil {
+			t.Fatalf("expected %+v to be rejected", n)
+		}
+	}
+}
+
+func TestDiffLinks(t *testing.T) {
+	old := []Link{
+		{Name: "br0", Kind: "bridge"},
+		{Name: "vx0", Kind: "vxlan", VNI: 42, Master: "br0"},
+		{Name: "old0", Kind: "dummy", Master: "br0"},
+	}
+	desired := []Link{
+		{Name: "vlan10", Kind: "vlan", Parent: "eth0", VLANID: 10, Master: "br1"},
+		{Name: "br1", Kind: "BRIDGE", State: "up"},
+		{Name: "br0", Kind: "bridge"},
+		{Name: "vx0", Kind: "vxlan", VNI: 43, Master: "br0"},
+	}
+
+	res, err := DiffLinks(old, desired)
+	if err != nil {
+		t.Fatalf("DiffLinks() error: %v", err)
+	}
+	if len(res.Unchanged) != 1 || len(res.ToAdd) != 2 || len(res.ToDel) != 1 || len(res.ToReplace) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	// Masters are added before the links using them.
+	if res.ToAdd[0].Name != "br1" || res.ToAdd[1].Name != "vlan10" {
+		t.Fatalf("unexpected add order: %+v", res.ToAdd)
+	}
+	if res.ToReplace[0].New.VNI != 43 || res.ToReplace[0].New.Port != 4789 {
+		t.Fatalf("unexpected replace: %+v", res.ToReplace)
+	}
+
+	if _, err := DiffLinks(nil, []Link{
+		{Name: "br0", Kind: "bridge", Master: "br1"},
+		{Name: "br1", Kind: "bridge", Master: "br0"},
+	}); err == nil {
+		t.Fatalf("expected a master cycle to be rejected")
+	}
+	for _, l := range []Link{
+		{Name: "eth0", Kind: "veth"},
+		{Name: "vlan10", Kind: "vlan", VLANID: 10},
+		{Name: "br0", Kind: "bridge", VNI: 1},
+		{Name: "gre1", Kind: "gre", Remote: "2001:db8::1"},
+	} {
+		if _, err := l.Normalize(); err == nil {
+			t.Fatalf("expected %+v to be rejected", l)
+		}
+	}
+}
+
+func TestDiffRoutesKernelIdentity(t *testing.T) {
+	old := []Route{
+		{Dst: "10.0.0.0/8", Gateway: "10.0.0.1"},
+		{Dst: "2001:db8::/32", Gateway: "2001:db8::1"},
+		{Dst: "10.1.0.0/16", Gateway: "10.0.0.1", Metric: 10},
+	}
+	desired := []Route{
+		{Dst: "10.0.0.0/8", Gateway: "10.0.0.2", Device: "eth1", Table: 254}, // table 0 is main
+		{Dst: "2001:db8::/32", Gateway: "2001:db8::2", Metric: 1024},         // IPv6 metric 0 is 1024
+		{Dst: "10.1.0.0/16", Gateway: "10.0.0.1", Metric: 20},                // identity changed
+	}
+
+	res, err := DiffRoutesWithIdentity(old, desired, KernelIdentity)
+	if err != nil {
+		t.Fatalf("DiffRoutesWithIdentity() error: %v", err)
+	}
+	if len(res.ToReplace) != 2 || len(res.ToDel) != 1 || len(res.ToAdd) != 1 {
+		t.Fatalf("unexpected diff: %+v", res)
+	}
+	if res.ToDel[0].Metric != 10 || res.ToAdd[0].Metric != 20 {
+		t.Fatalf("unexpected identity change: %+v", res)
+	}
+	want := map[string]string{"10.0.0.0/8": "gateway,device,table", "2001:db8::/32": "gateway,metric"}
+	for _, rc := range res.ToReplace {
+		if got := strings.Join(rc.Changed, ","); got != want[rc.New.Dst] {
+			t.Fatalf("%s: changed = %q, want %q", rc.New.Dst, got, want[rc.New.Dst])
+		}
+	}
+
+	// The family and the TOS are part of the identity.
+	for _, pair := range [][2]Route{
+		{{Dst: "default", Device: "eth0"}, {Dst: "::/0", Device: "eth0"}},
+		{{Dst: "default", Device: "eth0", Metric: 1024}, {Dst: "default", Gateway: "fe80::1", Device: "eth0"}},
+		{{Dst: "10.0.0.0/8", Device: "eth0"}, {Dst: "10.0.0.0/8", Device: "eth0", TOS: 0x10}},
+	} {
+		a, _ := pair[0].Normalize()
+		b, _ := pair[1].Normalize()
+		if KernelIdentity(a) == KernelIdentity(b) {
+			t.Fatalf("%+v and %+v share the kernel identity %q", a, b, KernelIdentity(a))
+		}
+	}
+	if _, err := (Route{Dst: "2001:db8::/32", Device: "eth0", TOS: 0x10}).Normalize(); err == nil {
+		t.Fatalf("expected tos to be rejected for an IPv6 route")
+	}
+
+	// DstTableMetricIdentity compares table and metric as given.
+	res, err = DiffRoutesWithIdentity(old, desired, DstTableMetricIdentity)
+	if err != nil {
+		t.Fatalf("DiffRoutesWithIdentity() error: %v", err)
+	}
+	if len(res.ToReplace) != 0 {
+		t.Fatalf("unexpected replaces: %+v", res.ToReplace)
+	}
+}
+
+func TestDiffRoutesMetrics(t *testing.T) {
+	old := []Route{{Dst: "10.0.0.0/8", Gateway: "10.0.0.1", Metrics: &RouteMetrics{MTU: 1500}}}
+	desired := []Route{{Dst: "10.0.0.0/8", Gateway: "10.0.0.1", Metrics: &RouteMetrics{MTU: 1400, InitCwnd: 10}}}
+
+	res, err := DiffRoutesWithIdentity(old, desired, DstTableMetricIdentity)
+	if err != nil {
+		t.Fatalf("DiffRoutesWithIdentity() error: %v", err)
+	}
+	if len(res.ToReplace) != 1 || res.ToReplace[0].New.Metrics.MTU != 1400 {
+		t.Fatalf("expected metrics change to be a replace, got %+v", res)
+	}
+
+	// Empty metrics are dropped, so they do not change the key.
+	a, _ := Route{Dst: "10.0.0.0/8", Metrics: &RouteMetrics{}}.Key()
+	b, _ := Route{Dst: "10.0.0.0/8"}.Key()
+	if a != b {
+		t.Fatalf("empty metrics changed the key: %q vs %q", a, b)
+	}
+}
@@ -0,0 +1,318 @@
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// tableMain is the kernel main routing table (RT_TABLE_MAIN); Route.Table 0 means main.
+const tableMain = 254
+
+// DriftReport describes how the live routing table differs from the desired routes.
+type DriftReport struct {
+	// Missing are desired routes not present on the system.
+	Missing []Route `json:"missing,omitempty"`
+	// Extra are owned routes present on the system but no longer desired.
+	Extra []Route `json:"extra,omitempty"`
+	// Modified pairs a live owned route (Old) with the desired route (New)
+	// that shares its kernel slot (see KernelIdentity: family, dst, table or VRF,
+	// metric and TOS) but differs in other fields.
+	Modified []RouteChange `json:"modified,omitempty"`
+}
+
+// InSync reports whether no drift was found.
+func (d DriftReport) InSync() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Modified) == 0
+}
+
+// DetectDrift compares desiredRoutes against Manager.List().
+//
+// Only routes we own are considered: a live route is owned if it matches a route
+// in the Store baseline or in desiredRoutes, or takes the kernel slot of a baseline
+// route (same KernelIdentity: family, dst, table or VRF, metric and TOS), e.g. its
+// gateway was changed by hand. Fields left empty/zero in a desired or
+// baseline route act as wildcards, since the kernel fills them in (e.g. proto, scope).
+// A route in the table of a VRF known to c (see VRFs, VRFManager) is compared by
+// VRF name, so "table 10" matches a live route reported as "vrf blue".
+//
+// With OwnerProto set, ownership is decided by the route protocol instead, so
+// routes installed by the kernel, DHCP or routing daemons are never reported as Extra.
+func (c Controller) DetectDrift(ctx context.Context, desiredRoutes []Route) (DriftReport, error) {
+	if c.Manager == nil {
+		return DriftReport{}, fmt.Errorf("manager is nil")
+	}
+	if c.Store == nil {
+		return DriftReport{}, fmt.Errorf("store is nil")
+	}
+
+	baseline, err := c.Store.Load()
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("load old routes: %w", err)
+	}
+	baseline, err = normalizeRoutes(baseline)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("old routes: %w", err)
+	}
+	desiredRoutes, err = c.stamp(desiredRoutes)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	desired, err := normalizeRoutes(desiredRoutes)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("desired routes: %w", err)
+	}
+
+	live, err := c.Manager.List(ctx)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("list live routes: %w", err)
+	}
+	live, err = normalizeRoutes(live)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("live routes: %w", err)
+	}
+	vrfs, err := c.vrfNames(ctx)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	baseline, desired, live = inVRFs(baseline, vrfs), inVRFs(desired, vrfs), inVRFs(live, vrfs)
+
+	owned := make([]Route, 0, len(live))
+	for _, l := range live {
+		if c.ownerProto() != "" {
+			if c.ownedByProto(l) {
+				owned = append(owned, l)
+			}
+			continue
+		}
+		if matchesAny(baseline, l) || matchesAny(desired, l) || slotAny(baseline, l) {
+			owned = append(owned, l)
+		}
+	}
+
+	var rep DriftReport
+	used := make([]bool, len(owned))
+	for _, d := range desired {
+		found := false
+		for i, l := range owned {
+			if matchesLive(d, l) {
+				used[i] = true
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+		modified := false
+		for i, l := range owned {
+			if !used[i] && sameSlot(d, l) {
+				used[i] = true
+				rep.Modified = append(rep.Modified, RouteChange{Old: l, New: d})
+				modified = true
+				break
+			}
+		}
+		if !modified {
+			rep.Missing = append(rep.Missing, d)
+		}
+	}
+	for i, l := range owned {
+		if !used[i] {
+			rep.Extra = append(rep.Extra, l)
+		}
+	}
+
+	sortRoutes(rep.Missing)
+	sortRoutes(rep.Extra)
+
+	return rep, nil
+}
+
+// ReconcileLive repairs drift between desiredRoutes and the live routing table:
+// extra routes are deleted, modified routes are replaced and missing routes are added.
+// desiredRoutes is saved as the new baseline only if every repair succeeded.
+// The safety guards apply to the extra routes to delete.
+func (c Controller) ReconcileLive(ctx context.Context, desiredRoutes []Route) (DriftReport, error) {
+	p, err := c.planLive(ctx, desiredRoutes)
+	if err != nil {
+		return p.rep, err
+	}
+	if err := c.ensureVRFs(ctx, p.diff); err != nil {
+		return p.rep, err
+	}
+
+	var applyErrs []error
+	if _, err := c.applyOps(ctx, p.ops); err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+
+	if len(applyErrs) == 0 {
+		if err := c.Store.Save(p.desired); err != nil {
+			applyErrs = append(applyErrs, fmt.Errorf("save applied routes: %w", err))
+		}
+	}
+
+	return p.rep, errors.Join(applyErrs...)
+}
+
+// PlanLive computes what ReconcileLive would do with desiredRoutes, checking
+// the safety guards, without changing the system.
+func (c Controller) PlanLive(ctx context.Context, desiredRoutes []Route) (DriftReport, error) {
+	p, err := c.planLive(ctx, desiredRoutes)
+	return p.rep, err
+}
+
+// livePlan is the repair of the drift found by DetectDrift.
+type livePlan struct {
+	rep  DriftReport
+	diff DiffResult
+	ops  []RouteOp
+	// desired is the new baseline.
+	desired []Route
+}
+
+func (c Controller) planLive(ctx context.Context, desiredRoutes []Route) (livePlan, error) {
+	var p livePlan
+	var err error
+	p.rep, err = c.DetectDrift(ctx, desiredRoutes)
+	if err != nil {
+		return livePlan{}, err
+	}
+	rep := p.rep
+
+	baseline, err := c.Store.Load()
+	if err != nil {
+		return p, fmt.Errorf("load old routes: %w", err)
+	}
+	if err := c.checkDeletes(len(baseline), len(desiredRoutes), rep.Extra); err != nil {
+		return p, err
+	}
+
+	p.diff = DiffResult{ToDel: rep.Extra, ToReplace: rep.Modified, ToAdd: rep.Missing}
+	// Routes in sync still reach gateways for the ones to add.
+	desiredRoutes, _ = c.stamp(desiredRoutes)    // validated by DetectDrift
+	desired, _ := normalizeRoutes(desiredRoutes) // validated by DetectDrift
+	vrfs, err := c.vrfNames(ctx)
+	if err != nil {
+		return p, err
+	}
+	p.desired = inVRFs(desired, vrfs)
+	sortRoutes(p.desired)
+	repaired := make(map[string]bool, len(rep.Missing)+len(rep.Modified))
+	for _, r := range rep.Missing {
+		k, _ := r.Key()
+		repaired[k] = true
+	}
+	for _, rc := range rep.Modified {
+		k, _ := rc.New.Key()
+		repaired[k] = true
+	}
+	for _, r := range p.desired {
+		if k, _ := r.Key(); !repaired[k] {
+			p.diff.Unchanged = append(p.diff.Unchanged, r)
+		}
+	}
+	p.ops, err = planOps(p.diff, routeDeps{checkGateways: c.CheckGateways, strategy: c.ApplyStrategy})
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func normalizeRoutes(routes []Route) ([]Route, error) {
+	out := make([]Route, 0, len(routes))
+	for i, r := range routes {
+		n, err := r.Normalize()
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func matchesAny(specs []Route, live Route) bool {
+	for _, s := range specs {
+		if matchesLive(s, live) {
+			return true
+		}
+	}
+	return false
+}
+
+func slotAny(specs []Route, live Route) bool {
+	for _, s := range specs {
+		if sameSlot(s, live) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesLive reports whether a normalized live route satisfies a normalized spec.
+// Empty spec fields match anything; table 0 means main and metric 0 means the kernel default.
+func matchesLive(spec, live Route) bool {
+	if !sameSlot(spec, live) {
+		return false
+	}
+	if spec.Gateway != live.Gateway || spec.Device != "" && spec.Device != live.Device || spec.Onlink != live.Onlink {
+		return false
+	}
+	if spec.Src != "" && spec.Src != live.Src {
+		return false
+	}
+	if spec.Scope != "" && spec.Scope != live.Scope {
+		return false
+	}
+	if spec.Type != live.Type {
+		return false
+	}
+	if spec.Proto != "" && spec.Proto != live.Proto {
+		return false
+	}
+	if spec.NexthopID != live.NexthopID {
+		return false
+	}
+	if spec.Family != live.Family || formatMPLSLabels(spec.NewDst) != formatMPLSLabels(live.NewDst) {
+		return false
+	}
+	if (spec.Encap == nil) != (live.Encap == nil) || spec.Encap != nil && spec.Encap.String() != live.Encap.String() {
+		return false
+	}
+	if len(spec.Nexthops) != len(live.Nexthops) {
+		return false
+	}
+	for i := range spec.Nexthops {
+		if spec.Nexthops[i].key() != live.Nexthops[i].key() {
+			return false
+		}
+	}
+	if spec.Metrics != nil {
+		var lm RouteMetrics
+		if live.Metrics != nil {
+			lm = *live.Metrics
+		}
+		return spec.Metrics.matches(lm)
+	}
+	return true
+}
+
+// sameSlot reports whether spec and live occupy the same kernel slot (see KernelIdentity).
+func sameSlot(spec, live Route) bool {
+	return KernelIdentity(spec) == KernelIdentity(live)
+}
+
+func effectiveTable(t int) int {
+	if t == 0 {
+		return tableMain
+	}
+	return t
+}
+
+// effectiveMetric returns the metric the kernel assigns: IPv6 routes default to 1024.
+func effectiveMetric(r Route) int {
+	if r.Metric == 0 && routeFamily(r) == "inet6" {
+		return 1024
+	}
+	return r.Metric
+}
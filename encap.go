@@ -0,0 +1,296 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Encap describes a lightweight tunnel encapsulation ("ip route ... encap <type> ...").
+//
+// Type selects which of the other fields apply:
+//   - "mpls": Labels ("encap mpls 100/200").
+//   - "seg6": Mode ("encap" or "inline", default "encap") and Segments, in the
+//     order packets visit them ("encap seg6 mode encap segs fc00::1,fc00::2").
+//   - "seg6local": Action (End, End.X, End.T, End.DX2, End.DX4, End.DX6,
+//     End.DT4, End.DT6, End.B6, End.B6.Encaps) with its Table, VRFTable,
+//     NH4, NH6, IIF, OIF and Segments ("encap seg6local action End.DX4 nh4 10.0.0.1").
+//   - "bpf": In/Out/Xmit are paths of programs pinned in bpffs, Headroom the
+//     xmit headroom ("encap bpf xmit pinned /sys/fs/bpf/lwt headroom 14").
+//   - "ip", "ip6": an IP tunnel for a collect-metadata device, with ID, Src,
+//     Dst, TTL (hoplimit) and TOS (tc) ("encap ip id 100 dst 192.0.2.1 ttl 64").
+//
+// Setting a field that does not belong to Type is an error.
+type Encap struct {
+	Type string `json:"type"`
+
+	Labels []int `json:"labels,omitempty"`
+
+	Mode     string   `json:"mode,omitempty"`
+	Segments []string `json:"segments,omitempty"`
+
+	Action   string `json:"action,omitempty"`
+	Table    int    `json:"table,omitempty"`
+	VRFTable int    `json:"vrftable,omitempty"`
+	NH4      string `json:"nh4,omitempty"`
+	NH6      string `json:"nh6,omitempty"`
+	IIF      string `json:"iif,omitempty"`
+	OIF      string `json:"oif,omitempty"`
+
+	In       string `json:"in,omitempty"`
+	Out      string `json:"out,omitempty"`
+	Xmit     string `json:"xmit,omitempty"`
+	Headroom int    `json:"headroom,omitempty"`
+
+	ID  uint64 `json:"id,omitempty"`
+	Src string `json:"src,omitempty"`
+	Dst string `json:"dst,omitempty"`
+	TTL int    `json:"ttl,omitempty"`
+	TOS int    `json:"tos,omitempty"`
+}
+
+// encapFields lists the fields each encap type may set.
+var encapFields = map[string][]string{
+	"mpls":      {"labels"},
+	"seg6":      {"mode", "segments"},
+	"seg6local": {"action", "table", "vrftable", "nh4", "nh6", "iif", "oif", "segments"},
+	"bpf":       {"in", "out", "xmit", "headroom"},
+	"ip":        {"id", "src", "dst", "ttl", "tos"},
+	"ip6":       {"id", "src", "dst", "ttl", "tos"},
+}
+
+// seg6LocalActions holds the canonical spelling of the supported seg6local actions.
+var seg6LocalActions = []string{
+	"End", "End.X", "End.T", "End.DX2", "End.DX6", "End.DX4",
+	"End.DT6", "End.DT4", "End.B6", "End.B6.Encaps",
+}
+
+// lwtBPFMaxHeadroom is LWT_BPF_MAX_HEADROOM.
+const lwtBPFMaxHeadroom = 256
+
+// Normalize canonicalizes an encapsulation. It returns a copy of e.
+func (e Encap) Normalize() (Encap, error) {
+	out := e
+	out.Type = strings.ToLower(strings.TrimSpace(out.Type))
+	allowed, ok := encapFields[out.Type]
+	if !ok {
+		return Encap{}, fmt.Errorf("unsupported encap type %q", e.Type)
+	}
+	for _, f := range out.setFields() {
+		if !containsString(allowed, f) {
+			return Encap{}, fmt.Errorf("encap %s does not take %s", out.Type, f)
+		}
+	}
+
+	var err error
+	switch out.Type {
+	case "mpls":
+		if len(out.Labels) == 0 {
+			return Encap{}, fmt.Errorf("encap mpls requires labels")
+		}
+		if err := checkMPLSLabels(out.Labels); err != nil {
+			return Encap{}, err
+		}
+		out.Labels = append([]int(nil), out.Labels...)
+	case "seg6":
+		out.Mode = strings.ToLower(strings.TrimSpace(out.Mode))
+		switch out.Mode {
+		case "":
+			out.Mode = "encap"
+		case "encap", "inline":
+		default:
+			return Encap{}, fmt.Errorf("unsupported encap seg6 mode %q", e.Mode)
+		}
+		if len(out.Segments) == 0 {
+			return Encap{}, fmt.Errorf("encap seg6 requires segments")
+		}
+		if out.Segments, err = normalizeSegments(out.Segments); err != nil {
+			return Encap{}, err
+		}
+	case "seg6local":
+		action := strings.TrimSpace(out.Action)
+		out.Action = ""
+		for _, a := range seg6LocalActions {
+			if strings.EqualFold(a, action) {
+				out.Action = a
+			}
+		}
+		if out.Action == "" {
+			return Encap{}, fmt.Errorf("unsupported encap seg6local action %q", e.Action)
+		}
+		if out.Table < 0 || out.VRFTable < 0 {
+			return Encap{}, fmt.Errorf("encap seg6local table must be >= 0")
+		}
+		if out.NH4, err = normalizeEncapIP(out.NH4, "nh4", false); err != nil {
+			return Encap{}, err
+		}
+		if out.NH6, err = normalizeEncapIP(out.NH6, "nh6", true); err != nil {
+			return Encap{}, err
+		}
+		out.IIF = strings.TrimSpace(out.IIF)
+		out.OIF = strings.TrimSpace(out.OIF)
+		if len(out.Segments) > 0 {
+			if out.Segments, err = normalizeSegments(out.Segments); err != nil {
+				return Encap{}, err
+			}
+		} else {
+			out.Segments = nil
+		}
+	case "bpf":
+		out.In = strings.TrimSpace(out.In)
+		out.Out = strings.TrimSpace(out.Out)
+		out.Xmit = strings.TrimSpace(out.Xmit)
+		if out.In == "" && out.Out == "" && out.Xmit == "" {
+			return Encap{}, fmt.Errorf("encap bpf requires an in, out or xmit program")
+		}
+		if out.Headroom < 0 || out.Headroom > lwtBPFMaxHeadroom {
+			return Encap{}, fmt.Errorf("encap bpf headroom must be in [0, %d]", lwtBPFMaxHeadroom)
+		}
+		if out.Headroom != 0 && out.Xmit == "" {
+			return Encap{}, fmt.Errorf("encap bpf headroom requires an xmit program")
+		}
+	case "ip", "ip6":
+		v6 := out.Type == "ip6"
+		if out.Dst == "" {
+			return Encap{}, fmt.Errorf("encap %s requires dst", out.Type)
+		}
+		if out.Dst, err = normalizeEncapIP(out.Dst, "dst", v6); err != nil {
+			return Encap{}, err
+		}
+		if out.Src, err = normalizeEncapIP(out.Src, "src", v6); err != nil {
+			return Encap{}, err
+		}
+		if out.TTL < 0 || out.TTL > 255 || out.TOS < 0 || out.TOS > 255 {
+			return Encap{}, fmt.Errorf("encap %s ttl/tos must be in [0, 255]", out.Type)
+		}
+	}
+	return out, nil
+}
+
+// setFields returns the JSON names of the non-empty type-specific fields.
+func (e Encap) setFields() []string {
+	var fs []string
+	add := func(name string, set bool) {
+		if set {
+			fs = append(fs, name)
+		}
+	}
+	add("labels", len(e.Labels) > 0)
+	add("mode", e.Mode != "")
+	add("segments", len(e.Segments) > 0)
+	add("action", e.Action != "")
+	add("table", e.Table != 0)
+	add("vrftable", e.VRFTable != 0)
+	add("nh4", e.NH4 != "")
+	add("nh6", e.NH6 != "")
+	add("iif", e.IIF != "")
+	add("oif", e.OIF != "")
+	add("in", e.In != "")
+	add("out", e.Out != "")
+	add("xmit", e.Xmit != "")
+	add("headroom", e.Headroom != 0)
+	add("id", e.ID != 0)
+	add("src", e.Src != "")
+	add("dst", e.Dst != "")
+	add("ttl", e.TTL != 0)
+	add("tos", e.TOS != 0)
+	return fs
+}
+
+// String returns the encapsulation in "ip route" form (e.g. "mpls 100/200").
+func (e Encap) String() string {
+	b := []string{e.Type}
+	add := func(k, v string) {
+		if v != "" {
+			b = append(b, k, v)
+		}
+	}
+	addInt := func(k string, v int) {
+		if v != 0 {
+			b = append(b, k, strconv.Itoa(v))
+		}
+	}
+	switch e.Type {
+	case "mpls":
+		b = append(b, formatMPLSLabels(e.Labels))
+	case "seg6":
+		add("mode", e.Mode)
+		add("segs", strings.Join(e.Segments, ","))
+	case "seg6local":
+		add("action", e.Action)
+		addInt("table", e.Table)
+		addInt("vrftable", e.VRFTable)
+		add("nh4", e.NH4)
+		add("nh6", e.NH6)
+		add("iif", e.IIF)
+		add("oif", e.OIF)
+		if len(e.Segments) > 0 {
+			b = append(b, "srh", "segs", strings.Join(e.Segments, ","))
+		}
+	case "bpf":
+		for _, p := range []struct{ hook, path string }{{"in", e.In}, {"out", e.Out}, {"xmit", e.Xmit}} {
+			if p.path != "" {
+				b = append(b, p.hook, "pinned", p.path)
+			}
+		}
+		addInt("headroom", e.Headroom)
+	case "ip", "ip6":
+		if e.ID != 0 {
+			b = append(b, "id", strconv.FormatUint(e.ID, 10))
+		}
+		add("src", e.Src)
+		add("dst", e.Dst)
+		ttl, tos := "ttl", "tos"
+		if e.Type == "ip6" {
+			ttl, tos = "hoplimit", "tc"
+		}
+		addInt(ttl, e.TTL)
+		if e.TOS != 0 {
+			// Parsed as a DS field, like the route tos.
+			b = append(b, tos, fmt.Sprintf("0x%02x", e.TOS))
+		}
+	}
+	return strings.Join(b, " ")
+}
+
+func normalizeSegments(segs []string) ([]string, error) {
+	out := make([]string, 0, len(segs))
+	for _, s := range segs {
+		n, err := normalizeEncapIP(s, "segment", true)
+		if err != nil {
+			return nil, err
+		}
+		if n == "" {
+			return nil, fmt.Errorf("empty encap segment")
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// normalizeEncapIP canonicalizes an optional address of the given family.
+func normalizeEncapIP(s, what string, v6 bool) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || (ip.To4() == nil) != v6 {
+		fam := "IPv4"
+		if v6 {
+			fam = "IPv6"
+		}
+		return "", fmt.Errorf("invalid encap %s %q: want an %s address", what, s, fam)
+	}
+	return ip.String(), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// bpfObjGet is the BPF_OBJ_GET command of the bpf(2) syscall.
+const bpfObjGet = 7
+
+// bpfPrograms holds the descriptors of pinned BPF programs opened for a request.
+type bpfPrograms struct {
+	fds []int
+}
+
+// open returns a descriptor for the program pinned at path.
+func (p *bpfPrograms) open(path string) (int, error) {
+	name, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	// union bpf_attr for BPF_OBJ_GET: pathname, bpf_fd, file_flags.
+	attr := struct {
+		pathname  uint64
+		bpfFD     uint32
+		fileFlags uint32
+	}{pathname: uint64(uintptr(unsafe.Pointer(name)))}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, bpfObjGet, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	// attr only holds name as an integer, which does not keep it alive.
+	runtime.KeepAlive(name)
+	if errno != 0 {
+		return 0, fmt.Errorf("bpf program %q: %w", path, errno)
+	}
+	p.fds = append(p.fds, int(fd))
+	return int(fd), nil
+}
+
+// Close releases every opened program.
+func (p *bpfPrograms) Close() {
+	for _, fd := range p.fds {
+		unix.Close(fd)
+	}
+	p.fds = nil
+}
+
+var seg6LocalActionCodes = map[string]int{
+	"End":           nl.SEG6_LOCAL_ACTION_END,
+	"End.X":         nl.SEG6_LOCAL_ACTION_END_X,
+	"End.T":         nl.SEG6_LOCAL_ACTION_END_T,
+	"End.DX2":       nl.SEG6_LOCAL_ACTION_END_DX2,
+	"End.DX6":       nl.SEG6_LOCAL_ACTION_END_DX6,
+	"End.DX4":       nl.SEG6_LOCAL_ACTION_END_DX4,
+	"End.DT6":       nl.SEG6_LOCAL_ACTION_END_DT6,
+	"End.DT4":       nl.SEG6_LOCAL_ACTION_END_DT4,
+	"End.B6":        nl.SEG6_LOCAL_ACTION_END_B6,
+	"End.B6.Encaps": nl.SEG6_LOCAL_ACTION_END_B6_ENCAPS,
+}
+
+// toNetlinkEncap converts a normalized encapsulation. See toNetlinkRoute for progs.
+func toNetlinkEncap(h *netlink.Handle, e Encap, progs *bpfPrograms) (netlink.Encap, error) {
+	switch e.Type {
+	case "mpls":
+		return &netlink.MPLSEncap{Labels: append([]int(nil), e.Labels...)}, nil
+	case "seg6":
+		enc := &netlink.SEG6Encap{Mode: nl.SEG6_IPTUN_MODE_ENCAP, Segments: toSRH(e.Segments)}
+		if e.Mode == "inline" {
+			enc.Mode = nl.SEG6_IPTUN_MODE_INLINE
+		}
+		return enc, nil
+	case "seg6local":
+		enc := &netlink.SEG6LocalEncap{Action: seg6LocalActionCodes[e.Action]}
+		enc.Flags[nl.SEG6_LOCAL_ACTION] = true
+		if len(e.Segments) > 0 {
+			enc.Segments = toSRH(e.Segments)
+			enc.Flags[nl.SEG6_LOCAL_SRH] = true
+		}
+		if e.Table != 0 {
+			enc.Table = e.Table
+			enc.Flags[nl.SEG6_LOCAL_TABLE] = true
+		}
+		if e.VRFTable != 0 {
+			enc.VrfTable = e.VRFTable
+			enc.Flags[nl.SEG6_LOCAL_VRFTABLE] = true
+		}
+		if e.NH4 != "" {
+			enc.InAddr = net.ParseIP(e.NH4).To4()
+			enc.Flags[nl.SEG6_LOCAL_NH4] = true
+		}
+		if e.NH6 != "" {
+			enc.In6Addr = net.ParseIP(e.NH6).To16()
+			enc.Flags[nl.SEG6_LOCAL_NH6] = true
+		}
+		if e.IIF != "" {
+			idx, err := linkIndex(h, e.IIF)
+			if err != nil {
+				return nil, err
+			}
+			enc.Iif = idx
+			enc.Flags[nl.SEG6_LOCAL_IIF] = true
+		}
+		if e.OIF != "" {
+			idx, err := linkIndex(h, e.OIF)
+			if err != nil {
+				return nil, err
+			}
+			enc.Oif = idx
+			enc.Flags[nl.SEG6_LOCAL_OIF] = true
+		}
+		return enc, nil
+	case "bpf":
+		if progs == nil {
+			return nil, nil
+		}
+		enc := &netlink.BpfEncap{}
+		for _, p := range []struct {
+			mode int
+			path string
+		}{{nl.LWT_BPF_IN, e.In}, {nl.LWT_BPF_OUT, e.Out}, {nl.LWT_BPF_XMIT, e.Xmit}} {
+			if p.path == "" {
+				continue
+			}
+			fd, err := progs.open(p.path)
+			if err != nil {
+				return nil, err
+			}
+			if err := enc.SetProg(p.mode, fd, p.path); err != nil {
+				return nil, err
+			}
+		}
+		if err := enc.SetXmitHeadroom(e.Headroom); err != nil {
+			return nil, err
+		}
+		return enc, nil
+	case "ip", "ip6":
+		return &ipTunnelEncap{e: e}, nil
+	default:
+		return nil, fmt.Errorf("encap %s is not supported by IPRouteManager", e.Type)
+	}
+}
+
+// LWTUNNEL_IP_* attributes; the LWTUNNEL_IP6_* ones share the numbers,
+// with hoplimit and tc in place of ttl and tos.
+const (
+	lwtunnelIPID  = 1
+	lwtunnelIPDst = 2
+	lwtunnelIPSrc = 3
+	lwtunnelIPTTL = 4
+	lwtunnelIPTOS = 5
+)
+
+// ipTunnelEncap is a normalized "ip" or "ip6" encap as a netlink.Encap,
+// which the netlink library can neither encode nor decode.
+type ipTunnelEncap struct {
+	e Encap
+}
+
+func (t *ipTunnelEncap) Type() int {
+	if t.e.Type == "ip6" {
+		return nl.LWTUNNEL_ENCAP_IP6
+	}
+	return nl.LWTUNNEL_ENCAP_IP
+}
+
+func (t *ipTunnelEncap) Decode(b []byte) error {
+	e, ok := decodeIPTunnelEncap(t.Type(), b)
+	if !ok {
+		return fmt.Errorf("invalid encap %s", t.e.Type)
+	}
+	t.e = e
+	return nil
+}
+
+func (t *ipTunnelEncap) Encode() ([]byte, error) {
+	ip := func(s string) []byte {
+		v := net.ParseIP(s)
+		if v4 := v.To4(); v4 != nil && t.e.Type == "ip" {
+			return v4
+		}
+		return v
+	}
+	var b []byte
+	add := func(typ int, v []byte) { b = append(b, nl.NewRtAttr(typ, v).Serialize()...) }
+	// The tunnel id is big endian.
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, t.e.ID)
+	add(lwtunnelIPID, id)
+	add(lwtunnelIPDst, ip(t.e.Dst))
+	if t.e.Src != "" {
+		add(lwtunnelIPSrc, ip(t.e.Src))
+	}
+	if t.e.TTL != 0 {
+		add(lwtunnelIPTTL, []byte{uint8(t.e.TTL)})
+	}
+	if t.e.TOS != 0 {
+		add(lwtunnelIPTOS, []byte{uint8(t.e.TOS)})
+	}
+	return b, nil
+}
+
+func (t *ipTunnelEncap) String() string { return t.e.String() }
+
+func (t *ipTunnelEncap) Equal(x netlink.Encap) bool {
+	o, ok := x.(*ipTunnelEncap)
+	return ok && o.e.String() == t.e.String()
+}
+
+// decodeIPTunnelEncap decodes the RTA_ENCAP attributes of an encap of type
+// typ; ok is false if it is not an ip/ip6 encap.
+func decodeIPTunnelEncap(typ int, b []byte) (Encap, bool) {
+	out := Encap{Type: "ip"}
+	switch typ {
+	case nl.LWTUNNEL_ENCAP_IP:
+	case nl.LWTUNNEL_ENCAP_IP6:
+		out.Type = "ip6"
+	default:
+		return Encap{}, false
+	}
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return Encap{}, false
+	}
+	ip := func(v []byte) string {
+		if ip := net.IP(v); len(v) == net.IPv4len || len(v) == net.IPv6len {
+			if !ip.IsUnspecified() {
+				return ip.String()
+			}
+		}
+		return ""
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case lwtunnelIPID:
+			if len(a.Value) == 8 {
+				out.ID = binary.BigEndian.Uint64(a.Value)
+			}
+		case lwtunnelIPDst:
+			out.Dst = ip(a.Value)
+		case lwtunnelIPSrc:
+			out.Src = ip(a.Value)
+		case lwtunnelIPTTL:
+			if len(a.Value) > 0 {
+				out.TTL = int(a.Value[0])
+			}
+		case lwtunnelIPTOS:
+			if len(a.Value) > 0 {
+				out.TOS = int(a.Value[0])
+			}
+		}
+	}
+	return out, out.Dst != ""
+}
+
+// fromNetlinkEncap converts a kernel encapsulation; ok is false for unsupported types.
+func fromNetlinkEncap(h *netlink.Handle, enc netlink.Encap) (Encap, bool) {
+	switch e := enc.(type) {
+	case *netlink.MPLSEncap:
+		return Encap{Type: "mpls", Labels: append([]int(nil), e.Labels...)}, true
+	case *netlink.SEG6Encap:
+		out := Encap{Type: "seg6", Mode: "encap", Segments: fromSRH(e.Segments)}
+		if e.Mode == nl.SEG6_IPTUN_MODE_INLINE {
+			out.Mode = "inline"
+		}
+		return out, true
+	case *netlink.SEG6LocalEncap:
+		out := Encap{Type: "seg6local"}
+		for name, code := range seg6LocalActionCodes {
+			if code == e.Action {
+				out.Action = name
+			}
+		}
+		if out.Action == "" {
+			return Encap{}, false
+		}
+		if e.Flags[nl.SEG6_LOCAL_SRH] {
+			out.Segments = fromSRH(e.Segments)
+		}
+		if e.Flags[nl.SEG6_LOCAL_TABLE] {
+			out.Table = e.Table
+		}
+		if e.Flags[nl.SEG6_LOCAL_VRFTABLE] {
+			out.VRFTable = e.VrfTable
+		}
+		if e.Flags[nl.SEG6_LOCAL_NH4] {
+			out.NH4 = e.InAddr.String()
+		}
+		if e.Flags[nl.SEG6_LOCAL_NH6] {
+			out.NH6 = e.In6Addr.String()
+		}
+		if e.Flags[nl.SEG6_LOCAL_IIF] {
+			out.IIF = linkName(h, e.Iif)
+		}
+		if e.Flags[nl.SEG6_LOCAL_OIF] {
+			out.OIF = linkName(h, e.Oif)
+		}
+		return out, true
+	case *netlink.BpfEncap:
+		return parseBpfEncapString(e.String())
+	case *ipTunnelEncap:
+		return e.e, true
+	default:
+		return Encap{}, false
+	}
+}
+
+// parseBpfEncapString recovers the program names and headroom of a BpfEncap,
+// whose fields are unexported, from its String form
+// ("in: NAME xmit: NAME[fd:N] xmit headroom: 14").
+func parseBpfEncapString(s string) (Encap, bool) {
+	out := Encap{Type: "bpf"}
+	toks := strings.Fields(s)
+	for len(toks) >= 2 {
+		key, val := toks[0], toks[1]
+		if key == "xmit" && val == "headroom:" {
+			if len(toks) < 3 {
+				return Encap{}, false
+			}
+			n, err := strconv.Atoi(toks[2])
+			if err != nil {
+				return Encap{}, false
+			}
+			out.Headroom = n
+			toks = toks[3:]
+			continue
+		}
+		// SetProg names programs "NAME[fd:N]" and the kernel keeps the name as sent.
+		val = strings.TrimRight(val, "\x00")
+		if i := strings.LastIndex(val, "[fd:"); i > 0 && strings.HasSuffix(val, "]") {
+			val = val[:i]
+		}
+		switch key {
+		case "in:":
+			out.In = val
+		case "out:":
+			out.Out = val
+		case "xmit:":
+			out.Xmit = val
+		default:
+			return Encap{}, false
+		}
+		toks = toks[2:]
+	}
+	if len(toks) != 0 {
+		return Encap{}, false
+	}
+	return out, true
+}
+
+// toSRH converts segments in visiting order to SRH order, where the last
+// segment to visit comes first.
+func toSRH(segs []string) []net.IP {
+	out := make([]net.IP, 0, len(segs))
+	for i := len(segs) - 1; i >= 0; i-- {
+		out = append(out, net.ParseIP(segs[i]).To16())
+	}
+	return out
+}
+
+// fromSRH is the inverse of toSRH.
+func fromSRH(segs []net.IP) []string {
+	out := make([]string, 0, len(segs))
+	for i := len(segs) - 1; i >= 0; i-- {
+		out = append(out, segs[i].String())
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestNetlinkEncapRoundTrip(t *testing.T) {
+	for _, e := range []Encap{
+		{Type: "mpls", Labels: []int{100, 200}},
+		{Type: "seg6", Mode: "encap", Segments: []string{"fc00::1", "fc00::2", "fc00::3"}},
+		{Type: "seg6", Mode: "inline", Segments: []string{"fc00::1"}},
+		{Type: "seg6local", Action: "End.DX4", NH4: "10.0.0.1"},
+		{Type: "seg6local", Action: "End.DX6", NH6: "2001:db8::1"},
+		{Type: "seg6local", Action: "End.DT6", Table: 100},
+		{Type: "seg6local", Action: "End.B6", Segments: []string{"fc00::1", "fc00::2"}},
+	} {
+		n, err := e.Normalize()
+		if err != nil {
+			t.Fatalf("Normalize(%+v): %v", e, err)
+		}
+		enc, err := toNetlinkEncap(nil, n, nil)
+		if err != nil {
+			t.Fatalf("toNetlinkEncap(%s): %v", n, err)
+		}
+		got, ok := fromNetlinkEncap(nil, enc)
+		if !ok {
+			t.Fatalf("fromNetlinkEncap(%s) not supported", n)
+		}
+		if got.String() != n.String() {
+			t.Fatalf("round trip of %q = %q", n, got)
+		}
+	}
+
+	// Segments are sent in SRH order, the last to visit first.
+	enc, _ := toNetlinkEncap(nil, Encap{Type: "seg6", Mode: "encap", Segments: []string{"fc00::1", "fc00::2"}}, nil)
+	if segs := enc.(*netlink.SEG6Encap).Segments; segs[0].String() != "fc00::2" {
+		t.Fatalf("SRH segments = %v, want fc00::2 first", segs)
+	}
+
+	// ip/ip6 encaps are decoded from the raw RTA_ENCAP, as List does.
+	for _, e := range []Encap{
+		{Type: "ip", ID: 100, Src: "192.0.2.10", Dst: "198.51.100.7", TTL: 64, TOS: 0x10},
+		{Type: "ip6", ID: 7, Dst: "2001:db8::7", TTL: 32},
+	} {
+		n, err := e.Normalize()
+		if err != nil {
+			t.Fatalf("Normalize(%+v): %v", e, err)
+		}
+		enc, err := toNetlinkEncap(nil, n, nil)
+		if err != nil {
+			t.Fatalf("toNetlinkEncap(%s): %v", n, err)
+		}
+		b, err := enc.Encode()
+		if err != nil {
+			t.Fatalf("Encode(%s): %v", n, err)
+		}
+		got, ok := decodeIPTunnelEncap(enc.Type(), b)
+		if !ok || got.String() != n.String() {
+			t.Fatalf("round trip of %q = %q, %t", n, got, ok)
+		}
+	}
+
+	for s, want := range map[string]string{
+		"in: lwt_in[fd:5] xmit: lwt_x[fd:6] xmit headroom: 14": "bpf in pinned lwt_in xmit pinned lwt_x headroom 14",
+		"out: /sys/fs/bpf/o": "bpf out pinned /sys/fs/bpf/o",
+	} {
+		got, ok := parseBpfEncapString(s)
+		if !ok || got.String() != want {
+			t.Fatalf("parseBpfEncapString(%q) = %q, %t; want %q", s, got, ok, want)
+		}
+	}
+	if _, ok := parseBpfEncapString("bogus: x"); ok {
+		t.Fatalf("expected unknown bpf hook to be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package linuxroute
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncapNormalize(t *testing.T) {
+	cases := []struct {
+		in   Encap
+		want string // String() of the normalized encap
+		err  string
+	}{
+		{in: Encap{Type: "MPLS", Labels: []int{100, 200}}, want: "mpls 100/200"},
+		{in: Encap{Type: "mpls"}, err: "requires labels"},
+		{in: Encap{Type: "mpls", Labels: []int{1 << 20}}, err: "label"},
+		{in: Encap{Type: "seg6", Segments: []string{"FC00::1", "fc00::2"}}, want: "seg6 mode encap segs fc00::1,fc00::2"},
+		{in: Encap{Type: "seg6", Mode: "inline", Segments: []string{"fc00::1"}}, want: "seg6 mode inline segs fc00::1"},
+		{in: Encap{Type: "seg6", Segments: []string{"10.0.0.1"}}, err: "want an IPv6 address"},
+		{in: Encap{Type: "seg6local", Action: "end.dx4", NH4: "10.0.0.1"}, want: "seg6local action End.DX4 nh4 10.0.0.1"},
+		{in: Encap{Type: "seg6local", Action: "End.DT6", Table: 100}, want: "seg6local action End.DT6 table 100"},
+		{in: Encap{Type: "seg6local", Action: "End.Y"}, err: "unsupported encap seg6local action"},
+		{in: Encap{Type: "bpf", Xmit: "/sys/fs/bpf/lwt", Headroom: 14}, want: "bpf xmit pinned /sys/fs/bpf/lwt headroom 14"},
+		{in: Encap{Type: "bpf", In: "/sys/fs/bpf/in", Headroom: 14}, err: "headroom requires an xmit program"},
+		{in: Encap{Type: "ip6", ID: 7, Dst: "2001:db8::1", TTL: 64}, want: "ip6 id 7 dst 2001:db8::1 hoplimit 64"},
+		{in: Encap{Type: "ip", Dst: "2001:db8::1"}, err: "want an IPv4 address"},
+		{in: Encap{Type: "mpls", Labels: []int{100}, Mode: "encap"}, err: "encap mpls does not take mode"},
+		{in: Encap{Type: "gre"}, err: "unsupported encap type"},
+	}
+	for _, tc := range cases {
+		got, err := tc.in.Normalize()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Normalize(%+v) error = %v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Normalize(%+v) error: %v", tc.in, err)
+		}
+		if got.String() != tc.want {
+			t.Fatalf("Normalize(%+v) = %q, want %q", tc.in, got.String(), tc.want)
+		}
+	}
+}
+
+func TestRouteEncapKeyAndDrift(t *testing.T) {
+	spec := Route{Dst: "10.0.0.0/24", Device: "eth0", Encap: &Encap{Type: "mpls", Labels: []int{100}}}
+	k, err := spec.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(k, "|encap=mpls 100") {
+		t.Fatalf("Key() = %q, want an encap suffix", k)
+	}
+	plain, _ := Route{Dst: "10.0.0.0/24", Device: "eth0"}.Key()
+	if plain == k {
+		t.Fatalf("encap does not change the key")
+	}
+
+	if _, err := (Route{Dst: "10.0.0.0/24", Nexthops: []Nexthop{
+		{Device: "eth0", Encap: &Encap{Type: "seg6", Segments: []string{"fc00::1"}}},
+	}}).Normalize(); err == nil {
+		t.Fatalf("expected a non-mpls nexthop encap to be rejected")
+	}
+
+	n, _ := spec.Normalize()
+	for _, tc := range []struct {
+		live Route
+		want bool
+	}{
+		{Route{Dst: "10.0.0.0/24", Device: "eth0", Table: 254, Encap: &Encap{Type: "mpls", Labels: []int{100}}}, true},
+		{Route{Dst: "10.0.0.0/24", Device: "eth0", Table: 254, Encap: &Encap{Type: "mpls", Labels: []int{200}}}, false},
+		{Route{Dst: "10.0.0.0/24", Device: "eth0", Table: 254}, false},
+	} {
+		live, _ := tc.live.Normalize()
+		if got := matchesLive(n, live); got != tc.want {
+			t.Fatalf("matchesLive(%+v) = %t, want %t", live.Encap, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// GuardError is returned, before any RouteManager call, when a plan violates
+// one of the controller's safety guards (MaxDeletes, MaxDeletePercent,
+// ProtectedRoutes, RefuseEmptyDesired). Set Controller.Force to bypass the guards.
+type GuardError struct {
+	Reason string
+	// Routes are the offending routes, if any.
+	Routes []Route
+}
+
+func (e *GuardError) Error() string {
+	if len(e.Routes) == 0 {
+		return "unsafe plan refused: " + e.Reason
+	}
+	return fmt.Sprintf("unsafe plan refused: %s (%d route(s))", e.Reason, len(e.Routes))
+}
+
+// checkDeletes enforces the safety guards on a plan deleting toDel
+// out of oldCount baseline routes, with desiredCount desired routes.
+func (c Controller) checkDeletes(oldCount, desiredCount int, toDel []Route) error {
+	if c.Force {
+		return nil
+	}
+	if c.RefuseEmptyDesired && desiredCount == 0 && oldCount > 0 {
+		return &GuardError{Reason: "desired routes are empty", Routes: toDel}
+	}
+	if c.MaxDeletes > 0 && len(toDel) > c.MaxDeletes {
+		return &GuardError{
+			Reason: fmt.Sprintf("%d deletes exceed MaxDeletes %d", len(toDel), c.MaxDeletes),
+			Routes: toDel,
+		}
+	}
+	if c.MaxDeletePercent > 0 && oldCount > 0 {
+		pct := float64(len(toDel)) * 100 / float64(oldCount)
+		if pct > c.MaxDeletePercent {
+			return &GuardError{
+				Reason: fmt.Sprintf("deleting %.1f%% of routes exceeds MaxDeletePercent %.1f%%", pct, c.MaxDeletePercent),
+				Routes: toDel,
+			}
+		}
+	}
+
+	var protected []Route
+	for _, r := range toDel {
+		ok, err := c.isProtected(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			protected = append(protected, r)
+		}
+	}
+	if len(protected) > 0 {
+		return &GuardError{Reason: "plan deletes protected routes", Routes: protected}
+	}
+	return nil
+}
+
+// isProtected reports whether r matches ProtectedRoutes: "default" matches default
+// routes, and a CIDR matches every dst inside it.
+func (c Controller) isProtected(r Route) (bool, error) {
+	n, err := r.Normalize()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range c.ProtectedRoutes {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "default" {
+			if n.Dst == "default" {
+				return true, nil
+			}
+			continue
+		}
+		_, protNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return false, fmt.Errorf("invalid protected route %q: %w", p, err)
+		}
+		if n.Dst == "default" {
+			if ones, _ := protNet.Mask.Size(); ones == 0 {
+				return true, nil
+			}
+			continue
+		}
+		_, dst, _ := net.ParseCIDR(n.Dst) // validated by Normalize
+		dstOnes, dstBits := dst.Mask.Size()
+		protOnes, protBits := protNet.Mask.Size()
+		if dstBits == protBits && dstOnes >= protOnes && protNet.Contains(dst.IP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
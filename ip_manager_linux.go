@@ -12,6 +12,7 @@ import (
 	"syscall"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
 )
 
@@ -20,6 +21,27 @@ import (
 type IPRouteManager struct {
 	// IPPath is kept for backward compatibility, but unused in the netlink implementation.
 	IPPath string
+
+	// Proto, when set, restricts List to routes with this protocol (across all tables),
+	// e.g. the Controller.OwnerProto used to stamp routes.
+	Proto string
+
+	// Handle, when set, is used for every netlink call instead of the global handle,
+	// e.g. one bound to another network namespace (see NewIPRouteManagerAt).
+	Handle *netlink.Handle
+
+	// ns is the namespace Handle is bound to, used for event subscriptions;
+	// ownsNS is set when Close must release it.
+	ns     *netns.NsHandle
+	ownsNS bool
+}
+
+// handle returns m.Handle, or a zero Handle that behaves like the package-level netlink functions.
+func (m IPRouteManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
 }
 
 func (m IPRouteManager) List(ctx context.Context) ([]Route, error) {
@@ -29,18 +51,63 @@ func (m IPRouteManager) List(ctx context.Context) ([]Route, error) {
 	default:
 	}
 
-	nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{}, 0)
+	// Table is left unspecified so routes are listed from every table; without a
+	// table filter only the main table would be.
+	filter := &netlink.Route{Table: unix.RT_TABLE_UNSPEC}
+	mask := uint64(netlink.RT_FILTER_TABLE)
+	if m.Proto != "" {
+		p, err := DefaultNameResolver().Proto(m.Proto)
+		if err != nil {
+			return nil, err
+		}
+		filter.Protocol = netlink.RouteProtocol(p)
+		mask |= netlink.RT_FILTER_PROTOCOL
+	}
+
+	vrfs, err := vrfTables(m.handle())
 	if err != nil {
 		return nil, err
 	}
 
-	routes := make([]Route, 0, len(nlRoutes))
-	for _, nr := range nlRoutes {
-		r, err := fromNetlinkRoute(nr)
+	var routes []Route
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6, netlink.FAMILY_MPLS} {
+		nlRoutes, err := m.handle().RouteListFiltered(family, filter, mask)
 		if err != nil {
-			continue
+			return nil, err
+		}
+		var raws map[routeSlot]rawRouteAttrs
+		if family != netlink.FAMILY_MPLS {
+			if raws, err = m.routeRawAttrs(family); err != nil {
+				return nil, err
+			}
+		}
+		for _, nr := range nlRoutes {
+			if !listable(nr) {
+				continue
+			}
+			raw := raws[routeSlot{family: family, table: nr.Table, dst: slotDst(nr.Dst), priority: nr.Priority, tos: nr.Tos}]
+			if raw.nhid != 0 {
+				// The netlink library reports the resolved nexthop of the object as
+				// the route's own; drop it in favor of the reference.
+				nr.Gw, nr.LinkIndex, nr.MultiPath, nr.Encap = nil, 0, nil, nil
+				raw.encap, raw.hopEncaps = nil, nil
+			}
+			r, err := fromNetlinkRoute(m.handle(), nr)
+			if err != nil {
+				continue
+			}
+			r.NexthopID = raw.nhid
+			if raw.encap != nil {
+				r.Encap = raw.encap
+			}
+			for i, e := range raw.hopEncaps {
+				if e != nil && i < len(r.Nexthops) {
+					r.Nexthops[i].Encap = e
+				}
+			}
+			setVRF(&r, vrfs)
+			routes = append(routes, r)
 		}
-		routes = append(routes, r)
 	}
 
 	return routes, nil
@@ -53,11 +120,43 @@ func (m IPRouteManager) Add(ctx context.Context, r Route) error {
 	default:
 	}
 
-	nlr, err := toNetlinkRoute(r)
+	n, err := r.Normalize()
+	if err != nil {
+		return err
+	}
+	if n.NexthopID != 0 {
+		return m.addNexthopRoute(n)
+	}
+
+	var progs bpfPrograms
+	defer progs.Close()
+	nlr, err := toNetlinkRoute(m.handle(), r, &progs)
+	if err != nil {
+		return err
+	}
+	return m.handle().RouteReplace(&nlr)
+}
+
+// Replace atomically updates old to new via netlink.RouteReplace.
+// If old and new do not share the kernel route identity (see KernelIdentity),
+// old is deleted afterwards so it does not linger.
+func (m IPRouteManager) Replace(ctx context.Context, old, new Route) error {
+	if err := m.Add(ctx, new); err != nil {
+		return err
+	}
+	o, err := old.Normalize()
+	if err != nil {
+		return err
+	}
+	n, err := new.Normalize()
 	if err != nil {
 		return err
 	}
-	return netlink.RouteReplace(&nlr)
+	if KernelIdentity(o) == KernelIdentity(n) {
+		// Deleting old would remove the route just installed in its place.
+		return nil
+	}
+	return m.Delete(ctx, old)
 }
 
 func (m IPRouteManager) Delete(ctx context.Context, r Route) error {
@@ -67,11 +166,11 @@ func (m IPRouteManager) Delete(ctx context.Context, r Route) error {
 	default:
 	}
 
-	nlr, err := toNetlinkRoute(r)
+	nlr, err := toNetlinkRoute(m.handle(), r, nil)
 	if err != nil {
 		return err
 	}
-	err = netlink.RouteDel(&nlr)
+	err = m.handle().RouteDel(&nlr)
 	if err != nil {
 		if errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.ENOENT) {
 			return nil
@@ -85,7 +184,10 @@ func (m IPRouteManager) Delete(ctx context.Context, r Route) error {
 	return nil
 }
 
-func toNetlinkRoute(r Route) (netlink.Route, error) {
+// toNetlinkRoute converts r for a netlink request. BPF encap programs are opened
+// into progs, which must stay open until the request is sent; with a nil progs
+// (deletes) the BPF encap is left out, so it does not take part in matching.
+func toNetlinkRoute(h *netlink.Handle, r Route, progs *bpfPrograms) (netlink.Route, error) {
 	n, err := r.Normalize()
 	if err != nil {
 		return netlink.Route{}, err
@@ -93,8 +195,20 @@ func toNetlinkRoute(r Route) (netlink.Route, error) {
 
 	var nr netlink.Route
 
-	if n.Dst != "default" {
-		_, ipNet, err := net.ParseCIDR(n.Dst)
+	if n.Family == familyMPLS {
+		label, _ := strconv.Atoi(n.Dst)
+		nr.MPLSDst = &label
+		if len(n.NewDst) > 0 {
+			nr.NewDst = &netlink.MPLSDestination{Labels: append([]int(nil), n.NewDst...)}
+		}
+	} else {
+		dst := n.Dst
+		if dst == "default" {
+			// Normalize spells only the IPv4 default route "default"; without a
+			// gateway netlink cannot tell its family otherwise.
+			dst = "0.0.0.0/0"
+		}
+		_, ipNet, err := net.ParseCIDR(dst)
 		if err != nil {
 			return netlink.Route{}, fmt.Errorf("invalid dst %q: %w", n.Dst, err)
 		}
@@ -106,23 +220,74 @@ func toNetlinkRoute(r Route) (netlink.Route, error) {
 		if gw == nil {
 			return netlink.Route{}, fmt.Errorf("invalid gateway %q", n.Gateway)
 		}
-		nr.Gw = gw
+		if n.Family == familyMPLS {
+			// MPLS next hops are IP addresses of another family, sent as RTA_VIA.
+			via := &netlink.Via{AddrFamily: netlink.FAMILY_V4, Addr: gw.To4()}
+			if via.Addr == nil {
+				via.AddrFamily, via.Addr = netlink.FAMILY_V6, gw
+			}
+			nr.Via = via
+		} else {
+			nr.Gw = gw
+		}
 	}
 
 	if n.Device != "" {
-		link, err := netlink.LinkByName(n.Device)
+		idx, err := linkIndex(h, n.Device)
+		if err != nil {
+			return netlink.Route{}, err
+		}
+		nr.LinkIndex = idx
+	}
+	if n.Onlink {
+		nr.Flags |= int(netlink.FLAG_ONLINK)
+	}
+
+	for _, nh := range n.Nexthops {
+		info := &netlink.NexthopInfo{Hops: nh.Weight - 1}
+		if nh.Gateway != "" {
+			info.Gw = net.ParseIP(nh.Gateway)
+		}
+		if nh.Device != "" {
+			idx, err := linkIndex(h, nh.Device)
+			if err != nil {
+				return netlink.Route{}, err
+			}
+			info.LinkIndex = idx
+		}
+		if nh.Onlink {
+			info.Flags |= int(netlink.FLAG_ONLINK)
+		}
+		if nh.Encap != nil {
+			enc, err := toNetlinkEncap(h, *nh.Encap, progs)
+			if err != nil {
+				return netlink.Route{}, err
+			}
+			info.Encap = enc
+		}
+		nr.MultiPath = append(nr.MultiPath, info)
+	}
+
+	if n.Encap != nil {
+		enc, err := toNetlinkEncap(h, *n.Encap, progs)
 		if err != nil {
-			return netlink.Route{}, fmt.Errorf("link %q: %w", n.Device, err)
+			return netlink.Route{}, err
 		}
-		nr.LinkIndex = link.Attrs().Index
+		nr.Encap = enc
 	}
 
 	if n.Table != 0 {
 		nr.Table = n.Table
 	}
+	if n.VRF != "" {
+		if nr.Table, err = vrfTable(h, n.VRF); err != nil {
+			return netlink.Route{}, err
+		}
+	}
 	if n.Metric != 0 {
 		nr.Priority = n.Metric
 	}
+	nr.Tos = n.TOS
 	if n.Src != "" {
 		src := net.ParseIP(n.Src)
 		if src == nil {
@@ -132,11 +297,11 @@ func toNetlinkRoute(r Route) (netlink.Route, error) {
 	}
 
 	if n.Scope != "" {
-		sc, ok := parseScope(n.Scope)
-		if !ok {
-			return netlink.Route{}, fmt.Errorf("unsupported scope %q", n.Scope)
+		sc, err := builtinNames.Scope(n.Scope)
+		if err != nil {
+			return netlink.Route{}, err
 		}
-		nr.Scope = sc
+		nr.Scope = netlink.Scope(sc)
 	}
 
 	if n.Type != "" {
@@ -150,24 +315,54 @@ func toNetlinkRoute(r Route) (netlink.Route, error) {
 	}
 
 	if n.Proto != "" {
-		p, ok := parseProtocol(n.Proto)
-		if !ok {
-			return netlink.Route{}, fmt.Errorf("unsupported proto %q", n.Proto)
+		p, err := builtinNames.Proto(n.Proto)
+		if err != nil {
+			return netlink.Route{}, err
 		}
 		nr.Protocol = netlink.RouteProtocol(p)
 	}
 
+	if m := n.Metrics; m != nil {
+		nr.MTU = m.MTU
+		nr.AdvMSS = m.AdvMSS
+		nr.InitCwnd = m.InitCwnd
+		nr.InitRwnd = m.InitRwnd
+		nr.Hoplimit = m.Hoplimit
+		// The kernel keeps rtt in 1/8 ms and rttvar in 1/4 ms.
+		nr.Rtt = m.RTT * 8
+		nr.RttVar = m.RTTVar * 4
+		nr.Window = m.Window
+		nr.Congctl = m.Congctl
+		nr.Features = m.Features
+		nr.QuickACK = m.QuickACK
+	}
+
 	return nr, nil
 }
 
-func fromNetlinkRoute(nr netlink.Route) (Route, error) {
+func fromNetlinkRoute(h *netlink.Handle, nr netlink.Route) (Route, error) {
 	r := Route{
 		Table:  nr.Table,
 		Metric: nr.Priority,
+		TOS:    nr.Tos,
 	}
 
-	if nr.Dst == nil {
+	if nr.MPLSDst != nil {
+		r.Family = familyMPLS
+		r.Dst = strconv.Itoa(*nr.MPLSDst)
+		// MPLS has a single table, reported as main.
+		r.Table = 0
+		if d, ok := nr.NewDst.(*netlink.MPLSDestination); ok {
+			r.NewDst = append([]int(nil), d.Labels...)
+		}
+		if v, ok := nr.Via.(*netlink.Via); ok && len(v.Addr) != 0 {
+			r.Gateway = v.Addr.String()
+		}
+	} else if nr.Dst == nil {
 		r.Dst = "default"
+		if nr.Family == netlink.FAMILY_V6 {
+			r.Dst = "::/0"
+		}
 	} else {
 		r.Dst = nr.Dst.String()
 	}
@@ -181,52 +376,119 @@ func fromNetlinkRoute(nr netlink.Route) (Route, error) {
 	}
 
 	if nr.LinkIndex != 0 {
-		link, err := netlink.LinkByIndex(nr.LinkIndex)
-		if err == nil && link != nil && link.Attrs() != nil {
-			r.Device = link.Attrs().Name
+		r.Device = linkName(h, nr.LinkIndex)
+	}
+	r.Onlink = nr.Flags&int(netlink.FLAG_ONLINK) != 0
+
+	for _, info := range nr.MultiPath {
+		if info == nil {
+			continue
+		}
+		nh := Nexthop{
+			Weight: info.Hops + 1,
+			Onlink: info.Flags&int(netlink.FLAG_ONLINK) != 0,
 		}
+		if len(info.Gw) != 0 {
+			nh.Gateway = info.Gw.String()
+		}
+		if info.LinkIndex != 0 {
+			nh.Device = linkName(h, info.LinkIndex)
+		}
+		if info.Encap != nil {
+			enc, ok := fromNetlinkEncap(h, info.Encap)
+			if !ok {
+				return Route{}, fmt.Errorf("unsupported nexthop encap %s", info.Encap)
+			}
+			nh.Encap = &enc
+		}
+		r.Nexthops = append(r.Nexthops, nh)
+	}
+
+	if nr.Encap != nil {
+		enc, ok := fromNetlinkEncap(h, nr.Encap)
+		if !ok {
+			return Route{}, fmt.Errorf("unsupported encap %s", nr.Encap)
+		}
+		r.Encap = &enc
 	}
 
-	r.Scope = scopeString(nr.Scope)
+	r.Scope = strconv.Itoa(int(nr.Scope))
 	r.Type = routeTypeString(nr.Type)
 	if nr.Protocol != 0 {
-		r.Proto = protocolString(int(nr.Protocol))
+		r.Proto = strconv.Itoa(int(nr.Protocol))
+	}
+	r.Metrics = &RouteMetrics{
+		MTU:      nr.MTU,
+		AdvMSS:   nr.AdvMSS,
+		InitCwnd: nr.InitCwnd,
+		InitRwnd: nr.InitRwnd,
+		Hoplimit: nr.Hoplimit,
+		RTT:      nr.Rtt / 8,
+		RTTVar:   nr.RttVar / 4,
+		Window:   nr.Window,
+		Congctl:  nr.Congctl,
+		Features: nr.Features,
+		QuickACK: nr.QuickACK,
 	}
 
 	return r.Normalize()
 }
 
-func parseScope(s string) (netlink.Scope, bool) {
-	switch strings.ToLower(strings.TrimSpace(s)) {
-	case "global", "universe":
-		return netlink.SCOPE_UNIVERSE, true
-	case "site":
-		return netlink.SCOPE_SITE, true
-	case "link":
-		return netlink.SCOPE_LINK, true
-	case "host":
-		return netlink.SCOPE_HOST, true
-	case "nowhere":
-		return netlink.SCOPE_NOWHERE, true
-	default:
-		return 0, false
+// vrfTables returns the VRF device name of each VRF table.
+func vrfTables(h *netlink.Handle) (map[int]string, error) {
+	links, err := h.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list links: %w", err)
+	}
+	vrfs := make(map[int]string)
+	for _, l := range links {
+		if v, ok := l.(*netlink.Vrf); ok {
+			vrfs[int(v.Table)] = v.Name
+		}
+	}
+	return vrfs, nil
+}
+
+// vrfTable resolves a VRF device name to its table.
+func vrfTable(h *netlink.Handle, name string) (int, error) {
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("vrf %q: %w", name, err)
+	}
+	v, ok := link.(*netlink.Vrf)
+	if !ok {
+		return 0, fmt.Errorf("vrf %q: not a vrf device", name)
+	}
+	return int(v.Table), nil
+}
+
+func linkIndex(h *netlink.Handle, name string) (int, error) {
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("link %q: %w", name, err)
+	}
+	return link.Attrs().Index, nil
+}
+
+func linkName(h *netlink.Handle, index int) string {
+	link, err := h.LinkByIndex(index)
+	if err == nil && link != nil && link.Attrs() != nil {
+		return link.Attrs().Name
 	}
+	return ""
 }
 
-func scopeString(sc netlink.Scope) string {
-	switch sc {
-	case netlink.SCOPE_UNIVERSE:
-		return "global"
-	case netlink.SCOPE_SITE:
-		return "site"
-	case netlink.SCOPE_LINK:
-		return "link"
-	case netlink.SCOPE_HOST:
-		return "host"
-	case netlink.SCOPE_NOWHERE:
-		return "nowhere"
+// listable reports whether List reports nr: the local table and local,
+// broadcast, multicast and anycast routes belong to the kernel's addresses.
+func listable(nr netlink.Route) bool {
+	if nr.Table == unix.RT_TABLE_LOCAL {
+		return false
+	}
+	switch nr.Type {
+	case unix.RTN_UNICAST, unix.RTN_BLACKHOLE, unix.RTN_UNREACHABLE, unix.RTN_PROHIBIT:
+		return true
 	default:
-		return ""
+		return false
 	}
 }
 
@@ -257,43 +519,3 @@ func routeTypeString(t int) string {
 		return ""
 	}
 }
-
-func parseProtocol(p string) (int, bool) {
-	p = strings.ToLower(strings.TrimSpace(p))
-	if p == "" {
-		return 0, true
-	}
-	if n, err := strconv.Atoi(p); err == nil {
-		if n < 0 || n > 255 {
-			return 0, false
-		}
-		return n, true
-	}
-	switch p {
-	case "kernel":
-		return unix.RTPROT_KERNEL, true
-	case "boot":
-		return unix.RTPROT_BOOT, true
-	case "static":
-		return unix.RTPROT_STATIC, true
-	case "dhcp":
-		return unix.RTPROT_DHCP, true
-	default:
-		return 0, false
-	}
-}
-
-func protocolString(p int) string {
-	switch p {
-	case unix.RTPROT_KERNEL:
-		return "kernel"
-	case unix.RTPROT_BOOT:
-		return "boot"
-	case unix.RTPROT_STATIC:
-		return "static"
-	case unix.RTPROT_DHCP:
-		return "dhcp"
-	default:
-		return strconv.Itoa(p)
-	}
-}
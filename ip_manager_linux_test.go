@@ -0,0 +1,120 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestNetlinkRouteRoundTrip(t *testing.T) {
+	for _, r := range []Route{
+		{Dst: "default", Gateway: "198.51.100.1", Onlink: true},
+		{Dst: "10.1.0.0/16", Nexthops: []Nexthop{{Gateway: "198.51.100.1", Weight: 3, Onlink: true}}},
+		{Dst: "10.2.0.0/16", Table: 100, Nexthops: []Nexthop{
+			{Gateway: "192.0.2.1", Weight: 1},
+			{Gateway: "198.51.100.1", Weight: 2, Onlink: true},
+		}},
+		{Dst: "10.3.0.0/16", Gateway: "192.0.2.1", Metric: 10},
+	} {
+		n, err := r.Normalize()
+		if err != nil {
+			t.Fatalf("Normalize(%+v): %v", r, err)
+		}
+		want, _ := n.Key()
+		nr, err := toNetlinkRoute(nil, r, nil)
+		if err != nil {
+			t.Fatalf("toNetlinkRoute(%+v): %v", r, err)
+		}
+		if len(nr.MultiPath) == 0 && n.Onlink != (nr.Flags&int(netlink.FLAG_ONLINK) != 0) {
+			t.Fatalf("toNetlinkRoute(%+v) flags = %#x", r, nr.Flags)
+		}
+		// The kernel reports a single path as a plain route with its flags.
+		if len(nr.MultiPath) == 1 {
+			nh := nr.MultiPath[0]
+			nr.Gw, nr.LinkIndex, nr.Flags, nr.MultiPath = nh.Gw, nh.LinkIndex, nh.Flags, nil
+		}
+		got, err := fromNetlinkRoute(nil, nr)
+		if err != nil {
+			t.Fatalf("fromNetlinkRoute(%+v): %v", nr, err)
+		}
+		// List reports the kernel defaults Normalize leaves empty.
+		got.Scope, got.Type = "", ""
+		if gk, _ := got.Key(); gk != want {
+			t.Fatalf("round trip of %+v\n got  %s\n want %s", r, gk, want)
+		}
+	}
+}
+
+// newTestNamespace returns a manager bound to a new network namespace with lo
+// up, skipping the test without the privileges to create one.
+func newTestNamespace(t *testing.T) *IPRouteManager {
+	t.Helper()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("current network namespace: %v", err)
+	}
+	defer origin.Close()
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("new network namespace: %v", err)
+	}
+	t.Cleanup(func() { ns.Close() })
+	if err := netns.Set(origin); err != nil {
+		t.Fatalf("restore network namespace: %v", err)
+	}
+
+	m, err := NewIPRouteManagerAt(ns)
+	if err != nil {
+		t.Fatalf("NewIPRouteManagerAt: %v", err)
+	}
+	t.Cleanup(m.Close)
+	lo, err := m.Handle.LinkByName("lo")
+	if err == nil {
+		err = m.Handle.LinkSetUp(lo)
+	}
+	if err != nil {
+		t.Fatalf("set lo up: %v", err)
+	}
+	return m
+}
+
+func TestIPRouteManagerList_AllTables(t *testing.T) {
+	m := newTestNamespace(t)
+	ctx := context.Background()
+	desired := []Route{
+		{Dst: "10.1.0.0/16", Device: "lo"},
+		{Dst: "10.2.0.0/16", Device: "lo", Table: 100},
+		{Dst: "10.3.0.0/16", Type: "blackhole", Table: 200},
+	}
+	for _, r := range desired {
+		if err := m.Add(ctx, r); err != nil {
+			t.Fatalf("Add(%+v): %v", r, err)
+		}
+	}
+
+	live, err := m.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, r := range live {
+		if r.Table == 255 {
+			t.Fatalf("List reported a local table route %+v", r)
+		}
+	}
+
+	c := Controller{Manager: m, Store: &MemoryStore{}}
+	rep, err := c.DetectDrift(ctx, desired)
+	if err != nil {
+		t.Fatalf("DetectDrift: %v", err)
+	}
+	if !rep.InSync() {
+		t.Fatalf("routes outside the main table reported as drift: %+v", rep)
+	}
+}
@@ -5,11 +5,15 @@ package linuxroute
 import (
 	"context"
 	"fmt"
+
+	"github.com/vishvananda/netlink"
 )
 
 // IPRouteManager is not supported on non-Linux platforms.
 type IPRouteManager struct {
 	IPPath string
+	Proto  string
+	Handle *netlink.Handle
 }
 
 func (m IPRouteManager) List(ctx context.Context) ([]Route, error) {
@@ -23,3 +27,7 @@ func (m IPRouteManager) Add(ctx context.Context, r Route) error {
 func (m IPRouteManager) Delete(ctx context.Context, r Route) error {
 	return fmt.Errorf("IPRouteManager is supported only on linux")
 }
+
+func (m IPRouteManager) Replace(ctx context.Context, old, new Route) error {
+	return fmt.Errorf("IPRouteManager is supported only on linux")
+}
@@ -0,0 +1,203 @@
+package linuxroute
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ipJSONRoute is one entry of "ip -j -d route show table all".
+type ipJSONRoute struct {
+	Type     string                       `json:"type"`
+	Dst      string                       `json:"dst"`
+	Gateway  string                       `json:"gateway"`
+	Dev      string                       `json:"dev"`
+	Table    json.RawMessage              `json:"table"`
+	Metric   int                          `json:"metric"`
+	PrefSrc  string                       `json:"prefsrc"`
+	TOS      string                       `json:"tos"`
+	Pref     string                       `json:"pref"`
+	Protocol json.RawMessage              `json:"protocol"`
+	Scope    json.RawMessage              `json:"scope"`
+	Flags    []string                     `json:"flags"`
+	Nexthops []ipJSONNexthop              `json:"nexthops"`
+	Metrics  []map[string]json.RawMessage `json:"metrics"`
+	NhID     uint32                       `json:"nhid"`
+}
+
+type ipJSONNexthop struct {
+	Gateway string   `json:"gateway"`
+	Dev     string   `json:"dev"`
+	Weight  int      `json:"weight"`
+	Flags   []string `json:"flags"`
+}
+
+// DecodeIPRouteJSON decodes the output of "ip -j [-d] route show [table all]"
+// into normalized routes, e.g. to seed a RouteStore baseline on existing hosts.
+//
+// Kernel-managed entries that Route cannot express (type local, broadcast,
+// multicast, anycast, ... and the whole "local" table) are skipped.
+func DecodeIPRouteJSON(rd io.Reader) ([]Route, error) {
+	var entries []ipJSONRoute
+	if err := json.NewDecoder(rd).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decode ip route json: %w", err)
+	}
+
+	routes := make([]Route, 0, len(entries))
+	for i, e := range entries {
+		r, ok, err := e.route()
+		if err != nil {
+			return nil, fmt.Errorf("routes[%d] (%s): %w", i, e.Dst, err)
+		}
+		if ok {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+func (e ipJSONRoute) route() (Route, bool, error) {
+	switch e.Type {
+	case "", "unicast", "blackhole", "unreachable", "prohibit":
+	default:
+		return Route{}, false, nil
+	}
+
+	table, err := jsonNameOrNumber(e.Table)
+	if err != nil {
+		return Route{}, false, fmt.Errorf("table: %w", err)
+	}
+	r := Route{
+		Gateway: e.Gateway,
+		Device:  e.Dev,
+		Metric:  e.Metric,
+		Src:     e.PrefSrc,
+	}
+	if table != "" {
+		if table == "local" {
+			return Route{}, false, nil
+		}
+		if r.Table, err = parseTableName(table); err != nil {
+			return Route{}, false, fmt.Errorf("unknown table %q", table)
+		}
+	}
+	if r.Proto, err = jsonNameOrNumber(e.Protocol); err != nil {
+		return Route{}, false, fmt.Errorf("protocol: %w", err)
+	}
+	if r.Scope, err = jsonNameOrNumber(e.Scope); err != nil {
+		return Route{}, false, fmt.Errorf("scope: %w", err)
+	}
+	if e.Type != "unicast" {
+		r.Type = e.Type
+	}
+
+	if e.TOS != "" {
+		if r.TOS, err = parseDSField(e.TOS); err != nil {
+			return Route{}, false, fmt.Errorf("tos %q: %w", e.TOS, err)
+		}
+	}
+
+	r.Dst = e.Dst
+	if r.Dst == "default" && e.Pref != "" {
+		// Only IPv6 routes have a router preference.
+		r.Dst = "::/0"
+	}
+	if r.Dst != "default" && !strings.Contains(r.Dst, "/") {
+		if strings.Contains(r.Dst, ":") {
+			r.Dst += "/128"
+		} else {
+			r.Dst += "/32"
+		}
+	}
+
+	for _, nh := range e.Nexthops {
+		r.Nexthops = append(r.Nexthops, Nexthop{
+			Gateway: nh.Gateway,
+			Device:  nh.Dev,
+			Weight:  nh.Weight,
+			Onlink:  hasFlag(nh.Flags, "onlink"),
+		})
+	}
+	r.Onlink = hasFlag(e.Flags, "onlink") && len(r.Nexthops) == 0
+
+	if e.NhID != 0 {
+		// The kernel also prints the paths of the nexthop object.
+		r.NexthopID = e.NhID
+		r.Gateway, r.Device, r.Nexthops, r.Onlink = "", "", nil, false
+	}
+
+	if len(e.Metrics) > 0 {
+		m, err := decodeIPJSONMetrics(e.Metrics)
+		if err != nil {
+			return Route{}, false, fmt.Errorf("metrics: %w", err)
+		}
+		r.Metrics = &m
+	}
+
+	if r, err = DefaultNameResolver().ResolveRoute(r); err != nil {
+		return Route{}, false, err
+	}
+	n, err := r.Normalize()
+	if err != nil {
+		return Route{}, false, err
+	}
+	return n, true, nil
+}
+
+// decodeIPJSONMetrics maps iproute2's "metrics" array ([{"mtu":1400,...}]) to
+// RouteMetrics. Metrics Route cannot express are ignored.
+func decodeIPJSONMetrics(objs []map[string]json.RawMessage) (RouteMetrics, error) {
+	var m RouteMetrics
+	for _, obj := range objs {
+		for key, raw := range obj {
+			if !isMetricOption(key) {
+				continue
+			}
+			val, err := jsonNameOrNumber(raw)
+			if err != nil {
+				// "features" is printed as a list of names.
+				var names []string
+				if key != "features" || json.Unmarshal(raw, &names) != nil {
+					return RouteMetrics{}, fmt.Errorf("%s: %w", key, err)
+				}
+				val = strings.Join(names, ",")
+			}
+			if key == "rtt" || key == "rttvar" {
+				// Printed in milliseconds, not raw kernel units.
+				val += "ms"
+			}
+			if err := m.setIPOption(key, val); err != nil {
+				return RouteMetrics{}, fmt.Errorf("%s %q: %w", key, val, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// jsonNameOrNumber accepts a JSON string or number (iproute2 prints unnamed
+// tables/protocols as numbers) and returns it as a string.
+func jsonNameOrNumber(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var n int
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", fmt.Errorf("expected a name or number, got %s", raw)
+	}
+	return strconv.Itoa(n), nil
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package linuxroute
+
+import (
+	"os"
+	"testing"
+)
+
+// The fixtures are captured from a network namespace with
+// "ip -4 -j -d route show table all" and "ip -6 -j -d route show table all".
+func TestDecodeIPRouteJSON(t *testing.T) {
+	cases := []struct {
+		fixture string
+		want    []Route
+	}{
+		{
+			fixture: "testdata/ip4_route_show_table_all.json",
+			want: []Route{
+				{Dst: "10.20.0.0/16", Table: 100, Metric: 10, Scope: "global", Proto: "static", Nexthops: []Nexthop{
+					{Gateway: "192.0.2.1", Device: "eth0", Weight: 1},
+					{Gateway: "198.51.100.1", Device: "eth1", Weight: 2, Onlink: true},
+				}},
+				{Dst: "default", Gateway: "192.0.2.1", Device: "eth0", Table: 254, Metric: 100, Src: "192.0.2.10", Scope: "global", Proto: "dhcp"},
+				{Dst: "10.30.0.0/16", Table: 254, Scope: "global", Proto: "static", Nexthops: []Nexthop{
+					{Gateway: "198.51.100.1", Device: "eth1", Onlink: true},
+				}},
+				{Dst: "10.40.0.0/16", Gateway: "192.0.2.1", Device: "eth0", Table: 254, TOS: 0x10, Scope: "global", Proto: "static",
+					Metrics: &RouteMetrics{MTU: 1400}},
+				{Dst: "10.99.0.0/16", Type: "blackhole", Table: 254, Scope: "global", Proto: "boot"},
+				{Dst: "192.0.2.0/24", Device: "eth0", Table: 254, Src: "192.0.2.10", Scope: "link", Proto: "kernel"},
+			},
+		},
+		{
+			fixture: "testdata/ip6_route_show_table_all.json",
+			want: []Route{
+				{Dst: "2001:db8::/64", Device: "eth0", Table: 254, Metric: 256, Scope: "global", Proto: "kernel"},
+				{Dst: "fe80::/64", Device: "eth1", Table: 254, Metric: 256, Scope: "global", Proto: "kernel"},
+				{Dst: "fe80::/64", Device: "eth0", Table: 254, Metric: 256, Scope: "global", Proto: "kernel"},
+				{Dst: "::/0", Gateway: "fe80::1", Device: "eth0", Table: 254, Metric: 1024, Scope: "global", Proto: "ra"},
+			},
+		},
+	}
+	for _, tc := range cases {
+		f, err := os.Open(tc.fixture)
+		if err != nil {
+			t.Fatalf("open fixture: %v", err)
+		}
+		routes, err := DecodeIPRouteJSON(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: DecodeIPRouteJSON() error: %v", tc.fixture, err)
+		}
+
+		if len(routes) != len(tc.want) {
+			t.Fatalf("%s: got %d routes, want %d: %+v", tc.fixture, len(routes), len(tc.want), routes)
+		}
+		for i := range tc.want {
+			gk, _ := routes[i].Key()
+			wk, _ := tc.want[i].Key()
+			if gk != wk {
+				t.Fatalf("%s: routes[%d]\n got  %s\n want %s", tc.fixture, i, gk, wk)
+			}
+		}
+	}
+}
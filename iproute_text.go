@@ -0,0 +1,530 @@
+package linuxroute
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseIPRouteLine parses one route in "ip route" syntax, e.g.
+//
+//	default via 10.0.0.1 dev eth0 table 100 metric 50 proto static scope link src 10.0.0.5
+//	blackhole 10.0.0.0/8
+//	ip route add 10.1.0.0/16 nexthop via 10.0.0.1 dev eth0 weight 1 nexthop via 10.0.1.1 dev eth1 weight 2
+//	ip -f mpls route add 100 as 200 via inet 10.0.0.2 dev eth0
+//
+// A leading "ip [-4|-6|-M|-f family] route [add|replace|append|change]" is accepted;
+// it sets the mpls family, and -6 makes "default" the IPv6 default route (which
+// otherwise needs an IPv6 gateway or src). A bare number as prefix is an MPLS label too.
+// The returned route is normalized.
+func ParseIPRouteLine(line string) (Route, error) {
+	toks := strings.Fields(line)
+	toks, family := trimIPRouteCommand(toks)
+	if len(toks) == 0 {
+		return Route{}, fmt.Errorf("empty route")
+	}
+
+	var r Route
+	if isRouteType(toks[0]) {
+		if toks[0] != "unicast" {
+			r.Type = toks[0]
+		}
+		toks = toks[1:]
+	}
+	if len(toks) == 0 {
+		return Route{}, fmt.Errorf("missing route prefix")
+	}
+	r.Dst = toks[0]
+	toks = toks[1:]
+	if _, err := strconv.Atoi(r.Dst); err == nil {
+		family = familyMPLS
+	}
+	if family == familyMPLS {
+		r.Family = family
+	}
+	if r.Dst == "default" && family == "inet6" {
+		r.Dst = "::/0"
+	}
+	if r.Dst != "default" && !strings.Contains(r.Dst, "/") && r.Family == "" {
+		// A bare address is a host route.
+		if strings.Contains(r.Dst, ":") {
+			r.Dst += "/128"
+		} else {
+			r.Dst += "/32"
+		}
+	}
+
+	for len(toks) > 0 {
+		key := toks[0]
+		if key == "nexthop" {
+			nh, rest, err := parseIPRouteNexthop(toks[1:])
+			if err != nil {
+				return Route{}, err
+			}
+			r.Nexthops = append(r.Nexthops, nh)
+			toks = rest
+			continue
+		}
+		if key == "encap" {
+			enc, rest, err := parseEncapTokens(toks[1:])
+			if err != nil {
+				return Route{}, err
+			}
+			r.Encap = &enc
+			toks = rest
+			continue
+		}
+		if key == "onlink" {
+			r.Onlink = true
+			toks = toks[1:]
+			continue
+		}
+		if len(toks) < 2 {
+			return Route{}, fmt.Errorf("missing value for %q", key)
+		}
+		val := toks[1]
+		toks = toks[2:]
+
+		var err error
+		switch key {
+		case "via":
+			// "via inet 10.0.0.2": the family follows from the address.
+			if (val == "inet" || val == "inet6") && len(toks) > 0 {
+				val = toks[0]
+				toks = toks[1:]
+			}
+			r.Gateway = val
+		case "nhid":
+			var id uint64
+			id, err = strconv.ParseUint(val, 10, 32)
+			r.NexthopID = uint32(id)
+		case "as":
+			if val == "to" && len(toks) > 0 {
+				val = toks[0]
+				toks = toks[1:]
+			}
+			r.NewDst, err = parseMPLSLabels(val)
+		case "dev", "oif":
+			r.Device = val
+		case "table":
+			r.Table, err = parseTableName(val)
+		case "vrf":
+			r.VRF = val
+		case "metric", "preference", "priority":
+			r.Metric, err = strconv.Atoi(val)
+		case "proto", "protocol":
+			r.Proto = val
+		case "scope":
+			r.Scope = val
+		case "src":
+			r.Src = val
+		case "tos", "dsfield":
+			r.TOS, err = parseDSField(val)
+		default:
+			if !isMetricOption(key) {
+				return Route{}, fmt.Errorf("unsupported route option %q", key)
+			}
+			if r.Metrics == nil {
+				r.Metrics = &RouteMetrics{}
+			}
+			err = r.Metrics.setIPOption(key, val)
+		}
+		if err != nil {
+			return Route{}, fmt.Errorf("invalid %s %q: %w", key, val, err)
+		}
+	}
+
+	r, err := DefaultNameResolver().ResolveRoute(r)
+	if err != nil {
+		return Route{}, err
+	}
+	return r.Normalize()
+}
+
+func parseIPRouteNexthop(toks []string) (Nexthop, []string, error) {
+	var nh Nexthop
+	for len(toks) > 0 {
+		key := toks[0]
+		switch key {
+		case "nexthop":
+			return nh, toks, nil
+		case "onlink":
+			nh.Onlink = true
+			toks = toks[1:]
+			continue
+		case "encap":
+			enc, rest, err := parseEncapTokens(toks[1:])
+			if err != nil {
+				return Nexthop{}, nil, err
+			}
+			nh.Encap = &enc
+			toks = rest
+			continue
+		}
+		if len(toks) < 2 {
+			return Nexthop{}, nil, fmt.Errorf("missing value for nexthop %q", key)
+		}
+		val := toks[1]
+		toks = toks[2:]
+		switch key {
+		case "via":
+			nh.Gateway = val
+		case "dev":
+			nh.Device = val
+		case "weight":
+			w, err := strconv.Atoi(val)
+			if err != nil {
+				return Nexthop{}, nil, fmt.Errorf("invalid nexthop weight %q: %w", val, err)
+			}
+			nh.Weight = w
+		default:
+			return Nexthop{}, nil, fmt.Errorf("unsupported nexthop option %q", key)
+		}
+	}
+	return nh, nil, nil
+}
+
+// parseEncapTokens parses "<type> <options...>" after "encap" and returns the
+// tokens following the encapsulation. Like iproute2, options of the encap type
+// (e.g. "src" for "encap ip") are consumed before route options.
+func parseEncapTokens(toks []string) (Encap, []string, error) {
+	if len(toks) < 2 {
+		return Encap{}, nil, fmt.Errorf("incomplete encap")
+	}
+	e := Encap{Type: toks[0]}
+	toks = toks[1:]
+
+	if e.Type == "mpls" {
+		labels, err := parseMPLSLabels(toks[0])
+		if err != nil {
+			return Encap{}, nil, err
+		}
+		e.Labels = labels
+		return e, toks[1:], nil
+	}
+	if _, ok := encapFields[e.Type]; !ok {
+		return Encap{}, nil, fmt.Errorf("unsupported encap type %q", e.Type)
+	}
+
+	for len(toks) >= 2 {
+		key, val := toks[0], toks[1]
+		n := 2
+		var err error
+		switch {
+		case e.Type == "seg6" && key == "mode":
+			e.Mode = val
+		case (e.Type == "seg6" && key == "segs") || (e.Type == "seg6local" && key == "srh"):
+			if key == "srh" {
+				if val != "segs" || len(toks) < 3 {
+					return Encap{}, nil, fmt.Errorf("expected \"srh segs <list>\"")
+				}
+				val, n = toks[2], 3
+			}
+			e.Segments = strings.Split(val, ",")
+		case e.Type == "seg6local" && key == "action":
+			e.Action = val
+		case e.Type == "seg6local" && key == "table":
+			e.Table, err = parseTableName(val)
+		case e.Type == "seg6local" && key == "vrftable":
+			e.VRFTable, err = parseTableName(val)
+		case e.Type == "seg6local" && key == "nh4":
+			e.NH4 = val
+		case e.Type == "seg6local" && key == "nh6":
+			e.NH6 = val
+		case e.Type == "seg6local" && key == "iif":
+			e.IIF = val
+		case e.Type == "seg6local" && key == "oif":
+			e.OIF = val
+		case e.Type == "bpf" && (key == "in" || key == "out" || key == "xmit"):
+			if val == "pinned" {
+				if len(toks) < 3 {
+					return Encap{}, nil, fmt.Errorf("missing pinned path for bpf %s", key)
+				}
+				val, n = toks[2], 3
+			}
+			switch key {
+			case "in":
+				e.In = val
+			case "out":
+				e.Out = val
+			default:
+				e.Xmit = val
+			}
+		case e.Type == "bpf" && key == "headroom":
+			e.Headroom, err = strconv.Atoi(val)
+		case (e.Type == "ip" || e.Type == "ip6") && key == "id":
+			e.ID, err = strconv.ParseUint(val, 0, 64)
+		case (e.Type == "ip" || e.Type == "ip6") && key == "src":
+			e.Src = val
+		case (e.Type == "ip" || e.Type == "ip6") && key == "dst":
+			e.Dst = val
+		case (e.Type == "ip" && key == "ttl") || (e.Type == "ip6" && key == "hoplimit"):
+			e.TTL, err = strconv.Atoi(val)
+		case (e.Type == "ip" && key == "tos") || (e.Type == "ip6" && key == "tc"):
+			e.TOS, err = parseDSField(val)
+		default:
+			return e, toks, nil
+		}
+		if err != nil {
+			return Encap{}, nil, fmt.Errorf("invalid encap %s %s %q: %w", e.Type, key, val, err)
+		}
+		toks = toks[n:]
+	}
+	return e, toks, nil
+}
+
+// trimIPRouteCommand drops a leading "ip [options] route [add|...]" and
+// returns the family the options select: "inet", "inet6", "mpls" or "".
+func trimIPRouteCommand(toks []string) ([]string, string) {
+	family := ""
+	if len(toks) > 0 && toks[0] == "ip" {
+		toks = toks[1:]
+		for len(toks) > 0 && strings.HasPrefix(toks[0], "-") {
+			switch toks[0] {
+			case "-4":
+				family = "inet"
+			case "-6":
+				family = "inet6"
+			case "-M":
+				family = familyMPLS
+			case "-f", "-family":
+				if len(toks) > 1 {
+					switch toks[1] {
+					case "inet", "inet6", familyMPLS:
+						family = toks[1]
+					}
+					toks = toks[1:]
+				}
+			}
+			toks = toks[1:]
+		}
+		if len(toks) > 0 && (toks[0] == "route" || toks[0] == "r" || toks[0] == "ro") {
+			toks = toks[1:]
+		}
+		if len(toks) > 0 {
+			switch toks[0] {
+			case "add", "replace", "append", "change", "del", "delete":
+				toks = toks[1:]
+			}
+		}
+	}
+	return toks, family
+}
+
+func isRouteType(s string) bool {
+	switch s {
+	case "unicast", "blackhole", "unreachable", "prohibit":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseDSField parses a TOS byte, which iproute2 reads as hex with or without "0x".
+func parseDSField(s string) (int, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 8)
+	return int(v), err
+}
+
+// parseTableName accepts a table number or a name known to DefaultNameResolver.
+func parseTableName(s string) (int, error) {
+	return DefaultNameResolver().Table(s)
+}
+
+// printName turns a number stored by Normalize back into its iproute2 name.
+func printName(s string, name func(int) string) string {
+	if v, err := strconv.Atoi(s); err == nil {
+		return name(v)
+	}
+	return s
+}
+
+// IPRouteString returns r in "ip route" syntax (without the "ip route add" prefix),
+// suitable for ParseIPRouteLine. r is normalized first; an invalid route is
+// printed as-is.
+func (r Route) IPRouteString() string {
+	if n, err := r.Normalize(); err == nil {
+		r = n
+	}
+
+	var b []string
+	if r.Type != "" && r.Type != "unicast" {
+		b = append(b, r.Type)
+	}
+	b = append(b, r.Dst)
+	if len(r.NewDst) > 0 {
+		b = append(b, "as", formatMPLSLabels(r.NewDst))
+	}
+	if r.Gateway != "" {
+		b = append(b, "via")
+		if r.Family == familyMPLS {
+			b = append(b, viaFamily(r.Gateway))
+		}
+		b = append(b, r.Gateway)
+	}
+	if r.NexthopID != 0 {
+		b = append(b, "nhid", strconv.FormatUint(uint64(r.NexthopID), 10))
+	}
+	if r.Device != "" {
+		b = append(b, "dev", r.Device)
+	}
+	if r.Onlink {
+		b = append(b, "onlink")
+	}
+	names := DefaultNameResolver()
+	if r.Table != 0 {
+		b = append(b, "table", names.TableName(r.Table))
+	}
+	if r.VRF != "" {
+		b = append(b, "vrf", r.VRF)
+	}
+	if r.Metric != 0 {
+		b = append(b, "metric", strconv.Itoa(r.Metric))
+	}
+	if r.Proto != "" {
+		b = append(b, "proto", printName(r.Proto, names.ProtoName))
+	}
+	if r.Scope != "" {
+		b = append(b, "scope", printName(r.Scope, names.ScopeName))
+	}
+	if r.Src != "" {
+		b = append(b, "src", r.Src)
+	}
+	if r.TOS != 0 {
+		b = append(b, "tos", fmt.Sprintf("0x%02x", r.TOS))
+	}
+	if r.Encap != nil {
+		b = append(b, "encap", r.Encap.String())
+	}
+	if r.Metrics != nil {
+		b = append(b, r.Metrics.ipTokens()...)
+	}
+	for _, nh := range r.Nexthops {
+		b = append(b, "nexthop")
+		if nh.Encap != nil {
+			b = append(b, "encap", nh.Encap.String())
+		}
+		if nh.Gateway != "" {
+			b = append(b, "via", nh.Gateway)
+		}
+		if nh.Device != "" {
+			b = append(b, "dev", nh.Device)
+		}
+		if nh.Weight != 0 {
+			b = append(b, "weight", strconv.Itoa(nh.Weight))
+		}
+		if nh.Onlink {
+			b = append(b, "onlink")
+		}
+	}
+	return strings.Join(b, " ")
+}
+
+// ipRouteCommand returns "ip route", "ip -6 route" or "ip -f mpls route" for r.
+func ipRouteCommand(r Route) string {
+	if r.Family == familyMPLS {
+		return "ip -f mpls route"
+	}
+	v6 := strings.Contains(r.Dst, ":") || strings.Contains(r.Gateway, ":")
+	for _, nh := range r.Nexthops {
+		v6 = v6 || strings.Contains(nh.Gateway, ":")
+	}
+	if v6 {
+		return "ip -6 route"
+	}
+	return "ip route"
+}
+
+// IPRouteCommands returns the plan as ready-to-run "ip route" commands, in
+// the order Apply runs it with the default DeleteFirst strategy. A replace that
+// changes the KernelIdentity of a route is written as a delete and an add,
+// since "ip route replace" would leave the old route installed.
+func (d DiffResult) IPRouteCommands() []string {
+	ops, err := planOps(d, routeDeps{})
+	if err != nil {
+		// A gateway cycle has no valid order; keep the diff order.
+		ops = ops[:0]
+		for _, r := range d.ToDel {
+			ops = append(ops, RouteOp{Kind: OpDelete, Route: r})
+		}
+		for _, rc := range d.ToReplace {
+			ops = append(ops, RouteOp{Kind: OpReplace, Route: rc.New, Old: rc.Old})
+		}
+		for _, r := range d.ToAdd {
+			ops = append(ops, RouteOp{Kind: OpAdd, Route: r})
+		}
+	}
+	cmds := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch {
+		case op.Kind == OpDelete:
+			cmds = append(cmds, ipRouteCommand(op.Route)+" del "+op.Route.IPRouteString())
+		case op.Kind == OpAdd:
+			cmds = append(cmds, ipRouteCommand(op.Route)+" add "+op.Route.IPRouteString())
+		case KernelIdentity(op.Old) != KernelIdentity(op.Route):
+			cmds = append(cmds, ipRouteCommand(op.Old)+" del "+op.Old.IPRouteString(),
+				ipRouteCommand(op.Route)+" add "+op.Route.IPRouteString())
+		default:
+			cmds = append(cmds, ipRouteCommand(op.Route)+" replace "+op.Route.IPRouteString())
+		}
+	}
+	return cmds
+}
+
+// ParseIPRouteScript parses routes written one per line in "ip route" syntax.
+// Blank lines and "#" comments are skipped; a trailing "\" continues a line.
+func ParseIPRouteScript(rd io.Reader) ([]Route, error) {
+	var routes []Route
+	sc := bufio.NewScanner(rd)
+	lineNo, startNo := 0, 0
+	var pending string
+	for sc.Scan() {
+		lineNo++
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if pending == "" {
+			startNo = lineNo
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			pending += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line = pending + line
+		pending = ""
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		r, err := ParseIPRouteLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", startNo, err)
+		}
+		routes = append(routes, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(pending) != "" {
+		r, err := ParseIPRouteLine(pending)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", startNo, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// LoadIPRouteFile reads a desired route set written as an "ip route" script.
+func LoadIPRouteFile(path string) ([]Route, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseIPRouteScript(f)
+}
@@ -0,0 +1,194 @@
+package linuxroute
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIPRouteLine(t *testing.T) {
+	cases := []struct {
+		line string
+		want Route
+	}{
+		{
+			line: "default via 10.0.0.1 dev eth0 table 100 metric 50 proto static scope link src 10.0.0.5",
+			want: Route{Dst: "default", Gateway: "10.0.0.1", Device: "eth0", Table: 100, Metric: 50, Proto: "static", Scope: "link", Src: "10.0.0.5"},
+		},
+		{
+			line: "ip route add blackhole 10.0.0.0/8",
+			want: Route{Dst: "10.0.0.0/8", Type: "blackhole"},
+		},
+		{
+			line: "unreachable 192.168.1.1 table main",
+			want: Route{Dst: "192.168.1.1/32", Type: "unreachable", Table: 254},
+		},
+		{
+			line: "ip -6 route replace 2001:db8::/64 via fe80::1 dev eth0",
+			want: Route{Dst: "2001:db8::/64", Gateway: "fe80::1", Device: "eth0"},
+		},
+		{
+			line: "ip -6 route add default dev eth0",
+			want: Route{Dst: "::/0", Device: "eth0"},
+		},
+		{
+			line: "10.70.0.0/16 via 10.0.0.1 tos 10",
+			want: Route{Dst: "10.70.0.0/16", Gateway: "10.0.0.1", TOS: 0x10},
+		},
+		{
+			line: "default nexthop via 10.0.1.1 dev eth1 weight 2 nexthop via 10.0.0.1 dev eth0 weight 1",
+			want: Route{Dst: "default", Nexthops: []Nexthop{
+				{Gateway: "10.0.0.1", Device: "eth0", Weight: 1},
+				{Gateway: "10.0.1.1", Device: "eth1", Weight: 2},
+			}},
+		},
+		{
+			line: "default via 198.51.100.1 dev eth0 onlink",
+			want: Route{Dst: "default", Gateway: "198.51.100.1", Device: "eth0", Onlink: true},
+		},
+		{
+			// A single path loses its weight and keeps onlink as a route flag.
+			line: "default nexthop via 198.51.100.1 dev eth0 weight 3 onlink",
+			want: Route{Dst: "default", Gateway: "198.51.100.1", Device: "eth0", Onlink: true},
+		},
+		{
+			line: "10.40.0.0/16 via 10.0.0.1 mtu 1400 advmss 1360 initcwnd 10 rtt 80 rttvar 5ms features ecn congctl bbr",
+			want: Route{Dst: "10.40.0.0/16", Gateway: "10.0.0.1", Metrics: &RouteMetrics{
+				MTU: 1400, AdvMSS: 1360, InitCwnd: 10, RTT: 10, RTTVar: 5, Features: 1, Congctl: "bbr",
+			}},
+		},
+		{
+			line: "2001:db8:1::/64 encap seg6 mode encap segs FC00::1,fc00::2 dev eth0",
+			want: Route{Dst: "2001:db8:1::/64", Device: "eth0", Encap: &Encap{
+				Type: "seg6", Mode: "encap", Segments: []string{"fc00::1", "fc00::2"},
+			}},
+		},
+		{
+			line: "10.50.0.0/16 encap mpls 100/200 via 10.0.0.1 dev eth0",
+			want: Route{Dst: "10.50.0.0/16", Gateway: "10.0.0.1", Device: "eth0", Encap: &Encap{Type: "mpls", Labels: []int{100, 200}}},
+		},
+		{
+			line: "fc00::10/128 encap seg6local action End.DX4 nh4 10.0.0.1 dev eth0",
+			want: Route{Dst: "fc00::10/128", Device: "eth0", Encap: &Encap{Type: "seg6local", Action: "End.DX4", NH4: "10.0.0.1"}},
+		},
+		{
+			line: "10.60.0.0/16 encap ip id 100 dst 192.0.2.1 ttl 64 dev vxlan0",
+			want: Route{Dst: "10.60.0.0/16", Device: "vxlan0", Encap: &Encap{Type: "ip", ID: 100, Dst: "192.0.2.1", TTL: 64}},
+		},
+		{
+			line: "10.61.0.0/16 encap ip6 id 7 dst 2001:db8::7 hoplimit 32 tc 0x20 dev ip6tnl0",
+			want: Route{Dst: "10.61.0.0/16", Device: "ip6tnl0", Encap: &Encap{Type: "ip6", ID: 7, Dst: "2001:db8::7", TTL: 32, TOS: 0x20}},
+		},
+		{
+			line: "ip -f mpls route add 100 as 200/300 via inet 10.0.0.2 dev eth0",
+			want: Route{Family: "mpls", Dst: "100", NewDst: []int{200, 300}, Gateway: "10.0.0.2", Device: "eth0"},
+		},
+		{
+			line: "101 via inet6 fe80::1 dev eth1 proto static",
+			want: Route{Family: "mpls", Dst: "101", Gateway: "fe80::1", Device: "eth1", Proto: "static"},
+		},
+		{
+			line: "10.70.0.0/16 nhid 10 proto static",
+			want: Route{Dst: "10.70.0.0/16", NexthopID: 10, Proto: "static"},
+		},
+		{
+			line: "10.80.0.0/16 via 10.0.0.1 dev eth0 vrf vrf-blue",
+			want: Route{Dst: "10.80.0.0/16", Gateway: "10.0.0.1", Device: "eth0", VRF: "vrf-blue"},
+		},
+	}
+	for _, tc := range cases {
+		got, err := ParseIPRouteLine(tc.line)
+		if err != nil {
+			t.Fatalf("ParseIPRouteLine(%q) error: %v", tc.line, err)
+		}
+		gk, _ := got.Key()
+		wk, _ := tc.want.Key()
+		if gk != wk {
+			t.Fatalf("ParseIPRouteLine(%q)\n got  %s\n want %s", tc.line, gk, wk)
+		}
+
+		// The formatter must round-trip.
+		again, err := ParseIPRouteLine(got.IPRouteString())
+		if err != nil {
+			t.Fatalf("ParseIPRouteLine(%q) error: %v", got.IPRouteString(), err)
+		}
+		ak, _ := again.Key()
+		if ak != gk {
+			t.Fatalf("round-trip of %q\n got  %s\n want %s", tc.line, ak, gk)
+		}
+	}
+
+	if _, err := ParseIPRouteLine("10.0.0.0/8 dev eth0 onlink"); err == nil {
+		t.Fatalf("expected onlink without a gateway to be rejected")
+	}
+	if _, err := ParseIPRouteLine("10.0.0.0/8 via 10.0.0.1 bogus 1"); err == nil {
+		t.Fatalf("expected unsupported option to be rejected")
+	}
+	if _, err := ParseIPRouteLine("10.0.0.0/8 encap seg6 mode encap segs 10.0.0.1 dev eth0"); err == nil {
+		t.Fatalf("expected IPv4 seg6 segment to be rejected")
+	}
+	if _, err := ParseIPRouteLine("ip -M route add 100 as 200 via inet 10.0.0.2 table 10"); err == nil {
+		t.Fatalf("expected table on an mpls route to be rejected")
+	}
+}
+
+func TestParseIPRouteScript(t *testing.T) {
+	script := `
+# default route
+ip route add default via 10.0.0.1 dev eth0
+
+10.10.0.0/16 via 10.0.0.2 \
+    dev eth0 metric 100
+`
+	routes, err := ParseIPRouteScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("ParseIPRouteScript() error: %v", err)
+	}
+	if len(routes) != 2 || routes[1].Metric != 100 || routes[1].Device != "eth0" {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+
+	diff := DiffResult{ToDel: routes[:1], ToAdd: routes[1:]}
+	cmds := diff.IPRouteCommands()
+	want := []string{
+		"ip route del default via 10.0.0.1 dev eth0",
+		"ip route add 10.10.0.0/16 via 10.0.0.2 dev eth0 metric 100",
+	}
+	if strings.Join(cmds, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("IPRouteCommands() = %q, want %q", cmds, want)
+	}
+}
+
+func TestIPRouteCommandsOrder(t *testing.T) {
+	parse := func(line string) Route {
+		r, err := ParseIPRouteLine(line)
+		if err != nil {
+			t.Fatalf("ParseIPRouteLine(%q) error: %v", line, err)
+		}
+		return r
+	}
+	diff := DiffResult{
+		ToDel: []Route{
+			parse("10.1.0.0/24 dev eth1 scope link"),
+			parse("10.3.0.0/16 via 10.1.0.1 dev eth1"),
+		},
+		ToReplace: []RouteChange{{
+			Old: parse("10.9.0.0/16 via 10.0.0.1 dev eth0"),
+			New: parse("10.9.0.0/16 via 10.0.0.1 dev eth0 tos 0x10"),
+		}},
+		ToAdd: []Route{
+			parse("10.2.0.0/16 via 10.1.0.1 dev eth0"),
+			parse("10.1.0.0/24 dev eth0 scope link"),
+		},
+	}
+	want := []string{
+		"ip route del 10.3.0.0/16 via 10.1.0.1 dev eth1",
+		"ip route del 10.1.0.0/24 dev eth1 scope link",
+		"ip route del 10.9.0.0/16 via 10.0.0.1 dev eth0",
+		"ip route add 10.9.0.0/16 via 10.0.0.1 dev eth0 tos 0x10",
+		"ip route add 10.1.0.0/24 dev eth0 scope link",
+		"ip route add 10.2.0.0/16 via 10.1.0.1 dev eth0",
+	}
+	if cmds := diff.IPRouteCommands(); strings.Join(cmds, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("IPRouteCommands() =\n%s\nwant\n%s", strings.Join(cmds, "\n"), strings.Join(want, "\n"))
+	}
+}
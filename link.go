@@ -0,0 +1,232 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Link describes a network device managed alongside routes, in a mostly
+// "ip link" compatible form ("ip link add vlan10 link eth0 type vlan id 10").
+//
+// Notes:
+//   - Name is the identity. Kind is one of dummy, bridge, vlan, vxlan, gre, ipip.
+//   - MTU 0 keeps the kernel default; State is "up" (the default) or "down";
+//     Master enslaves the device (e.g. to a bridge or VRF).
+//   - Parent is the lower device of a vlan (required) or the underlay device of
+//     a vxlan/gre/ipip tunnel.
+//   - VLANID is the vlan id; VNI, Port (default 4789) and TTL belong to vxlan;
+//     Local/Remote are tunnel endpoints (for vxlan Remote is the remote or group).
+//   - Kind-specific attributes cannot change on a live device; managers re-create
+//     the device when they do.
+type Link struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	MTU    int    `json:"mtu,omitempty"`
+	State  string `json:"state,omitempty"`
+	Master string `json:"master,omitempty"`
+
+	Parent string `json:"parent,omitempty"`
+	VLANID int    `json:"vlan_id,omitempty"`
+	VNI    int    `json:"vni,omitempty"`
+	Port   int    `json:"port,omitempty"`
+	Local  string `json:"local,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	TTL    int    `json:"ttl,omitempty"`
+}
+
+// vxlanPort is the IANA vxlan port; the kernel would otherwise default to 8472.
+const vxlanPort = 4789
+
+// Normalize canonicalizes fields so diffing is stable.
+// It returns a copy of l.
+func (l Link) Normalize() (Link, error) {
+	out := l
+
+	out.Name = strings.TrimSpace(out.Name)
+	out.Kind = strings.ToLower(strings.TrimSpace(out.Kind))
+	out.State = strings.ToLower(strings.TrimSpace(out.State))
+	out.Master = strings.TrimSpace(out.Master)
+	out.Parent = strings.TrimSpace(out.Parent)
+	out.Local = strings.TrimSpace(out.Local)
+	out.Remote = strings.TrimSpace(out.Remote)
+
+	if out.Name == "" {
+		return Link{}, fmt.Errorf("link.name is required")
+	}
+	if len(out.Name) > 15 || strings.ContainsAny(out.Name, "/ ") {
+		return Link{}, fmt.Errorf("invalid link.name %q", out.Name)
+	}
+	if out.MTU < 0 {
+		return Link{}, fmt.Errorf("link.mtu must be >= 0")
+	}
+	switch out.State {
+	case "":
+		out.State = "up"
+	case "up", "down":
+	default:
+		return Link{}, fmt.Errorf("unsupported link.state %q", l.State)
+	}
+	if out.Master == out.Name || out.Parent == out.Name {
+		return Link{}, fmt.Errorf("link %s cannot be its own master or parent", out.Name)
+	}
+
+	var err error
+	if out.Local, err = normalizeLinkIP(out.Local, "local"); err != nil {
+		return Link{}, err
+	}
+	if out.Remote, err = normalizeLinkIP(out.Remote, "remote"); err != nil {
+		return Link{}, err
+	}
+
+	// unsupported lists the attributes set on out that its kind does not have.
+	unsupported := func(names ...string) error {
+		set := map[string]bool{
+			"parent":  out.Parent != "",
+			"vlan_id": out.VLANID != 0,
+			"vni":     out.VNI != 0,
+			"port":    out.Port != 0,
+			"local":   out.Local != "",
+			"remote":  out.Remote != "",
+			"ttl":     out.TTL != 0,
+		}
+		for _, n := range names {
+			if set[n] {
+				return fmt.Errorf("link.%s is not supported for %s links", n, out.Kind)
+			}
+		}
+		return nil
+	}
+
+	switch out.Kind {
+	case "dummy", "bridge":
+		err = unsupported("parent", "vlan_id", "vni", "port", "local", "remote", "ttl")
+	case "vlan":
+		if err = unsupported("vni", "port", "local", "remote", "ttl"); err == nil {
+			if out.Parent == "" {
+				err = fmt.Errorf("link.parent is required for vlan links")
+			} else if out.VLANID < 1 || out.VLANID > 4094 {
+				err = fmt.Errorf("link.vlan_id must be in [1, 4094]")
+			}
+		}
+	case "vxlan":
+		if err = unsupported("vlan_id"); err == nil {
+			if out.VNI < 1 || out.VNI > 0xffffff {
+				err = fmt.Errorf("link.vni must be in [1, 16777215]")
+			} else if out.Port < 0 || out.Port > 0xffff {
+				err = fmt.Errorf("link.port must be in [0, 65535]")
+			}
+		}
+		if out.Port == 0 {
+			out.Port = vxlanPort
+		}
+	case "gre", "ipip":
+		if err = unsupported("vlan_id", "vni", "port"); err == nil {
+			if strings.Contains(out.Local, ":") || strings.Contains(out.Remote, ":") {
+				err = fmt.Errorf("link.local/remote must be IPv4 for %s links", out.Kind)
+			}
+		}
+	case "":
+		return Link{}, fmt.Errorf("link.kind is required")
+	default:
+		return Link{}, fmt.Errorf("unsupported link.kind %q", l.Kind)
+	}
+	if err != nil {
+		return Link{}, err
+	}
+	if out.TTL < 0 || out.TTL > 255 {
+		return Link{}, fmt.Errorf("link.ttl must be in [0, 255]")
+	}
+
+	return out, nil
+}
+
+func normalizeLinkIP(s, what string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid link.%s %q", what, s)
+	}
+	return ip.String(), nil
+}
+
+// identity returns the kernel identity of a link.
+func (l Link) identity() string {
+	return "name=" + l.Name
+}
+
+// Key returns a deterministic full-key identity string for a link.
+func (l Link) Key() (string, error) {
+	n, err := l.Normalize()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|kind=%s|mtu=%d|state=%s|master=%s|parent=%s|vlan_id=%d|vni=%d|port=%d|local=%s|remote=%s|ttl=%d",
+		n.identity(), n.Kind, n.MTU, n.State, n.Master, n.Parent, n.VLANID, n.VNI, n.Port, n.Local, n.Remote, n.TTL), nil
+}
+
+// sameDevice reports whether the kind-specific attributes of two normalized
+// links match, i.e. one can be turned into the other without re-creating it.
+func (l Link) sameDevice(o Link) bool {
+	return l.Kind == o.Kind && l.Parent == o.Parent && l.VLANID == o.VLANID && l.VNI == o.VNI &&
+		l.Port == o.Port && l.Local == o.Local && l.Remote == o.Remote && l.TTL == o.TTL
+}
+
+// sortLinksForAdd orders normalized links so that parents and masters come
+// before the links using them, by name otherwise. It fails on a dependency cycle.
+func sortLinksForAdd(links []Link) error {
+	byName := make(map[string]Link, len(links))
+	names := make([]string, 0, len(links))
+	for _, l := range links {
+		byName[l.Name] = l
+		names = append(names, l.Name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(links))
+	out := make([]Link, 0, len(links))
+	var visit func(name string) error
+	visit = func(name string) error {
+		l, ok := byName[name]
+		if !ok || state[name] == done {
+			return nil
+		}
+		if state[name] == visiting {
+			return fmt.Errorf("link %s: master/parent cycle", name)
+		}
+		state[name] = visiting
+		for _, dep := range []string{l.Parent, l.Master} {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		out = append(out, l)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	copy(links, out)
+	return nil
+}
+
+// sortLinksForDelete is the reverse of sortLinksForAdd.
+func sortLinksForDelete(links []Link) error {
+	if err := sortLinksForAdd(links); err != nil {
+		return err
+	}
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPLinkManager implements LinkManager using netlink.
+//
+// Add refuses to replace a device of a kind Link cannot express, and Delete
+// leaves such a device alone. Re-creating a device (see LinkManager) drops its
+// addresses and routes; ReconcileSnapshot applies devices first, but routes already in the baseline
+// are only restored by a drift repair.
+type IPLinkManager struct {
+	// Handle, when set, is used instead of the global netlink handle;
+	// share IPRouteManager.Handle to manage devices in the same network namespace.
+	Handle *netlink.Handle
+}
+
+func (m IPLinkManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPLinkManager) List(ctx context.Context) ([]Link, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	nlLinks, err := m.handle().LinkList()
+	if err != nil {
+		return nil, err
+	}
+	links := make([]Link, 0, len(nlLinks))
+	for _, nl := range nlLinks {
+		l, err := fromNetlinkLink(m.handle(), nl)
+		if err != nil {
+			// e.g. physical devices, which Link cannot express.
+			continue
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+func (m IPLinkManager) Add(ctx context.Context, l Link) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := l.Normalize()
+	if err != nil {
+		return err
+	}
+	h := m.handle()
+
+	cur, err := h.LinkByName(n.Name)
+	var notFound netlink.LinkNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		cur = nil
+	case err != nil:
+		return err
+	default:
+		live, err := fromNetlinkLink(h, cur)
+		if err != nil {
+			// Never re-create a device this package cannot manage (veth, vrf,
+			// physical, ...): the name likely collides with a device we do not own.
+			return fmt.Errorf("link %s exists as an unmanaged %s device", n.Name, cur.Type())
+		}
+		if !live.sameDevice(n) {
+			if err := h.LinkDel(cur); err != nil {
+				return fmt.Errorf("re-create %s: %w", n.Name, err)
+			}
+			cur = nil
+		}
+	}
+
+	if cur == nil {
+		nl, err := toNetlinkLink(h, n)
+		if err != nil {
+			return err
+		}
+		if err := h.LinkAdd(nl); err != nil {
+			return err
+		}
+		if cur, err = h.LinkByName(n.Name); err != nil {
+			return err
+		}
+	}
+
+	attrs := cur.Attrs()
+	if n.MTU != 0 && attrs.MTU != n.MTU {
+		if err := h.LinkSetMTU(cur, n.MTU); err != nil {
+			return fmt.Errorf("set %s mtu: %w", n.Name, err)
+		}
+	}
+	master := 0
+	if n.Master != "" {
+		if master, err = linkIndex(h, n.Master); err != nil {
+			return err
+		}
+	}
+	if attrs.MasterIndex != master {
+		if err := h.LinkSetMasterByIndex(cur, master); err != nil {
+			return fmt.Errorf("set %s master: %w", n.Name, err)
+		}
+	}
+	up := attrs.Flags&net.FlagUp != 0
+	switch {
+	case n.State == "up" && !up:
+		err = h.LinkSetUp(cur)
+	case n.State == "down" && up:
+		err = h.LinkSetDown(cur)
+	}
+	if err != nil {
+		return fmt.Errorf("set %s %s: %w", n.Name, n.State, err)
+	}
+	return nil
+}
+
+func (m IPLinkManager) Delete(ctx context.Context, l Link) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := l.Normalize()
+	if err != nil {
+		return err
+	}
+	cur, err := m.handle().LinkByName(n.Name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	if live, err := fromNetlinkLink(m.handle(), cur); err != nil || live.Kind != n.Kind {
+		// Another device took the name; the one we managed is gone.
+		return nil
+	}
+	return m.handle().LinkDel(cur)
+}
+
+// toNetlinkLink converts a normalized link for creation.
+func toNetlinkLink(h *netlink.Handle, l Link) (netlink.Link, error) {
+	attrs := netlink.LinkAttrs{Name: l.Name, MTU: l.MTU}
+	parent := 0
+	if l.Parent != "" {
+		var err error
+		if parent, err = linkIndex(h, l.Parent); err != nil {
+			return nil, err
+		}
+	}
+	local, remote := net.ParseIP(l.Local), net.ParseIP(l.Remote)
+
+	switch l.Kind {
+	case "dummy":
+		return &netlink.Dummy{LinkAttrs: attrs}, nil
+	case "bridge":
+		// A bridge only keeps an MTU set after creation (Add does that);
+		// otherwise it follows its ports.
+		attrs.MTU = 0
+		return &netlink.Bridge{LinkAttrs: attrs}, nil
+	case "vlan":
+		attrs.ParentIndex = parent
+		return &netlink.Vlan{LinkAttrs: attrs, VlanId: l.VLANID}, nil
+	case "vxlan":
+		// Learning is on by default in iproute2 too.
+		return &netlink.Vxlan{
+			LinkAttrs: attrs, VxlanId: l.VNI, VtepDevIndex: parent, Port: l.Port,
+			SrcAddr: local, Group: remote, TTL: l.TTL, Learning: true,
+		}, nil
+	case "gre":
+		return &netlink.Gretun{
+			LinkAttrs: attrs, Link: uint32(parent), Local: local, Remote: remote,
+			Ttl: uint8(l.TTL), PMtuDisc: 1,
+		}, nil
+	case "ipip":
+		return &netlink.Iptun{
+			LinkAttrs: attrs, Link: uint32(parent), Local: local, Remote: remote,
+			Ttl: uint8(l.TTL), PMtuDisc: 1,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported link kind %q", l.Kind)
+	}
+}
+
+func fromNetlinkLink(h *netlink.Handle, nl netlink.Link) (Link, error) {
+	attrs := nl.Attrs()
+	l := Link{Name: attrs.Name, MTU: attrs.MTU, State: "down"}
+	if attrs.Flags&net.FlagUp != 0 {
+		l.State = "up"
+	}
+	if attrs.MasterIndex != 0 {
+		l.Master = linkName(h, attrs.MasterIndex)
+	}
+	ip := func(ip net.IP) string {
+		if len(ip) == 0 || ip.IsUnspecified() {
+			return ""
+		}
+		return ip.String()
+	}
+
+	switch v := nl.(type) {
+	case *netlink.Dummy:
+		l.Kind = "dummy"
+	case *netlink.Bridge:
+		l.Kind = "bridge"
+	case *netlink.Vlan:
+		l.Kind = "vlan"
+		l.VLANID = v.VlanId
+		l.Parent = linkName(h, attrs.ParentIndex)
+	case *netlink.Vxlan:
+		l.Kind = "vxlan"
+		l.VNI, l.Port, l.TTL = v.VxlanId, v.Port, v.TTL
+		l.Local, l.Remote = ip(v.SrcAddr), ip(v.Group)
+		if v.VtepDevIndex != 0 {
+			l.Parent = linkName(h, v.VtepDevIndex)
+		}
+	case *netlink.Gretun:
+		l.Kind = "gre"
+		l.Local, l.Remote, l.TTL = ip(v.Local), ip(v.Remote), int(v.Ttl)
+		if v.Link != 0 {
+			l.Parent = linkName(h, int(v.Link))
+		}
+	case *netlink.Iptun:
+		l.Kind = "ipip"
+		l.Local, l.Remote, l.TTL = ip(v.Local), ip(v.Remote), int(v.Ttl)
+		if v.Link != 0 {
+			l.Parent = linkName(h, int(v.Link))
+		}
+	default:
+		return Link{}, fmt.Errorf("unsupported link type %s", nl.Type())
+	}
+	return l.Normalize()
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPLinkManager is not supported on non-Linux platforms.
+type IPLinkManager struct {
+	Handle *netlink.Handle
+}
+
+func (m IPLinkManager) List(ctx context.Context) ([]Link, error) {
+	return nil, fmt.Errorf("IPLinkManager is supported only on linux")
+}
+
+func (m IPLinkManager) Add(ctx context.Context, l Link) error {
+	return fmt.Errorf("IPLinkManager is supported only on linux")
+}
+
+func (m IPLinkManager) Delete(ctx context.Context, l Link) error {
+	return fmt.Errorf("IPLinkManager is supported only on linux")
+}
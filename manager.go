@@ -15,3 +15,70 @@ type RouteManager interface {
 	Add(ctx context.Context, r Route) error
 	Delete(ctx context.Context, r Route) error
 }
+
+// RouteReplacer is optionally implemented by a RouteManager that can
+// atomically update a route in place (e.g. "ip route replace").
+//
+// Controller uses it for DiffResult.ToReplace; without it, a replacement
+// falls back to delete old + add new.
+type RouteReplacer interface {
+	Replace(ctx context.Context, old, new Route) error
+}
+
+// RuleManager performs CRUD against the OS policy routing rules ("ip rule").
+type RuleManager interface {
+	// List returns current rules on the system.
+	List(ctx context.Context) ([]Rule, error)
+	Add(ctx context.Context, r Rule) error
+	Delete(ctx context.Context, r Rule) error
+}
+
+// NexthopManager performs CRUD against the OS nexthop objects ("ip nexthop").
+//
+// Add creates the object or replaces the existing one with the same ID in place.
+type NexthopManager interface {
+	// List returns current nexthop objects on the system.
+	List(ctx context.Context) ([]NexthopObject, error)
+	Add(ctx context.Context, nh NexthopObject) error
+	Delete(ctx context.Context, nh NexthopObject) error
+}
+
+// AddressManager performs CRUD against the OS interface addresses ("ip addr").
+//
+// Add creates the address or updates the existing one with the same ip and device in place.
+type AddressManager interface {
+	// List returns current addresses on the system.
+	List(ctx context.Context) ([]Address, error)
+	Add(ctx context.Context, a Address) error
+	Delete(ctx context.Context, a Address) error
+}
+
+// NeighborManager performs CRUD against the OS static neighbor entries ("ip neigh").
+//
+// Add creates the entry or updates the existing one with the same identity in place.
+type NeighborManager interface {
+	// List returns current static (permanent/noarp) and proxy entries on the system.
+	List(ctx context.Context) ([]Neighbor, error)
+	Add(ctx context.Context, n Neighbor) error
+	Delete(ctx context.Context, n Neighbor) error
+}
+
+// LinkManager performs CRUD against the OS network devices ("ip link").
+//
+// Add creates the device or updates the existing one with the same name,
+// re-creating it when kind-specific attributes differ; an existing device of a
+// kind Link does not support is an error, never replaced.
+type LinkManager interface {
+	// List returns current devices of the kinds Link supports.
+	List(ctx context.Context) ([]Link, error)
+	Add(ctx context.Context, l Link) error
+	Delete(ctx context.Context, l Link) error
+}
+
+// VRFManager lists and creates VRF devices ("ip link add NAME type vrf table N").
+type VRFManager interface {
+	// List returns the VRF devices on the system.
+	List(ctx context.Context) ([]VRF, error)
+	// Add creates a VRF device and brings it up.
+	Add(ctx context.Context, v VRF) error
+}
@@ -6,19 +6,64 @@ import "sync"
 type MemoryStore struct {
 	mu     sync.Mutex
 	routes []Route
+	rules  []Rule
+
+	nexthops  []NexthopObject
+	addresses []Address
+	neighbors []Neighbor
+	links     []Link
 }
 
 func (s *MemoryStore) Load() ([]Route, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	out := make([]Route, 0, len(s.routes))
-	out = append(out, s.routes...)
-	return out, nil
+	return loadLocked(&s.mu, &s.routes), nil
 }
 
 func (s *MemoryStore) Save(routes []Route) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.routes = append([]Route(nil), routes...)
+	saveLocked(&s.mu, &s.routes, routes)
+	return nil
+}
+
+func (s *MemoryStore) LoadRules() ([]Rule, error) {
+	return loadLocked(&s.mu, &s.rules), nil
+}
+
+func (s *MemoryStore) SaveRules(rules []Rule) error {
+	saveLocked(&s.mu, &s.rules, rules)
+	return nil
+}
+
+func (s *MemoryStore) LoadNexthops() ([]NexthopObject, error) {
+	return loadLocked(&s.mu, &s.nexthops), nil
+}
+
+func (s *MemoryStore) SaveNexthops(nexthops []NexthopObject) error {
+	saveLocked(&s.mu, &s.nexthops, nexthops)
+	return nil
+}
+
+func (s *MemoryStore) LoadAddresses() ([]Address, error) {
+	return loadLocked(&s.mu, &s.addresses), nil
+}
+
+func (s *MemoryStore) SaveAddresses(addresses []Address) error {
+	saveLocked(&s.mu, &s.addresses, addresses)
+	return nil
+}
+
+func (s *MemoryStore) LoadNeighbors() ([]Neighbor, error) {
+	return loadLocked(&s.mu, &s.neighbors), nil
+}
+
+func (s *MemoryStore) SaveNeighbors(neighbors []Neighbor) error {
+	saveLocked(&s.mu, &s.neighbors, neighbors)
+	return nil
+}
+
+func (s *MemoryStore) LoadLinks() ([]Link, error) {
+	return loadLocked(&s.mu, &s.links), nil
+}
+
+func (s *MemoryStore) SaveLinks(links []Link) error {
+	saveLocked(&s.mu, &s.links, links)
 	return nil
 }
@@ -0,0 +1,172 @@
+package linuxroute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteMetrics holds the per-route RTAX attributes
+// ("ip route ... mtu 1400 advmss 1360 initcwnd 10").
+//
+// Zero means "not set". RTT and RTTVar are in milliseconds, as "ip route" prints them.
+type RouteMetrics struct {
+	MTU      int    `json:"mtu,omitempty"`
+	AdvMSS   int    `json:"advmss,omitempty"`
+	InitCwnd int    `json:"initcwnd,omitempty"`
+	InitRwnd int    `json:"initrwnd,omitempty"`
+	Hoplimit int    `json:"hoplimit,omitempty"`
+	RTT      int    `json:"rtt,omitempty"`
+	RTTVar   int    `json:"rttvar,omitempty"`
+	Window   int    `json:"window,omitempty"`
+	Congctl  string `json:"congctl,omitempty"`
+	Features int    `json:"features,omitempty"`
+	QuickACK int    `json:"quickack,omitempty"`
+}
+
+// rtaxFeatureECN is RTAX_FEATURE_ECN, the only feature "ip route" can set.
+const rtaxFeatureECN = 1
+
+// Normalize canonicalizes metrics. It returns a copy of m.
+func (m RouteMetrics) Normalize() (RouteMetrics, error) {
+	out := m
+	out.Congctl = strings.ToLower(strings.TrimSpace(out.Congctl))
+
+	for _, f := range []struct {
+		name string
+		v    int
+	}{
+		{"mtu", out.MTU}, {"advmss", out.AdvMSS}, {"initcwnd", out.InitCwnd},
+		{"initrwnd", out.InitRwnd}, {"hoplimit", out.Hoplimit}, {"rtt", out.RTT},
+		{"rttvar", out.RTTVar}, {"window", out.Window}, {"features", out.Features},
+	} {
+		if f.v < 0 {
+			return RouteMetrics{}, fmt.Errorf("metrics.%s must be >= 0", f.name)
+		}
+	}
+	if out.Hoplimit > 255 {
+		return RouteMetrics{}, fmt.Errorf("metrics.hoplimit must be <= 255")
+	}
+	if out.QuickACK != 0 && out.QuickACK != 1 {
+		return RouteMetrics{}, fmt.Errorf("metrics.quickack must be 0 or 1")
+	}
+	return out, nil
+}
+
+// IsZero reports whether no metric is set.
+func (m RouteMetrics) IsZero() bool {
+	return m == RouteMetrics{}
+}
+
+// String returns the metrics in "ip route" form (e.g. "mtu 1400 advmss 1360").
+func (m RouteMetrics) String() string {
+	return strings.Join(m.ipTokens(), " ")
+}
+
+func (m RouteMetrics) ipTokens() []string {
+	var b []string
+	add := func(name string, v int) {
+		if v != 0 {
+			b = append(b, name, strconv.Itoa(v))
+		}
+	}
+	add("mtu", m.MTU)
+	add("advmss", m.AdvMSS)
+	if m.RTT != 0 {
+		b = append(b, "rtt", strconv.Itoa(m.RTT)+"ms")
+	}
+	if m.RTTVar != 0 {
+		b = append(b, "rttvar", strconv.Itoa(m.RTTVar)+"ms")
+	}
+	add("window", m.Window)
+	add("hoplimit", m.Hoplimit)
+	add("initcwnd", m.InitCwnd)
+	add("initrwnd", m.InitRwnd)
+	if m.Features == rtaxFeatureECN {
+		b = append(b, "features", "ecn")
+	} else {
+		add("features", m.Features)
+	}
+	add("quickack", m.QuickACK)
+	if m.Congctl != "" {
+		b = append(b, "congctl", m.Congctl)
+	}
+	return b
+}
+
+// matches reports whether live satisfies spec; zero spec fields match anything.
+func (m RouteMetrics) matches(live RouteMetrics) bool {
+	eq := func(spec, got int) bool { return spec == 0 || spec == got }
+	return eq(m.MTU, live.MTU) && eq(m.AdvMSS, live.AdvMSS) &&
+		eq(m.InitCwnd, live.InitCwnd) && eq(m.InitRwnd, live.InitRwnd) &&
+		eq(m.Hoplimit, live.Hoplimit) && eq(m.RTT, live.RTT) &&
+		eq(m.RTTVar, live.RTTVar) && eq(m.Window, live.Window) &&
+		eq(m.Features, live.Features) && eq(m.QuickACK, live.QuickACK) &&
+		(m.Congctl == "" || m.Congctl == live.Congctl)
+}
+
+// isMetricOption reports whether key is an "ip route" metrics keyword.
+func isMetricOption(key string) bool {
+	switch key {
+	case "mtu", "advmss", "initcwnd", "initrwnd", "hoplimit", "rtt", "rttvar",
+		"window", "congctl", "features", "quickack":
+		return true
+	default:
+		return false
+	}
+}
+
+// setIPOption parses one "ip route" metrics option into m.
+func (m *RouteMetrics) setIPOption(key, val string) error {
+	var err error
+	switch key {
+	case "congctl":
+		m.Congctl = val
+	case "features":
+		if val == "ecn" {
+			m.Features = rtaxFeatureECN
+		} else {
+			m.Features, err = strconv.Atoi(val)
+		}
+	case "rtt":
+		m.RTT, err = parseRTT(val, 8)
+	case "rttvar":
+		m.RTTVar, err = parseRTT(val, 4)
+	default:
+		var n int
+		if n, err = strconv.Atoi(val); err != nil {
+			break
+		}
+		switch key {
+		case "mtu":
+			m.MTU = n
+		case "advmss":
+			m.AdvMSS = n
+		case "initcwnd":
+			m.InitCwnd = n
+		case "initrwnd":
+			m.InitRwnd = n
+		case "hoplimit":
+			m.Hoplimit = n
+		case "window":
+			m.Window = n
+		case "quickack":
+			m.QuickACK = n
+		}
+	}
+	return err
+}
+
+// parseRTT parses an "ip route" rtt/rttvar value into milliseconds.
+// Like iproute2, a bare number is in raw kernel units (ms*scale).
+func parseRTT(s string, scale int) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n / scale, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return int(d / time.Millisecond), nil
+}
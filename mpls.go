@@ -0,0 +1,97 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// familyMPLS is the Route.Family of label-switching entries.
+const familyMPLS = "mpls"
+
+// maxMPLSLabel is the largest 20-bit MPLS label.
+const maxMPLSLabel = 0xfffff
+
+// normalizeMPLS canonicalizes the label fields of an MPLS route and rejects
+// attributes the MPLS forwarding table does not have. The rest of r must
+// already be normalized.
+func (r *Route) normalizeMPLS() error {
+	in, err := strconv.Atoi(r.Dst)
+	if err != nil || in < 0 || in > maxMPLSLabel {
+		return fmt.Errorf("invalid route.dst %q: want an mpls label", r.Dst)
+	}
+	r.Dst = strconv.Itoa(in)
+
+	if len(r.NewDst) > 0 {
+		if err := checkMPLSLabels(r.NewDst); err != nil {
+			return fmt.Errorf("route.newdst: %w", err)
+		}
+		r.NewDst = append([]int(nil), r.NewDst...)
+	} else {
+		r.NewDst = nil
+	}
+
+	switch {
+	case r.Table != 0:
+		return fmt.Errorf("route.table is not supported for mpls routes")
+	case r.VRF != "":
+		return fmt.Errorf("route.vrf is not supported for mpls routes")
+	case r.Metric != 0:
+		return fmt.Errorf("route.metric is not supported for mpls routes")
+	case r.Src != "":
+		return fmt.Errorf("route.src is not supported for mpls routes")
+	case r.Type != "":
+		return fmt.Errorf("route.type %s is not supported for mpls routes", r.Type)
+	case r.Encap != nil:
+		return fmt.Errorf("route.encap is not supported for mpls routes")
+	case len(r.Nexthops) > 0:
+		return fmt.Errorf("route.nexthops is not supported for mpls routes")
+	case r.Metrics != nil:
+		return fmt.Errorf("route.metrics is not supported for mpls routes")
+	case r.TOS != 0:
+		return fmt.Errorf("route.tos is not supported for mpls routes")
+	}
+	r.Type = ""
+	return nil
+}
+
+// viaFamily returns the iproute2 family name of an MPLS route's gateway
+// ("via inet 10.0.0.2", "via inet6 fe80::1").
+func viaFamily(gw string) string {
+	if ip := net.ParseIP(gw); ip != nil && ip.To4() == nil {
+		return "inet6"
+	}
+	return "inet"
+}
+
+func checkMPLSLabels(labels []int) error {
+	for _, l := range labels {
+		if l < 0 || l > maxMPLSLabel {
+			return fmt.Errorf("invalid mpls label %d", l)
+		}
+	}
+	return nil
+}
+
+// parseMPLSLabels parses a label stack in "ip route" form ("100/200").
+func parseMPLSLabels(s string) ([]int, error) {
+	var labels []int
+	for _, l := range strings.Split(s, "/") {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mpls label %q: %w", l, err)
+		}
+		labels = append(labels, n)
+	}
+	return labels, nil
+}
+
+// formatMPLSLabels is the inverse of parseMPLSLabels.
+func formatMPLSLabels(labels []int) string {
+	s := make([]string, 0, len(labels))
+	for _, l := range labels {
+		s = append(s, strconv.Itoa(l))
+	}
+	return strings.Join(s, "/")
+}
@@ -0,0 +1,274 @@
+package linuxroute
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// NameResolver maps iproute2 names of routing tables, route protocols and scopes
+// to numbers and back, as configured in rt_tables, rt_tables.d/*.conf, rt_protos,
+// rt_protos.d/*.conf and rt_scopes.
+//
+// Files are read from <root>/usr/share/iproute2 (distribution defaults), then
+// <root>/etc/iproute2 (local overrides). Missing files are ignored and the
+// kernel's reserved names are always known.
+type NameResolver struct {
+	tables *nameTable
+	protos *nameTable
+	scopes *nameTable
+}
+
+// nameTable is one iproute2 name database; later entries win, as in iproute2.
+type nameTable struct {
+	what   string
+	max    uint64
+	byName map[string]int
+	byNum  map[int]string
+}
+
+type nameEntry struct {
+	name string
+	num  int
+}
+
+var builtinTables = []nameEntry{{"unspec", 0}, {"default", 253}, {"main", 254}, {"local", 255}}
+
+// builtinProtos mirrors the RTPROT_* values from linux/rtnetlink.h.
+var builtinProtos = []nameEntry{
+	{"unspec", 0}, {"redirect", 1}, {"kernel", 2}, {"boot", 3}, {"static", 4},
+	{"gated", 8}, {"ra", 9}, {"mrt", 10}, {"zebra", 11}, {"bird", 12}, {"dnrouted", 13},
+	{"xorp", 14}, {"ntk", 15}, {"dhcp", 16}, {"keepalived", 18}, {"babel", 42},
+	{"openr", 99}, {"bgp", 186}, {"isis", 187}, {"ospf", 188}, {"rip", 189}, {"eigrp", 192},
+}
+
+// builtinScopes lists "universe" before "global" so the latter is printed.
+var builtinScopes = []nameEntry{{"universe", 0}, {"global", 0}, {"site", 200}, {"link", 253}, {"host", 254}, {"nowhere", 255}}
+
+// NewNameResolver loads iproute2 name files below root ("/" for the running system).
+func NewNameResolver(root string) (*NameResolver, error) {
+	n := builtinNameResolver()
+	for _, dir := range []string{"usr/share/iproute2", "etc/iproute2"} {
+		dir = filepath.Join(root, dir)
+		loads := []struct {
+			t    *nameTable
+			file string
+			dir  bool
+		}{
+			{n.tables, "rt_tables", false},
+			{n.tables, "rt_tables.d", true},
+			{n.protos, "rt_protos", false},
+			{n.protos, "rt_protos.d", true},
+			{n.scopes, "rt_scopes", false},
+		}
+		for _, l := range loads {
+			var err error
+			if l.dir {
+				err = l.t.loadDir(filepath.Join(dir, l.file))
+			} else {
+				err = l.t.loadFile(filepath.Join(dir, l.file))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func builtinNameResolver() *NameResolver {
+	return &NameResolver{
+		tables: newNameTable("table", 0xffffffff, builtinTables),
+		protos: newNameTable("proto", 255, builtinProtos),
+		scopes: newNameTable("scope", 255, builtinScopes),
+	}
+}
+
+// builtinNames knows only the kernel's reserved names. Normalize uses it, so
+// it never depends on configuration; other names are resolved before, when
+// routes are loaded (see ResolveRoute).
+var builtinNames = builtinNameResolver()
+
+var defaultResolver atomic.Pointer[NameResolver]
+
+// DefaultNameResolver returns the resolver used by the parsers, Route's JSON
+// decoding and a Controller without Names. Unless replaced with
+// SetDefaultNameResolver, it is loaded from "/" on first use; if that fails,
+// only the reserved names are known.
+func DefaultNameResolver() *NameResolver {
+	if r := defaultResolver.Load(); r != nil {
+		return r
+	}
+	r, err := NewNameResolver("/")
+	if err != nil {
+		r = builtinNameResolver()
+	}
+	if !defaultResolver.CompareAndSwap(nil, r) {
+		return defaultResolver.Load()
+	}
+	return r
+}
+
+// SetDefaultNameResolver replaces the resolver returned by DefaultNameResolver
+// (e.g. one loaded from a test root). A nil r reloads from "/" on next use.
+func SetDefaultNameResolver(r *NameResolver) {
+	defaultResolver.Store(r)
+}
+
+// ResolveRoute returns a copy of r with its proto and scope names replaced by
+// numbers, so Normalize accepts names configured in rt_protos and rt_scopes.
+func (n *NameResolver) ResolveRoute(r Route) (Route, error) {
+	var err error
+	if r.Proto, err = canonicalName(r.Proto, n.Proto); err != nil {
+		return Route{}, fmt.Errorf("invalid route.proto: %w", err)
+	}
+	if r.Scope, err = canonicalName(r.Scope, n.Scope); err != nil {
+		return Route{}, fmt.Errorf("invalid route.scope: %w", err)
+	}
+	return r, nil
+}
+
+// ResolveSnapshot resolves the names of every route, nexthop object and
+// address of snap, like ResolveRoute.
+func (n *NameResolver) ResolveSnapshot(snap Snapshot) (Snapshot, error) {
+	out := snap
+	out.Routes = append([]Route(nil), snap.Routes...)
+	out.Nexthops = append([]NexthopObject(nil), snap.Nexthops...)
+	out.Addresses = append([]Address(nil), snap.Addresses...)
+	var err error
+	for i := range out.Routes {
+		if out.Routes[i], err = n.ResolveRoute(out.Routes[i]); err != nil {
+			return Snapshot{}, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	for i := range out.Nexthops {
+		if out.Nexthops[i].Proto, err = canonicalName(out.Nexthops[i].Proto, n.Proto); err != nil {
+			return Snapshot{}, fmt.Errorf("nexthops[%d]: invalid nexthop.proto: %w", i, err)
+		}
+	}
+	for i := range out.Addresses {
+		if out.Addresses[i].Scope, err = canonicalName(out.Addresses[i].Scope, n.Scope); err != nil {
+			return Snapshot{}, fmt.Errorf("addresses[%d]: invalid address.scope: %w", i, err)
+		}
+	}
+	return out, nil
+}
+
+// Table resolves a routing table name or number.
+func (n *NameResolver) Table(s string) (int, error) { return n.tables.resolve(s) }
+
+// Proto resolves a route protocol name or number.
+func (n *NameResolver) Proto(s string) (int, error) { return n.protos.resolve(s) }
+
+// Scope resolves a route scope name or number.
+func (n *NameResolver) Scope(s string) (int, error) { return n.scopes.resolve(s) }
+
+// TableName returns the name of a routing table, or its number if it has none.
+func (n *NameResolver) TableName(v int) string { return n.tables.name(v) }
+
+// ProtoName returns the name of a route protocol, or its number if it has none.
+func (n *NameResolver) ProtoName(v int) string { return n.protos.name(v) }
+
+// ScopeName returns the name of a route scope, or its number if it has none.
+func (n *NameResolver) ScopeName(v int) string { return n.scopes.name(v) }
+
+// canonicalName resolves s with fn and returns the number as a string,
+// which is the form Normalize stores. An empty s stays empty.
+func canonicalName(s string, fn func(string) (int, error)) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", nil
+	}
+	v, err := fn(s)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(v), nil
+}
+
+func newNameTable(what string, max uint64, entries []nameEntry) *nameTable {
+	t := &nameTable{what: what, max: max, byName: map[string]int{}, byNum: map[int]string{}}
+	for _, e := range entries {
+		t.set(e.name, e.num)
+	}
+	return t
+}
+
+func (t *nameTable) set(name string, num int) {
+	t.byName[name] = num
+	t.byNum[num] = name
+}
+
+func (t *nameTable) resolve(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, err := strconv.ParseUint(s, 0, 32); err == nil {
+		if v > t.max {
+			return 0, fmt.Errorf("%s %q is out of range", t.what, s)
+		}
+		return int(v), nil
+	}
+	if v, ok := t.byName[s]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown %s %q", t.what, s)
+}
+
+func (t *nameTable) name(v int) string {
+	if s, ok := t.byNum[v]; ok {
+		return s
+	}
+	return strconv.Itoa(v)
+}
+
+func (t *nameTable) loadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		if err := t.loadFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile reads "<number> <name>" lines, skipping blanks and "#" comments.
+func (t *nameTable) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected \"<number> <name>\"", path, lineNo)
+		}
+		v, err := strconv.ParseUint(fields[0], 0, 32)
+		if err != nil || v > t.max {
+			return fmt.Errorf("%s:%d: invalid %s number %q", path, lineNo, t.what, fields[0])
+		}
+		t.set(strings.ToLower(fields[1]), int(v))
+	}
+	return sc.Err()
+}
@@ -0,0 +1,107 @@
+package linuxroute
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNameResolver(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("usr/share/iproute2/rt_protos", "12 bird\n200 old\n")
+	write("etc/iproute2/rt_tables", "# reserved\n255 local\n254 main\n100 vpn # tunnel\n")
+	write("etc/iproute2/rt_tables.d/guest.conf", "0x65 guest\n")
+	write("etc/iproute2/rt_protos.d/mine.conf", "200 mine\n")
+
+	names, err := NewNameResolver(root)
+	if err != nil {
+		t.Fatalf("NewNameResolver: %v", err)
+	}
+	SetDefaultNameResolver(names)
+	defer SetDefaultNameResolver(nil)
+
+	for s, want := range map[string]int{"vpn": 100, "guest": 101, "main": 254, "7": 7} {
+		if got, err := names.Table(s); err != nil || got != want {
+			t.Fatalf("Table(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	if _, err := names.Table("nope"); err == nil {
+		t.Fatalf("expected error for unknown table")
+	}
+	if got, _ := names.Proto("mine"); got != 200 {
+		t.Fatalf("Proto(mine) = %d, want 200", got)
+	}
+	if got := names.ProtoName(200); got != "mine" {
+		t.Fatalf("ProtoName(200) = %q, want mine", got)
+	}
+
+	var routes []Route
+	in := `[{"dst":"10.0.0.0/8","table":"main","proto":"bird","scope":"link"},
+	        {"dst":"10.0.0.0/8","table":254,"proto":"12","scope":"253"}]`
+	if err := json.Unmarshal([]byte(in), &routes); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	k0, err := routes[0].Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1, _ := routes[1].Key()
+	if k0 != k1 {
+		t.Fatalf("keys differ:\n%s\n%s", k0, k1)
+	}
+	if got := routes[0].IPRouteString(); got != "10.0.0.0/8 table main proto bird scope link" {
+		t.Fatalf("IPRouteString = %q", got)
+	}
+
+	r, err := ParseIPRouteLine("10.1.0.0/16 dev wg0 table vpn proto mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Table != 100 || r.Proto != "200" {
+		t.Fatalf("ParseIPRouteLine = %+v", r)
+	}
+
+	// Normalize only knows the reserved names; others are resolved beforehand.
+	if _, err := (Route{Dst: "10.2.0.0/16", Proto: "mine"}).Normalize(); err == nil {
+		t.Fatalf("Normalize resolved a configured proto name")
+	}
+	snap, err := names.ResolveSnapshot(Snapshot{
+		Routes:    []Route{{Dst: "10.2.0.0/16", Proto: "mine", Scope: "link"}},
+		Nexthops:  []NexthopObject{{ID: 1, Blackhole: true, Proto: "bird"}},
+		Addresses: []Address{{IP: "10.0.0.5/24", Device: "eth0", Scope: "host"}},
+	})
+	if err != nil {
+		t.Fatalf("ResolveSnapshot: %v", err)
+	}
+	if snap.Routes[0].Proto != "200" || snap.Routes[0].Scope != "253" || snap.Nexthops[0].Proto != "12" || snap.Addresses[0].Scope != "254" {
+		t.Fatalf("ResolveSnapshot = %+v", snap)
+	}
+	if _, err := names.ResolveSnapshot(Snapshot{Nexthops: []NexthopObject{{ID: 1, Proto: "nope"}}}); err == nil || !strings.Contains(err.Error(), "nexthops[0]") {
+		t.Fatalf("expected an unknown nexthop proto to be rejected, got %v", err)
+	}
+
+	// A Controller resolves desired routes and OwnerProto with its own resolver.
+	SetDefaultNameResolver(builtinNameResolver())
+	c := Controller{Manager: &fakeManager{}, Store: &MemoryStore{}, OwnerProto: "mine", Names: names}
+	res, err := c.Reconcile(context.Background(), []Route{{Dst: "10.3.0.0/16", Device: "eth0"}, {Dst: "10.4.0.0/16", Device: "eth0", Proto: "mine"}})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	for _, r := range res.Diff.ToAdd {
+		if r.Proto != "200" {
+			t.Fatalf("Reconcile added %+v, want proto 200", r)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Neighbor describes a static neighbor (ARP/NDP) entry in a mostly "ip neigh"
+// compatible form ("ip neigh replace 10.0.0.1 lladdr 52:54:00:12:34:56 dev eth0 nud permanent").
+//
+// Notes:
+//   - IP and Device are required and, with Proxy, form the entry identity.
+//   - State is "permanent" (the default) or "noarp"; LLAddr is required for both.
+//   - Proxy makes a proxy entry ("ip neigh add proxy 10.0.0.1 dev eth0"), which
+//     has neither LLAddr nor State.
+type Neighbor struct {
+	IP     string `json:"ip"`
+	LLAddr string `json:"lladdr,omitempty"`
+	Device string `json:"device"`
+	State  string `json:"state,omitempty"`
+	Proxy  bool   `json:"proxy,omitempty"`
+}
+
+// Normalize canonicalizes fields so diffing is stable.
+// It returns a copy of n.
+func (n Neighbor) Normalize() (Neighbor, error) {
+	out := n
+
+	out.IP = strings.TrimSpace(out.IP)
+	out.LLAddr = strings.TrimSpace(out.LLAddr)
+	out.Device = strings.TrimSpace(out.Device)
+	out.State = strings.ToLower(strings.TrimSpace(out.State))
+
+	ip := net.ParseIP(out.IP)
+	if ip == nil {
+		return Neighbor{}, fmt.Errorf("invalid neighbor.ip %q", n.IP)
+	}
+	out.IP = ip.String()
+	if out.Device == "" {
+		return Neighbor{}, fmt.Errorf("neighbor.device is required")
+	}
+
+	if out.Proxy {
+		if out.LLAddr != "" || out.State != "" {
+			return Neighbor{}, fmt.Errorf("neighbor.lladdr/state must be empty for a proxy entry")
+		}
+		return out, nil
+	}
+
+	switch out.State {
+	case "":
+		out.State = "permanent"
+	case "permanent", "noarp":
+	default:
+		return Neighbor{}, fmt.Errorf("unsupported neighbor.state %q", n.State)
+	}
+	if out.LLAddr == "" {
+		return Neighbor{}, fmt.Errorf("neighbor.lladdr is required")
+	}
+	mac, err := net.ParseMAC(out.LLAddr)
+	if err != nil {
+		return Neighbor{}, fmt.Errorf("invalid neighbor.lladdr %q: %w", n.LLAddr, err)
+	}
+	out.LLAddr = mac.String()
+
+	return out, nil
+}
+
+// identity returns the kernel identity of a normalized neighbor.
+func (n Neighbor) identity() string {
+	return fmt.Sprintf("ip=%s|dev=%s|proxy=%t", n.IP, n.Device, n.Proxy)
+}
+
+// Key returns a deterministic full-key identity string for a neighbor.
+func (n Neighbor) Key() (string, error) {
+	nn, err := n.Normalize()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|lladdr=%s|state=%s", nn.identity(), nn.LLAddr, nn.State), nil
+}
+
+func sortNeighbors(nn []Neighbor) {
+	sort.Slice(nn, func(i, j int) bool {
+		ki, _ := nn[i].Key()
+		kj, _ := nn[j].Key()
+		return ki < kj
+	})
+}
@@ -0,0 +1,145 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPNeighborManager implements NeighborManager using netlink.
+type IPNeighborManager struct {
+	// Handle, when set, is used instead of the global netlink handle;
+	// share IPRouteManager.Handle to manage neighbors in the same network namespace.
+	Handle *netlink.Handle
+}
+
+func (m IPNeighborManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPNeighborManager) List(ctx context.Context) ([]Neighbor, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var neighs []Neighbor
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		entries, err := m.handle().NeighList(0, family)
+		if err != nil {
+			return nil, err
+		}
+		proxies, err := m.handle().NeighProxyList(0, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, nn := range append(entries, proxies...) {
+			n, err := fromNetlinkNeigh(m.handle(), nn)
+			if err != nil {
+				// e.g. dynamic entries, which are owned by the kernel.
+				continue
+			}
+			neighs = append(neighs, n)
+		}
+	}
+	return neighs, nil
+}
+
+func (m IPNeighborManager) Add(ctx context.Context, n Neighbor) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	nn, err := toNetlinkNeigh(m.handle(), n)
+	if err != nil {
+		return err
+	}
+	return m.handle().NeighSet(nn)
+}
+
+func (m IPNeighborManager) Delete(ctx context.Context, n Neighbor) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	nn, err := toNetlinkNeigh(m.handle(), n)
+	if err != nil {
+		// The entry went away with its device.
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return err
+	}
+	err = m.handle().NeighDel(nn)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+func toNetlinkNeigh(h *netlink.Handle, n Neighbor) (*netlink.Neigh, error) {
+	nb, err := n.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	link, err := h.LinkByName(nb.Device)
+	if err != nil {
+		return nil, err
+	}
+
+	nn := &netlink.Neigh{
+		LinkIndex: link.Attrs().Index,
+		Family:    netlink.FAMILY_V6,
+		IP:        net.ParseIP(nb.IP),
+	}
+	if v4 := nn.IP.To4(); v4 != nil {
+		nn.Family, nn.IP = netlink.FAMILY_V4, v4
+	}
+	if nb.Proxy {
+		nn.Flags = netlink.NTF_PROXY
+		return nn, nil
+	}
+	nn.HardwareAddr, _ = net.ParseMAC(nb.LLAddr)
+	nn.State = netlink.NUD_PERMANENT
+	if nb.State == "noarp" {
+		nn.State = netlink.NUD_NOARP
+	}
+	return nn, nil
+}
+
+// fromNetlinkNeigh converts a static entry; dynamic (REACHABLE, STALE, ...)
+// entries and the NOARP entries the kernel derives for multicast addresses
+// cannot be expressed as a Neighbor.
+func fromNetlinkNeigh(h *netlink.Handle, nn netlink.Neigh) (Neighbor, error) {
+	if nn.Flags&netlink.NTF_PROXY == 0 && (nn.State&(netlink.NUD_PERMANENT|netlink.NUD_NOARP) == 0 || nn.IP.IsMulticast()) {
+		return Neighbor{}, fmt.Errorf("neighbor %s is not static (state %#x)", nn.IP, nn.State)
+	}
+	n := Neighbor{
+		IP:     nn.IP.String(),
+		Device: linkName(h, nn.LinkIndex),
+		Proxy:  nn.Flags&netlink.NTF_PROXY != 0,
+	}
+	if !n.Proxy {
+		n.LLAddr = nn.HardwareAddr.String()
+		n.State = "permanent"
+		if nn.State&netlink.NUD_NOARP != 0 {
+			n.State = "noarp"
+		}
+	}
+	return n.Normalize()
+}
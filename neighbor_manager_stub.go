@@ -0,0 +1,27 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPNeighborManager is not supported on non-Linux platforms.
+type IPNeighborManager struct {
+	Handle *netlink.Handle
+}
+
+func (m IPNeighborManager) List(ctx context.Context) ([]Neighbor, error) {
+	return nil, fmt.Errorf("IPNeighborManager is supported only on linux")
+}
+
+func (m IPNeighborManager) Add(ctx context.Context, n Neighbor) error {
+	return fmt.Errorf("IPNeighborManager is supported only on linux")
+}
+
+func (m IPNeighborManager) Delete(ctx context.Context, n Neighbor) error {
+	return fmt.Errorf("IPNeighborManager is supported only on linux")
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// NewIPRouteManagerAt returns an IPRouteManager bound to the network namespace ns.
+//
+// The namespace is entered only while the netlink socket is created, on a locked
+// OS thread; later calls never setns, so many managers can run concurrently.
+// The caller keeps ownership of ns and must keep it open while Watch is used.
+// Call Close to release the handle.
+func NewIPRouteManagerAt(ns netns.NsHandle) (*IPRouteManager, error) {
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, fmt.Errorf("netlink handle: %w", err)
+	}
+	return &IPRouteManager{Handle: h, ns: &ns}, nil
+}
+
+// NewIPRouteManagerAtPath is like NewIPRouteManagerAt for a namespace path
+// such as "/var/run/netns/foo".
+func NewIPRouteManagerAtPath(path string) (*IPRouteManager, error) {
+	ns, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("netns %q: %w", path, err)
+	}
+	return newOwnedIPRouteManagerAt(ns)
+}
+
+// NewIPRouteManagerAtPID is like NewIPRouteManagerAt for the namespace of process pid.
+func NewIPRouteManagerAtPID(pid int) (*IPRouteManager, error) {
+	ns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return nil, fmt.Errorf("netns of pid %d: %w", pid, err)
+	}
+	return newOwnedIPRouteManagerAt(ns)
+}
+
+func newOwnedIPRouteManagerAt(ns netns.NsHandle) (*IPRouteManager, error) {
+	m, err := NewIPRouteManagerAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, err
+	}
+	m.ownsNS = true
+	return m, nil
+}
+
+// Close releases m.Handle and the namespace opened by the constructor, if any.
+func (m IPRouteManager) Close() {
+	if m.Handle != nil {
+		m.Handle.Close()
+	}
+	if m.ownsNS && m.ns != nil {
+		m.ns.Close()
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netns"
+)
+
+func NewIPRouteManagerAt(ns netns.NsHandle) (*IPRouteManager, error) {
+	return nil, fmt.Errorf("IPRouteManager is supported only on linux")
+}
+
+func NewIPRouteManagerAtPath(path string) (*IPRouteManager, error) {
+	return nil, fmt.Errorf("IPRouteManager is supported only on linux")
+}
+
+func NewIPRouteManagerAtPID(pid int) (*IPRouteManager, error) {
+	return nil, fmt.Errorf("IPRouteManager is supported only on linux")
+}
+
+func (m IPRouteManager) Close() {}
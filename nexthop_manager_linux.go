@@ -0,0 +1,502 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// rtaNhID is RTA_NH_ID, the nexthop object referenced by a route;
+// nhaFDB is NHA_FDB. Neither is defined by x/sys/unix here.
+const (
+	rtaNhID = 30
+	nhaFDB  = 11
+)
+
+// IPNexthopManager implements NexthopManager with raw netlink requests,
+// since the netlink library has no nexthop object support.
+type IPNexthopManager struct {
+	// Handle, when set, is used to resolve device names instead of the global handle.
+	Handle *netlink.Handle
+	// Namespace, when set, is the network namespace to manage. It must be the one
+	// Handle is bound to; IPRouteManager.NexthopManager sets both.
+	Namespace *netns.NsHandle
+}
+
+// NexthopManager returns an IPNexthopManager for the namespace of m.
+func (m IPRouteManager) NexthopManager() IPNexthopManager {
+	return IPNexthopManager{Handle: m.Handle, Namespace: m.ns}
+}
+
+func (m IPNexthopManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPNexthopManager) List(ctx context.Context) ([]NexthopObject, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	req, done, err := rawRequest(m.Namespace, unix.RTM_GETNEXTHOP, unix.NLM_F_DUMP)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	req.AddData(&nhMsg{})
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWNEXTHOP)
+	if err != nil {
+		return nil, err
+	}
+
+	nhs := make([]NexthopObject, 0, len(msgs))
+	for _, msg := range msgs {
+		nh, err := m.fromNexthopMsg(msg)
+		if err != nil {
+			// e.g. fdb nexthops, which NexthopObject cannot express.
+			continue
+		}
+		nhs = append(nhs, nh)
+	}
+	return nhs, nil
+}
+
+func (m IPNexthopManager) Add(ctx context.Context, nh NexthopObject) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := nh.Normalize()
+	if err != nil {
+		return err
+	}
+	req, done, err := rawRequest(m.Namespace, unix.RTM_NEWNEXTHOP, unix.NLM_F_CREATE|unix.NLM_F_REPLACE|unix.NLM_F_ACK)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	msg := &nhMsg{Family: familyNumber(n.Family)}
+	if n.Proto != "" {
+		p, err := builtinNames.Proto(n.Proto)
+		if err != nil {
+			return err
+		}
+		msg.Protocol = uint8(p)
+	}
+	if n.Onlink {
+		msg.Flags |= unix.RTNH_F_ONLINK
+	}
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(unix.NHA_ID, nl.Uint32Attr(n.ID)))
+
+	switch {
+	case len(n.Group) > 0:
+		// struct nexthop_grp { u32 id; u8 weight; u8 resvd1; u16 resvd2; }, weight is stored minus one.
+		buf := make([]byte, 0, 8*len(n.Group))
+		for _, e := range n.Group {
+			entry := make([]byte, 8)
+			nl.NativeEndian().PutUint32(entry, e.ID)
+			entry[4] = uint8(e.Weight - 1)
+			buf = append(buf, entry...)
+		}
+		req.AddData(nl.NewRtAttr(unix.NHA_GROUP, buf))
+	case n.Blackhole:
+		req.AddData(nl.NewRtAttr(unix.NHA_BLACKHOLE, nil))
+	default:
+		idx, err := linkIndex(m.handle(), n.Device)
+		if err != nil {
+			return err
+		}
+		req.AddData(nl.NewRtAttr(unix.NHA_OIF, nl.Uint32Attr(uint32(idx))))
+		if n.Gateway != "" {
+			gw := net.ParseIP(n.Gateway)
+			if v4 := gw.To4(); v4 != nil {
+				gw = v4
+			}
+			req.AddData(nl.NewRtAttr(unix.NHA_GATEWAY, gw))
+		}
+	}
+
+	_, err = req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+func (m IPNexthopManager) Delete(ctx context.Context, nh NexthopObject) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if nh.ID == 0 {
+		return fmt.Errorf("nexthop.id must be > 0")
+	}
+	req, done, err := rawRequest(m.Namespace, unix.RTM_DELNEXTHOP, unix.NLM_F_ACK)
+	if err != nil {
+		return err
+	}
+	defer done()
+	req.AddData(&nhMsg{})
+	req.AddData(nl.NewRtAttr(unix.NHA_ID, nl.Uint32Attr(nh.ID)))
+
+	_, err = req.Execute(unix.NETLINK_ROUTE, 0)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// get returns the nexthop object with the given ID.
+func (m IPNexthopManager) get(id uint32) (NexthopObject, error) {
+	req, done, err := rawRequest(m.Namespace, unix.RTM_GETNEXTHOP, 0)
+	if err != nil {
+		return NexthopObject{}, err
+	}
+	defer done()
+	req.AddData(&nhMsg{})
+	req.AddData(nl.NewRtAttr(unix.NHA_ID, nl.Uint32Attr(id)))
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWNEXTHOP)
+	if err != nil {
+		return NexthopObject{}, fmt.Errorf("nexthop %d: %w", id, err)
+	}
+	if len(msgs) != 1 {
+		return NexthopObject{}, fmt.Errorf("nexthop %d: not found", id)
+	}
+	return m.fromNexthopMsg(msgs[0])
+}
+
+func (m IPNexthopManager) fromNexthopMsg(b []byte) (NexthopObject, error) {
+	if len(b) < nhMsgLen {
+		return NexthopObject{}, fmt.Errorf("short nexthop message")
+	}
+	attrs, err := nl.ParseRouteAttr(b[nhMsgLen:])
+	if err != nil {
+		return NexthopObject{}, err
+	}
+
+	nh := NexthopObject{Onlink: nl.NativeEndian().Uint32(b[4:8])&unix.RTNH_F_ONLINK != 0}
+	switch b[0] {
+	case unix.AF_INET:
+		nh.Family = "inet"
+	case unix.AF_INET6:
+		nh.Family = "inet6"
+	}
+	if b[2] != 0 {
+		nh.Proto = strconv.Itoa(int(b[2]))
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.NHA_ID:
+			nh.ID = nl.NativeEndian().Uint32(a.Value)
+		case unix.NHA_GROUP:
+			for v := a.Value; len(v) >= 8; v = v[8:] {
+				nh.Group = append(nh.Group, NexthopGroupEntry{
+					ID:     nl.NativeEndian().Uint32(v),
+					Weight: int(v[4]) + 1,
+				})
+			}
+		case unix.NHA_BLACKHOLE:
+			nh.Blackhole = true
+		case unix.NHA_OIF:
+			nh.Device = linkName(m.handle(), int(nl.NativeEndian().Uint32(a.Value)))
+		case unix.NHA_GATEWAY:
+			nh.Gateway = net.IP(a.Value).String()
+		case nhaFDB:
+			return NexthopObject{}, fmt.Errorf("nexthop %d: fdb nexthops are not supported", nh.ID)
+		}
+	}
+	if len(nh.Group) > 0 {
+		nh.Family = ""
+	}
+	return nh.Normalize()
+}
+
+// nhMsgLen is sizeof(struct nhmsg).
+const nhMsgLen = 8
+
+// nhMsg is struct nhmsg, the header of nexthop object messages.
+type nhMsg struct {
+	Family   uint8
+	Scope    uint8
+	Protocol uint8
+	Flags    uint32
+}
+
+func (msg *nhMsg) Len() int {
+	return nhMsgLen
+}
+
+func (msg *nhMsg) Serialize() []byte {
+	b := make([]byte, nhMsgLen)
+	b[0], b[1], b[2] = msg.Family, msg.Scope, msg.Protocol
+	nl.NativeEndian().PutUint32(b[4:], msg.Flags)
+	return b
+}
+
+func familyNumber(family string) uint8 {
+	switch family {
+	case "inet":
+		return unix.AF_INET
+	case "inet6":
+		return unix.AF_INET6
+	default:
+		return unix.AF_UNSPEC
+	}
+}
+
+// rawRequest returns a netlink request sent on a socket in ns (the current
+// namespace when nil), for messages the netlink library does not support.
+// Call done once the request has been executed.
+func rawRequest(ns *netns.NsHandle, proto, flags int) (req *nl.NetlinkRequest, done func(), err error) {
+	req = nl.NewNetlinkRequest(proto, flags)
+	if ns == nil {
+		return req, func() {}, nil
+	}
+	s, err := nl.GetNetlinkSocketAt(*ns, netns.None(), unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	req.Sockets = map[int]*nl.SocketHandle{unix.NETLINK_ROUTE: {Socket: s}}
+	return req, s.Close, nil
+}
+
+// routeSlot identifies a kernel route by the fields the kernel keys it on.
+type routeSlot struct {
+	family   int
+	table    int
+	dst      string
+	priority int
+	tos      int
+}
+
+func slotDst(dst *net.IPNet) string {
+	if dst == nil {
+		return ""
+	}
+	if ones, _ := dst.Mask.Size(); ones == 0 {
+		return ""
+	}
+	return dst.String()
+}
+
+// rawRouteAttrs are the attributes of a kernel route the netlink library does not decode.
+type rawRouteAttrs struct {
+	// nhid is the nexthop object the route uses.
+	nhid uint32
+	// encap is the ip/ip6 encap of the route, hopEncaps those of its paths in
+	// kernel order (nil for other paths).
+	encap     *Encap
+	hopEncaps []*Encap
+}
+
+// routeRawAttrs dumps the routes of family and returns the attributes the
+// netlink library does not decode of each route having some.
+func (m IPRouteManager) routeRawAttrs(family int) (map[routeSlot]rawRouteAttrs, error) {
+	req, done, err := rawRequest(m.ns, unix.RTM_GETROUTE, unix.NLM_F_DUMP)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	req.AddData(&nl.RtMsg{RtMsg: unix.RtMsg{Family: uint8(family)}})
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make(map[routeSlot]rawRouteAttrs)
+	for _, b := range msgs {
+		if len(b) < unix.SizeofRtMsg {
+			continue
+		}
+		msg := nl.DeserializeRtMsg(b)
+		if int(msg.Family) != family {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(b[unix.SizeofRtMsg:])
+		if err != nil {
+			continue
+		}
+		slot := routeSlot{family: family, table: int(msg.Table), tos: int(msg.Tos)}
+		var raw rawRouteAttrs
+		var encapType int
+		var encap []byte
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case unix.RTA_DST:
+				slot.dst = slotDst(&net.IPNet{IP: a.Value, Mask: net.CIDRMask(int(msg.Dst_len), 8*len(a.Value))})
+			case unix.RTA_TABLE:
+				slot.table = int(binary.NativeEndian.Uint32(a.Value))
+			case unix.RTA_PRIORITY:
+				slot.priority = int(binary.NativeEndian.Uint32(a.Value))
+			case rtaNhID:
+				raw.nhid = binary.NativeEndian.Uint32(a.Value)
+			case unix.RTA_ENCAP_TYPE:
+				encapType = int(binary.NativeEndian.Uint16(a.Value))
+			case unix.RTA_ENCAP:
+				encap = a.Value
+			case unix.RTA_MULTIPATH:
+				raw.hopEncaps = multipathIPTunnelEncaps(a.Value)
+			}
+		}
+		if e, ok := decodeIPTunnelEncap(encapType, encap); ok {
+			raw.encap = &e
+		}
+		if raw.nhid != 0 || raw.encap != nil || raw.hopEncaps != nil {
+			routes[slot] = raw
+		}
+	}
+	return routes, nil
+}
+
+// multipathIPTunnelEncaps returns the ip/ip6 encaps of the paths of an
+// RTA_MULTIPATH attribute, or nil if none has one.
+func multipathIPTunnelEncaps(b []byte) []*Encap {
+	var encaps []*Encap
+	found := false
+	for len(b) >= unix.SizeofRtNexthop {
+		n := int(binary.NativeEndian.Uint16(b[0:2]))
+		if n < unix.SizeofRtNexthop || n > len(b) {
+			break
+		}
+		var e *Encap
+		if attrs, err := nl.ParseRouteAttr(b[unix.SizeofRtNexthop:n]); err == nil {
+			var encapType int
+			var encap []byte
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case unix.RTA_ENCAP_TYPE:
+					encapType = int(binary.NativeEndian.Uint16(a.Value))
+				case unix.RTA_ENCAP:
+					encap = a.Value
+				}
+			}
+			if d, ok := decodeIPTunnelEncap(encapType, encap); ok {
+				e, found = &d, true
+			}
+		}
+		encaps = append(encaps, e)
+		n = (n + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+		if n > len(b) {
+			break
+		}
+		b = b[n:]
+	}
+	if !found {
+		return nil
+	}
+	return encaps
+}
+
+// addNexthopRoute adds a route referencing a nexthop object, which the
+// netlink library cannot express.
+func (m IPRouteManager) addNexthopRoute(n Route) error {
+	if n.Metrics != nil {
+		return fmt.Errorf("route.metrics is not supported with route.nhid")
+	}
+
+	msg := nl.NewRtMsg()
+	msg.Table = unix.RT_TABLE_UNSPEC
+	var attrs []*nl.RtAttr
+	if n.Dst != "default" {
+		_, dst, err := net.ParseCIDR(n.Dst)
+		if err != nil {
+			return fmt.Errorf("invalid dst %q: %w", n.Dst, err)
+		}
+		ones, _ := dst.Mask.Size()
+		msg.Dst_len = uint8(ones)
+		msg.Family = unix.AF_INET6
+		ip := dst.IP
+		if v4 := ip.To4(); v4 != nil {
+			msg.Family, ip = unix.AF_INET, v4
+		}
+		attrs = append(attrs, nl.NewRtAttr(unix.RTA_DST, ip))
+	} else {
+		// The family of a default route follows from its nexthop object.
+		nh, err := m.NexthopManager().get(n.NexthopID)
+		if err != nil {
+			return err
+		}
+		if len(nh.Group) > 0 {
+			if nh, err = m.NexthopManager().get(nh.Group[0].ID); err != nil {
+				return err
+			}
+		}
+		msg.Family = familyNumber(nh.Family)
+	}
+	attrs = append(attrs, nl.NewRtAttr(rtaNhID, nl.Uint32Attr(n.NexthopID)))
+
+	table := n.Table
+	if n.VRF != "" {
+		t, err := vrfTable(m.handle(), n.VRF)
+		if err != nil {
+			return err
+		}
+		table = t
+	}
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	attrs = append(attrs, nl.NewRtAttr(unix.RTA_TABLE, nl.Uint32Attr(uint32(table))))
+	if n.Metric != 0 {
+		attrs = append(attrs, nl.NewRtAttr(unix.RTA_PRIORITY, nl.Uint32Attr(uint32(n.Metric))))
+	}
+	if n.Src != "" {
+		src := net.ParseIP(n.Src)
+		if v4 := src.To4(); v4 != nil {
+			src = v4
+		}
+		attrs = append(attrs, nl.NewRtAttr(unix.RTA_PREFSRC, src))
+	}
+	names := builtinNames
+	if n.Scope != "" {
+		sc, err := names.Scope(n.Scope)
+		if err != nil {
+			return err
+		}
+		msg.Scope = uint8(sc)
+	}
+	if n.Type != "" {
+		rt, ok := parseRouteType(n.Type)
+		if !ok {
+			return fmt.Errorf("unsupported type %q", n.Type)
+		}
+		msg.Type = uint8(rt)
+	}
+	if n.Proto != "" {
+		p, err := names.Proto(n.Proto)
+		if err != nil {
+			return err
+		}
+		msg.Protocol = uint8(p)
+	}
+
+	req, done, err := rawRequest(m.ns, unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE|unix.NLM_F_ACK)
+	if err != nil {
+		return err
+	}
+	defer done()
+	req.AddData(msg)
+	for _, a := range attrs {
+		req.AddData(a)
+	}
+	_, err = req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
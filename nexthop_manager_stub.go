@@ -0,0 +1,33 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// IPNexthopManager is not supported on non-Linux platforms.
+type IPNexthopManager struct {
+	Handle    *netlink.Handle
+	Namespace *netns.NsHandle
+}
+
+func (m IPRouteManager) NexthopManager() IPNexthopManager {
+	return IPNexthopManager{Handle: m.Handle}
+}
+
+func (m IPNexthopManager) List(ctx context.Context) ([]NexthopObject, error) {
+	return nil, fmt.Errorf("IPNexthopManager is supported only on linux")
+}
+
+func (m IPNexthopManager) Add(ctx context.Context, nh NexthopObject) error {
+	return fmt.Errorf("IPNexthopManager is supported only on linux")
+}
+
+func (m IPNexthopManager) Delete(ctx context.Context, nh NexthopObject) error {
+	return fmt.Errorf("IPNexthopManager is supported only on linux")
+}
@@ -0,0 +1,181 @@
+package linuxroute
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// NexthopObject describes a kernel nexthop object in a mostly "ip nexthop"
+// compatible form (not to be confused with Nexthop, a path of a multipath route).
+// Routes reference it by ID through Route.NexthopID.
+//
+// Notes:
+//   - ID is required and is the object's identity: changing any other field
+//     replaces the object in place, so routes using it are updated atomically.
+//     The kernel cannot turn a nexthop into a group or back, so such a change
+//     is refused by ReconcileSnapshot; it needs a new ID.
+//   - Exactly one of Device (with an optional Gateway), Blackhole or Group is set:
+//     "ip nexthop add id 1 via 10.0.0.1 dev eth0", "id 2 blackhole", "id 10 group 1/2,3".
+//   - Family is derived from Gateway and defaults to "inet"; groups have none.
+//   - Proto is a number or a reserved name ("static"); Normalize stores a number.
+//     Names from rt_protos are resolved when a snapshot is loaded
+//     (see NameResolver.ResolveSnapshot).
+type NexthopObject struct {
+	ID        uint32              `json:"id"`
+	Family    string              `json:"family,omitempty"`
+	Gateway   string              `json:"gateway,omitempty"`
+	Device    string              `json:"device,omitempty"`
+	Onlink    bool                `json:"onlink,omitempty"`
+	Blackhole bool                `json:"blackhole,omitempty"`
+	Group     []NexthopGroupEntry `json:"group,omitempty"`
+	Proto     string              `json:"proto,omitempty"`
+}
+
+// NexthopGroupEntry is a member of a nexthop group.
+//
+// Weight is optional; 0 means the kernel default (1).
+type NexthopGroupEntry struct {
+	ID     uint32 `json:"id"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// Normalize canonicalizes fields so diffing is stable.
+// It returns a copy of nh.
+func (nh NexthopObject) Normalize() (NexthopObject, error) {
+	out := nh
+
+	out.Family = strings.ToLower(strings.TrimSpace(out.Family))
+	out.Gateway = strings.TrimSpace(out.Gateway)
+	out.Device = strings.TrimSpace(out.Device)
+	out.Proto = strings.TrimSpace(out.Proto)
+
+	if out.ID == 0 {
+		return NexthopObject{}, fmt.Errorf("nexthop.id must be > 0")
+	}
+
+	var err error
+	if out.Proto, err = canonicalName(out.Proto, builtinNames.Proto); err != nil {
+		return NexthopObject{}, fmt.Errorf("invalid nexthop.proto: %w", err)
+	}
+
+	switch out.Family {
+	case "", "inet", "inet6":
+	default:
+		return NexthopObject{}, fmt.Errorf("unsupported nexthop.family %q", nh.Family)
+	}
+
+	switch {
+	case len(out.Group) > 0:
+		if out.Gateway != "" || out.Device != "" || out.Onlink || out.Blackhole {
+			return NexthopObject{}, fmt.Errorf("nexthop.gateway/device/onlink/blackhole must be empty when nexthop.group is set")
+		}
+		if out.Family != "" {
+			return NexthopObject{}, fmt.Errorf("nexthop.family must be empty when nexthop.group is set")
+		}
+		group := make([]NexthopGroupEntry, 0, len(out.Group))
+		seen := make(map[uint32]bool, len(out.Group))
+		for i, e := range out.Group {
+			if e.ID == 0 || e.ID == out.ID {
+				return NexthopObject{}, fmt.Errorf("nexthop.group[%d]: invalid id %d", i, e.ID)
+			}
+			if seen[e.ID] {
+				return NexthopObject{}, fmt.Errorf("nexthop.group[%d]: duplicate id %d", i, e.ID)
+			}
+			seen[e.ID] = true
+			if e.Weight == 0 {
+				e.Weight = 1
+			}
+			if e.Weight < 1 || e.Weight > 256 {
+				return NexthopObject{}, fmt.Errorf("nexthop.group[%d]: weight must be in [1, 256]", i)
+			}
+			group = append(group, e)
+		}
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+		out.Group = group
+	case out.Blackhole:
+		if out.Gateway != "" || out.Device != "" || out.Onlink {
+			return NexthopObject{}, fmt.Errorf("nexthop.gateway/device/onlink must be empty for a blackhole nexthop")
+		}
+		out.Group = nil
+		if out.Family == "" {
+			out.Family = "inet"
+		}
+	default:
+		if out.Device == "" {
+			return NexthopObject{}, fmt.Errorf("nexthop requires device, blackhole or group")
+		}
+		out.Group = nil
+		if out.Gateway != "" {
+			ip := net.ParseIP(out.Gateway)
+			if ip == nil {
+				return NexthopObject{}, fmt.Errorf("invalid nexthop.gateway %q", out.Gateway)
+			}
+			out.Gateway = ip.String()
+			f := "inet"
+			if ip.To4() == nil {
+				f = "inet6"
+			}
+			if out.Family != "" && out.Family != f {
+				return NexthopObject{}, fmt.Errorf("nexthop.gateway family mismatch")
+			}
+			out.Family = f
+		}
+		if out.Family == "" {
+			out.Family = "inet"
+		}
+	}
+
+	return out, nil
+}
+
+// identity returns the kernel identity of a nexthop object.
+func (nh NexthopObject) identity() string {
+	return fmt.Sprintf("id=%d", nh.ID)
+}
+
+// Key returns a deterministic full-key identity string for a nexthop object.
+func (nh NexthopObject) Key() (string, error) {
+	n, err := nh.Normalize()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|family=%s|via=%s|dev=%s|onlink=%t|blackhole=%t|group=%s|proto=%s",
+		n.identity(), n.Family, n.Gateway, n.Device, n.Onlink, n.Blackhole, n.groupString(), n.Proto), nil
+}
+
+// groupString returns the group in "ip nexthop" form ("1/2,3": weights after commas).
+func (nh NexthopObject) groupString() string {
+	parts := make([]string, 0, len(nh.Group))
+	for _, e := range nh.Group {
+		if e.Weight > 1 {
+			parts = append(parts, fmt.Sprintf("%d,%d", e.ID, e.Weight))
+		} else {
+			parts = append(parts, fmt.Sprint(e.ID))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// sortNexthopsForAdd orders nexthops so that group members come before the groups
+// using them; sortNexthopsForDelete is the reverse. Within each class, order is by ID.
+func sortNexthopsForAdd(nhs []NexthopObject) {
+	sort.SliceStable(nhs, func(i, j int) bool {
+		gi, gj := len(nhs[i].Group) > 0, len(nhs[j].Group) > 0
+		if gi != gj {
+			return gj
+		}
+		return nhs[i].ID < nhs[j].ID
+	})
+}
+
+func sortNexthopsForDelete(nhs []NexthopObject) {
+	sort.SliceStable(nhs, func(i, j int) bool {
+		gi, gj := len(nhs[i].Group) > 0, len(nhs[j].Group) > 0
+		if gi != gj {
+			return gi
+		}
+		return nhs[i].ID < nhs[j].ID
+	})
+}
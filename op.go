@@ -0,0 +1,169 @@
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// OpKind is the kind of change a RouteOp applies.
+type OpKind string
+
+const (
+	OpDelete  OpKind = "delete"
+	OpAdd     OpKind = "add"
+	OpReplace OpKind = "replace"
+)
+
+// RouteOp is a single change applied to a RouteManager.
+type RouteOp struct {
+	Kind OpKind
+	// Route is the route deleted or added, or the new route of a replace.
+	Route Route
+	// Old is the previous route of a replace.
+	Old Route
+}
+
+func (op RouteOp) String() string {
+	if op.Kind == OpReplace {
+		return fmt.Sprintf("replace route (%+v -> %+v)", op.Old, op.Route)
+	}
+	return fmt.Sprintf("%s route (%+v)", op.Kind, op.Route)
+}
+
+// inverse returns the op that undoes op.
+func (op RouteOp) inverse() RouteOp {
+	switch op.Kind {
+	case OpDelete:
+		return RouteOp{Kind: OpAdd, Route: op.Route}
+	case OpAdd:
+		return RouteOp{Kind: OpDelete, Route: op.Route}
+	default:
+		return RouteOp{Kind: OpReplace, Route: op.Old, Old: op.Route}
+	}
+}
+
+// ApplyStrategy decides how the deletes of a plan are ordered against its adds.
+type ApplyStrategy string
+
+const (
+	// DeleteFirst applies all deletes before any add or replace (the default).
+	DeleteFirst ApplyStrategy = "delete-first"
+	// AddFirst adds and replaces before deleting (make-before-break), except that
+	// a delete sharing the KernelIdentity of an added route, which could not
+	// coexist with it, comes before that add.
+	AddFirst ApplyStrategy = "add-first"
+	// ReplaceWhenPossible is AddFirst, but a delete and an add sharing a kernel
+	// identity are applied as one in-place replace instead when they pair up 1:1.
+	ReplaceWhenPossible ApplyStrategy = "replace-when-possible"
+)
+
+// planOps orders a diff into ops. With DeleteFirst, deletes come first to avoid
+// "file exists" conflicts, then in-place replacements and adds; see ApplyStrategy
+// for the others. Within that, routes using a gateway are deleted before the
+// on-link route reaching it, and added after it. Otherwise the diff order is kept.
+func planOps(diff DiffResult, deps routeDeps) ([]RouteOp, error) {
+	baseline := make([]Route, 0, len(diff.Unchanged)+len(diff.ToReplace)+len(diff.ToDel))
+	baseline = append(baseline, diff.Unchanged...)
+	desired := make([]Route, 0, len(diff.Unchanged)+len(diff.ToReplace)+len(diff.ToAdd))
+	desired = append(desired, diff.Unchanged...)
+	for _, rc := range diff.ToReplace {
+		baseline = append(baseline, rc.Old)
+		desired = append(desired, rc.New)
+	}
+	baseline = append(baseline, diff.ToDel...)
+	desired = append(desired, diff.ToAdd...)
+
+	dels := make([]RouteOp, 0, len(diff.ToDel))
+	for _, r := range diff.ToDel {
+		dels = append(dels, RouteOp{Kind: OpDelete, Route: r})
+	}
+	adds := make([]RouteOp, 0, len(diff.ToReplace)+len(diff.ToAdd))
+	for _, rc := range diff.ToReplace {
+		adds = append(adds, RouteOp{Kind: OpReplace, Route: rc.New, Old: rc.Old})
+	}
+	for _, r := range diff.ToAdd {
+		adds = append(adds, RouteOp{Kind: OpAdd, Route: r})
+	}
+
+	switch deps.strategy {
+	case "", DeleteFirst:
+		dg := newOpGraph(dels)
+		if err := dg.gatewayEdges(0, len(dels), newRouteGraph(baseline, deps), true, false); err != nil {
+			return nil, err
+		}
+		ag := newOpGraph(adds)
+		if err := ag.gatewayEdges(0, len(adds), newRouteGraph(desired, deps), false, deps.checkGateways); err != nil {
+			return nil, err
+		}
+		dels, err := dg.sort()
+		if err != nil {
+			return nil, err
+		}
+		adds, err := ag.sort()
+		if err != nil {
+			return nil, err
+		}
+		return append(dels, adds...), nil
+	case AddFirst:
+	case ReplaceWhenPossible:
+		adds, dels = pairConflicts(adds, dels)
+	default:
+		return nil, fmt.Errorf("unsupported apply strategy %q", deps.strategy)
+	}
+
+	g := newOpGraph(append(adds, dels...))
+	if err := g.gatewayEdges(0, len(adds), newRouteGraph(desired, deps), false, deps.checkGateways); err != nil {
+		return nil, err
+	}
+	if err := g.gatewayEdges(len(adds), len(g.ops), newRouteGraph(baseline, deps), true, false); err != nil {
+		return nil, err
+	}
+	// Only a delete in the way of an add goes first.
+	delsByID := make(map[string][]int, len(dels))
+	for i, op := range dels {
+		s := KernelIdentity(op.Route)
+		delsByID[s] = append(delsByID[s], len(adds)+i)
+	}
+	for i, op := range adds {
+		for _, j := range delsByID[KernelIdentity(op.Route)] {
+			g.edge(j, i)
+		}
+	}
+	return g.sort()
+}
+
+func (c Controller) apply(ctx context.Context, op RouteOp) error {
+	switch op.Kind {
+	case OpDelete:
+		return c.Manager.Delete(ctx, op.Route)
+	case OpAdd:
+		return c.Manager.Add(ctx, op.Route)
+	case OpReplace:
+		return c.replace(ctx, RouteChange{Old: op.Old, New: op.Route})
+	default:
+		return fmt.Errorf("unknown op kind %q", op.Kind)
+	}
+}
+
+// applyOps applies ops in order and returns the ops that took effect.
+//
+// By default it continues past failures and joins their errors. In Transactional
+// mode it stops at the first failure, undoes the applied ops in reverse order and
+// returns a *TransactionError; no op is then reported as applied.
+func (c Controller) applyOps(ctx context.Context, ops []RouteOp) ([]RouteOp, error) {
+	var done []RouteOp
+	var applyErrs []error
+	for _, op := range ops {
+		err := c.apply(ctx, op)
+		if err == nil {
+			done = append(done, op)
+			continue
+		}
+		if c.Transactional {
+			return nil, c.rollback(ctx, op, err, done)
+		}
+		applyErrs = append(applyErrs, fmt.Errorf("%s: %w", op, err))
+	}
+	return done, errors.Join(applyErrs...)
+}
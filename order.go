@@ -0,0 +1,309 @@
+package linuxroute
+
+import (
+	"container/heap"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// routeDeps is what route operations depend on besides other routes.
+type routeDeps struct {
+	// nexthops are the desired nexthop objects: a route using a device-only
+	// object is on-link like a device route.
+	nexthops []NexthopObject
+	// addresses are the desired interface addresses, whose prefixes reach
+	// gateways through the connected routes the kernel adds for them.
+	addresses []Address
+	// checkGateways refuses an added or replaced route whose gateway nothing reaches.
+	checkGateways bool
+	// strategy orders deletes against adds.
+	strategy ApplyStrategy
+}
+
+// onlinkRoute is a route, or the prefix of an address, that makes the
+// gateways it covers reachable.
+type onlinkRoute struct {
+	// key is the route key; it is empty for an address prefix.
+	key    string
+	device string
+	// table is the effective table and vrf the VRF of the route;
+	// address prefixes have neither and match routes of any table.
+	table int
+	vrf   string
+}
+
+// routeGraph resolves the gateways of routes to the on-link routes reaching them,
+// as the kernel does when a route is added: a gateway must be covered by a route
+// without a gateway (of scope link or host for IPv4), looked up in the route's own
+// table first and then in main.
+type routeGraph struct {
+	// onlink indexes on-link routes by prefix length, then masked network.
+	onlink map[int]map[string][]onlinkRoute
+}
+
+// newRouteGraph indexes the on-link routes of a set of normalized routes.
+func newRouteGraph(routes []Route, deps routeDeps) routeGraph {
+	g := routeGraph{onlink: make(map[int]map[string][]onlinkRoute)}
+
+	nexthops := make(map[uint32]NexthopObject, len(deps.nexthops))
+	for _, nh := range deps.nexthops {
+		if n, err := nh.Normalize(); err == nil {
+			nexthops[n.ID] = n
+		}
+	}
+	for _, r := range routes {
+		dev, ok := onlinkDevice(r, nexthops)
+		if !ok {
+			continue
+		}
+		k, _ := r.Key()
+		g.add(routePrefix(r), onlinkRoute{key: k, device: dev, table: effectiveTable(r.Table), vrf: r.VRF})
+	}
+	for _, a := range deps.addresses {
+		n, err := a.Normalize()
+		if err != nil || hasFlag(n.Flags, "noprefixroute") {
+			continue
+		}
+		_, prefix, _ := net.ParseCIDR(n.IP)
+		g.add(prefix, onlinkRoute{device: n.Device})
+	}
+	return g
+}
+
+func (g routeGraph) add(prefix *net.IPNet, o onlinkRoute) {
+	ones, _ := prefix.Mask.Size()
+	byNet := g.onlink[ones]
+	if byNet == nil {
+		byNet = make(map[string][]onlinkRoute)
+		g.onlink[ones] = byNet
+	}
+	byNet[prefix.String()] = append(byNet[prefix.String()], o)
+}
+
+// onlinkDevice returns the device of a normalized route that can reach gateways.
+func onlinkDevice(r Route, nexthops map[uint32]NexthopObject) (string, bool) {
+	if r.Family != "" || r.Type != "" || r.Gateway != "" || len(r.Nexthops) > 0 {
+		return "", false
+	}
+	// IPv4 gateways are only resolved through routes of a narrower scope than
+	// universe; IPv6 ignores the scope.
+	if !strings.Contains(r.Dst, ":") && r.Scope != "253" && r.Scope != "254" {
+		return "", false
+	}
+	if r.NexthopID != 0 {
+		nh, ok := nexthops[r.NexthopID]
+		if !ok || nh.Gateway != "" || nh.Blackhole || len(nh.Group) > 0 {
+			return "", false
+		}
+		return nh.Device, nh.Device != ""
+	}
+	return r.Device, r.Device != ""
+}
+
+// routePrefix returns the destination of a normalized IP route, whose "default" is IPv4.
+func routePrefix(r Route) *net.IPNet {
+	dst := r.Dst
+	if dst == "default" {
+		dst = "0.0.0.0/0"
+	}
+	_, prefix, _ := net.ParseCIDR(dst)
+	return prefix
+}
+
+// gatewayRef is a gateway a route needs to reach, with the device it is pinned to.
+type gatewayRef struct {
+	gateway string
+	device  string
+}
+
+// gateways returns the gateways a normalized route needs to reach through
+// another route; onlink gateways and IPv6 link-local gateways need none.
+func gateways(r Route) []gatewayRef {
+	var refs []gatewayRef
+	needs := func(gw string) bool {
+		ip := net.ParseIP(gw)
+		return ip != nil && !(ip.To4() == nil && ip.IsLinkLocalUnicast())
+	}
+	if !r.Onlink && needs(r.Gateway) {
+		refs = append(refs, gatewayRef{gateway: r.Gateway, device: r.Device})
+	}
+	for _, nh := range r.Nexthops {
+		if !nh.Onlink && needs(nh.Gateway) {
+			refs = append(refs, gatewayRef{gateway: nh.Gateway, device: nh.Device})
+		}
+	}
+	return refs
+}
+
+// reach returns the on-link route the kernel would resolve gw of r through:
+// the longest prefix covering it, from r's own table before main, and routes
+// before address prefixes.
+func (g routeGraph) reach(r Route, gw gatewayRef) (onlinkRoute, bool) {
+	table, vrf := effectiveTable(r.Table), r.VRF
+	if r.Family == familyMPLS {
+		// The via address of an MPLS route is resolved in the main table.
+		table, vrf = tableMain, ""
+	}
+	ip := net.ParseIP(gw.gateway)
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	} else {
+		ip = ip.To4()
+	}
+
+	for ones := bits; ones >= 0; ones-- {
+		byNet := g.onlink[ones]
+		if byNet == nil {
+			continue
+		}
+		prefix := net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}
+		var best onlinkRoute
+		rank := 0
+		for _, o := range byNet[prefix.String()] {
+			if gw.device != "" && gw.device != o.device {
+				continue
+			}
+			switch {
+			case o.key != "" && o.table == table && o.vrf == vrf:
+				best, rank = o, 3
+			case o.key != "" && o.table == tableMain && o.vrf == "" && vrf == "" && rank < 2:
+				best, rank = o, 2
+			case o.key == "" && rank < 1:
+				best, rank = o, 1
+			}
+		}
+		if rank > 0 {
+			return best, true
+		}
+	}
+	return onlinkRoute{}, false
+}
+
+// opGraph holds ordering constraints between ops.
+type opGraph struct {
+	ops []RouteOp
+	// after[i] lists the ops that must wait for op i; waits[i] counts the ops op i waits for.
+	after [][]int
+	waits []int
+}
+
+func newOpGraph(ops []RouteOp) *opGraph {
+	return &opGraph{ops: ops, after: make([][]int, len(ops)), waits: make([]int, len(ops))}
+}
+
+// edge makes op then wait for op first.
+func (g *opGraph) edge(first, then int) {
+	g.after[first] = append(g.after[first], then)
+	g.waits[then]++
+}
+
+// gatewayEdges makes the ops in [lo, hi) wait for the on-link routes they use as a
+// gateway, or, if reverse is set, makes an on-link route wait for the ops using it.
+// With check set, a gateway nothing in rg reaches is an error.
+func (g *opGraph) gatewayEdges(lo, hi int, rg routeGraph, reverse, check bool) error {
+	index := make(map[string]int, hi-lo)
+	for i := lo; i < hi; i++ {
+		k, _ := g.ops[i].Route.Key()
+		index[k] = i
+	}
+	for i := lo; i < hi; i++ {
+		op := g.ops[i]
+		for _, gw := range gateways(op.Route) {
+			o, ok := rg.reach(op.Route, gw)
+			if !ok {
+				if check {
+					return fmt.Errorf("%s: gateway %s is not reachable through an on-link route", op, gw.gateway)
+				}
+				continue
+			}
+			j, ok := index[o.key]
+			if !ok || o.key == "" {
+				continue
+			}
+			if reverse {
+				g.edge(i, j)
+			} else {
+				g.edge(j, i)
+			}
+		}
+	}
+	return nil
+}
+
+// sort orders the ops topologically, taking the lowest ready index to keep
+// the original order otherwise.
+func (g *opGraph) sort() ([]RouteOp, error) {
+	waits := append([]int(nil), g.waits...)
+	ready := &intHeap{}
+	for i := range g.ops {
+		if waits[i] == 0 {
+			heap.Push(ready, i)
+		}
+	}
+	out := make([]RouteOp, 0, len(g.ops))
+	for ready.Len() > 0 {
+		i := heap.Pop(ready).(int)
+		out = append(out, g.ops[i])
+		for _, j := range g.after[i] {
+			if waits[j]--; waits[j] == 0 {
+				heap.Push(ready, j)
+			}
+		}
+	}
+	if len(out) != len(g.ops) {
+		for i, n := range waits {
+			if n > 0 {
+				return nil, fmt.Errorf("%s: route dependency cycle", g.ops[i])
+			}
+		}
+	}
+	return out, nil
+}
+
+// pairConflicts turns an add and a delete sharing a kernel identity into an
+// in-place replace, when no other add or delete shares it.
+func pairConflicts(adds, dels []RouteOp) ([]RouteOp, []RouteOp) {
+	addCount := make(map[string]int, len(adds))
+	for _, op := range adds {
+		addCount[KernelIdentity(op.Route)]++
+	}
+	delsByID := make(map[string][]int, len(dels))
+	for i, op := range dels {
+		s := KernelIdentity(op.Route)
+		delsByID[s] = append(delsByID[s], i)
+	}
+
+	paired := make([]bool, len(dels))
+	out := make([]RouteOp, 0, len(adds))
+	for _, op := range adds {
+		s := KernelIdentity(op.Route)
+		if op.Kind == OpAdd && addCount[s] == 1 && len(delsByID[s]) == 1 {
+			j := delsByID[s][0]
+			paired[j] = true
+			op = RouteOp{Kind: OpReplace, Route: op.Route, Old: dels[j].Route}
+		}
+		out = append(out, op)
+	}
+	kept := make([]RouteOp, 0, len(dels))
+	for j, op := range dels {
+		if !paired[j] {
+			kept = append(kept, op)
+		}
+	}
+	return out, kept
+}
+
+type intHeap []int
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x any)        { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
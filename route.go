@@ -1,18 +1,31 @@
 package linuxroute
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 )
 
 // Route describes a Linux route entry in a mostly "ip route" compatible form.
 //
 // Notes:
-// - Dst is required. Use "default" or a CIDR (e.g. "10.0.0.0/24", "2001:db8::/64").
-// - Gateway/Src are optional IPs.
-// - Device (dev) is optional but recommended for unambiguous routes.
-// - Table/Metric are optional; 0 means "unspecified/default".
+//   - Dst is required. Use "default" or a CIDR (e.g. "10.0.0.0/24", "2001:db8::/64").
+//     "default" is IPv4 unless the gateway, src or a nexthop is IPv6; Normalize
+//     stores the IPv4 default as "default" and the IPv6 one as "::/0".
+//   - Gateway/Src are optional IPs.
+//   - Device (dev) is optional but recommended for unambiguous routes.
+//   - Table/Metric are optional; 0 means "unspecified/default". JSON also accepts a table name.
+//   - VRF places the route in the table of a VRF device instead of Table.
+//   - Proto/Scope are numbers or reserved names ("static", "link"); Normalize stores
+//     numbers. Other iproute2 names are resolved when routes are parsed or decoded
+//     from JSON, or with NameResolver.ResolveRoute.
+//   - Nexthops describes a multipath (ECMP) route; it is mutually exclusive with Gateway/Device.
+//   - Family "mpls" makes Dst an incoming MPLS label instead of a prefix.
+//   - TOS selects IPv4 packets by their TOS (DS field) byte ("ip route add ... tos 0x10").
+//     Source-specific IPv6 routes ("from") are not supported: the netlink library can
+//     neither add nor list them.
 type Route struct {
 	Dst     string `json:"dst"`
 	Gateway string `json:"gateway,omitempty"`
@@ -20,11 +33,52 @@ type Route struct {
 	Table   int    `json:"table,omitempty"`
 	Metric  int    `json:"metric,omitempty"`
 	Src     string `json:"src,omitempty"`
+	TOS     int    `json:"tos,omitempty"`
 
 	// Extra fields for forward compatibility / completeness.
 	Scope string `json:"scope,omitempty"`
 	Type  string `json:"type,omitempty"`
 	Proto string `json:"proto,omitempty"`
+
+	// Nexthops holds the paths of a multipath route
+	// ("ip route add ... nexthop via A dev eth0 weight 1 nexthop via B dev eth1 weight 2").
+	Nexthops []Nexthop `json:"nexthops,omitempty"`
+
+	// Onlink makes the kernel treat Gateway as directly reachable on Device
+	// ("ip route add ... via 10.1.0.1 dev eth0 onlink").
+	Onlink bool `json:"onlink,omitempty"`
+
+	// Encap is the lightweight tunnel encapsulation of a single-path route
+	// ("ip route add 10.0.0.0/24 encap mpls 100 via 10.1.0.1 dev eth0").
+	Encap *Encap `json:"encap,omitempty"`
+
+	// Metrics holds per-route TCP/path attributes (mtu, advmss, initcwnd, ...).
+	Metrics *RouteMetrics `json:"metrics,omitempty"`
+
+	// Family is empty for IPv4/IPv6 routes, or "mpls" for a label-switching entry
+	// whose Dst is the incoming label, NewDst the outgoing label stack and Gateway
+	// the next hop of either IP family ("ip -f mpls route add 100 as 200 via inet 10.0.0.2 dev eth0").
+	Family string `json:"family,omitempty"`
+	NewDst []int  `json:"newdst,omitempty"`
+
+	// NexthopID references a nexthop object ("ip route add ... nhid 10") instead of
+	// Gateway/Device/Nexthops/Encap; see NexthopObject.
+	NexthopID uint32 `json:"nhid,omitempty"`
+
+	// VRF names a VRF device ("ip route add ... vrf vrf-blue"); managers resolve
+	// it to the device's table, so it is mutually exclusive with Table.
+	VRF string `json:"vrf,omitempty"`
+}
+
+// Nexthop is a single path of a multipath route.
+//
+// Weight is optional; 0 means the kernel default (1).
+type Nexthop struct {
+	Gateway string `json:"gateway,omitempty"`
+	Device  string `json:"device,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+	Onlink  bool   `json:"onlink,omitempty"`
+	Encap   *Encap `json:"encap,omitempty"`
 }
 
 // Normalize canonicalizes fields so diffing is stable.
@@ -39,17 +93,31 @@ func (r Route) Normalize() (Route, error) {
 	out.Scope = strings.TrimSpace(out.Scope)
 	out.Type = strings.TrimSpace(out.Type)
 	out.Proto = strings.TrimSpace(out.Proto)
+	out.Family = strings.ToLower(strings.TrimSpace(out.Family))
+	out.VRF = strings.TrimSpace(out.VRF)
 
 	out.Dst = strings.ToLower(out.Dst)
 	if out.Dst == "" {
 		return Route{}, fmt.Errorf("route.dst is required")
 	}
-	if out.Dst != "default" {
+	switch out.Family {
+	case "":
+		if len(out.NewDst) > 0 {
+			return Route{}, fmt.Errorf("route.newdst requires family mpls")
+		}
+	case familyMPLS:
+	default:
+		return Route{}, fmt.Errorf("unsupported route.family %q", r.Family)
+	}
+	if out.Dst != "default" && out.Family == "" {
 		_, ipNet, err := net.ParseCIDR(out.Dst)
 		if err != nil {
 			return Route{}, fmt.Errorf("invalid route.dst %q: %w", out.Dst, err)
 		}
 		out.Dst = ipNet.String()
+		if out.Dst == "0.0.0.0/0" {
+			out.Dst = "default"
+		}
 	}
 
 	if out.Gateway != "" {
@@ -68,9 +136,19 @@ func (r Route) Normalize() (Route, error) {
 		out.Src = ip.String()
 	}
 
-	out.Scope = strings.ToLower(out.Scope)
 	out.Type = strings.ToLower(out.Type)
-	out.Proto = strings.ToLower(out.Proto)
+	if out.Type == "unicast" {
+		// The kernel default; List reports it as "".
+		out.Type = ""
+	}
+
+	var err error
+	if out.Proto, err = canonicalName(out.Proto, builtinNames.Proto); err != nil {
+		return Route{}, fmt.Errorf("invalid route.proto: %w", err)
+	}
+	if out.Scope, err = canonicalName(out.Scope, builtinNames.Scope); err != nil {
+		return Route{}, fmt.Errorf("invalid route.scope: %w", err)
+	}
 
 	if out.Table < 0 {
 		return Route{}, fmt.Errorf("route.table must be >= 0")
@@ -78,16 +156,206 @@ func (r Route) Normalize() (Route, error) {
 	if out.Metric < 0 {
 		return Route{}, fmt.Errorf("route.metric must be >= 0")
 	}
+	if out.VRF != "" && out.Table != 0 {
+		return Route{}, fmt.Errorf("route.table must be empty when route.vrf is set")
+	}
+	if out.TOS < 0 || out.TOS > 255 {
+		return Route{}, fmt.Errorf("route.tos must be in [0, 255]")
+	}
+
+	if out.Encap != nil {
+		e, err := out.Encap.Normalize()
+		if err != nil {
+			return Route{}, fmt.Errorf("route.encap: %w", err)
+		}
+		out.Encap = &e
+	}
+
+	if len(out.Nexthops) > 0 {
+		if out.Gateway != "" || out.Device != "" || out.Encap != nil || out.Onlink {
+			return Route{}, fmt.Errorf("route.gateway/device/encap/onlink must be empty when route.nexthops is set")
+		}
+		nhs := make([]Nexthop, 0, len(out.Nexthops))
+		for i, nh := range out.Nexthops {
+			n, err := nh.Normalize()
+			if err != nil {
+				return Route{}, fmt.Errorf("route.nexthops[%d]: %w", i, err)
+			}
+			nhs = append(nhs, n)
+		}
+		sort.Slice(nhs, func(i, j int) bool {
+			return nhs[i].key() < nhs[j].key()
+		})
+		out.Nexthops = nhs
+
+		// The kernel stores a single nexthop as a regular route, without its
+		// weight and with onlink in the route flags, so collapse it to keep
+		// diffing against List() stable.
+		if len(nhs) == 1 {
+			out.Gateway = nhs[0].Gateway
+			out.Device = nhs[0].Device
+			out.Encap = nhs[0].Encap
+			out.Onlink = nhs[0].Onlink
+			out.Nexthops = nil
+		}
+	} else {
+		out.Nexthops = nil
+	}
+
+	if out.Onlink && out.Gateway == "" {
+		return Route{}, fmt.Errorf("route.onlink requires route.gateway")
+	}
+
+	if out.Metrics != nil {
+		m, err := out.Metrics.Normalize()
+		if err != nil {
+			return Route{}, err
+		}
+		if m.IsZero() {
+			out.Metrics = nil
+		} else {
+			out.Metrics = &m
+		}
+	}
+
+	if out.NexthopID != 0 {
+		if out.Gateway != "" || out.Device != "" || out.Encap != nil || len(out.Nexthops) > 0 {
+			return Route{}, fmt.Errorf("route.gateway/device/encap/nexthops must be empty when route.nhid is set")
+		}
+		if out.Family != "" {
+			return Route{}, fmt.Errorf("route.nhid is not supported for %s routes", out.Family)
+		}
+	}
+
+	if out.Family == familyMPLS {
+		if err := out.normalizeMPLS(); err != nil {
+			return Route{}, err
+		}
+	}
+	if routeFamily(out) == "inet6" {
+		if out.Dst == "default" {
+			out.Dst = "::/0"
+		}
+		if out.TOS != 0 {
+			return Route{}, fmt.Errorf("route.tos is not supported for IPv6 routes")
+		}
+	}
+
+	return out, nil
+}
+
+// UnmarshalJSON accepts "table", "proto" and "scope" as numbers or iproute2
+// names, resolved with DefaultNameResolver.
+func (r *Route) UnmarshalJSON(b []byte) error {
+	type plain Route
+	aux := struct {
+		*plain
+		Table json.RawMessage `json:"table,omitempty"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	t, err := unmarshalTable(aux.Table)
+	if err != nil {
+		return fmt.Errorf("route.table: %w", err)
+	}
+	r.Table = t
+	resolved, err := DefaultNameResolver().ResolveRoute(*r)
+	if err != nil {
+		return err
+	}
+	*r = resolved
+	return nil
+}
+
+// unmarshalTable decodes a JSON table number or name.
+func unmarshalTable(raw json.RawMessage) (int, error) {
+	s, err := jsonNameOrNumber(raw)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	return DefaultNameResolver().Table(s)
+}
+
+// routeFamily returns the family of a normalized route: "inet", "inet6" or "mpls".
+func routeFamily(r Route) string {
+	if r.Family != "" {
+		return r.Family
+	}
+	if strings.Contains(r.Dst, ":") || strings.Contains(r.Gateway, ":") || strings.Contains(r.Src, ":") {
+		return "inet6"
+	}
+	for _, nh := range r.Nexthops {
+		if strings.Contains(nh.Gateway, ":") {
+			return "inet6"
+		}
+	}
+	return "inet"
+}
+
+// Normalize canonicalizes a nexthop. It returns a copy of nh.
+func (nh Nexthop) Normalize() (Nexthop, error) {
+	out := nh
+
+	out.Gateway = strings.TrimSpace(out.Gateway)
+	out.Device = strings.TrimSpace(out.Device)
+
+	if out.Gateway == "" && out.Device == "" {
+		return Nexthop{}, fmt.Errorf("nexthop requires gateway or device")
+	}
+	if out.Gateway != "" {
+		ip := net.ParseIP(out.Gateway)
+		if ip == nil {
+			return Nexthop{}, fmt.Errorf("invalid nexthop.gateway %q", out.Gateway)
+		}
+		out.Gateway = ip.String()
+	}
+
+	if out.Weight == 0 {
+		out.Weight = 1
+	}
+	if out.Weight < 1 || out.Weight > 256 {
+		return Nexthop{}, fmt.Errorf("nexthop.weight must be in [1, 256]")
+	}
+
+	if out.Encap != nil {
+		e, err := out.Encap.Normalize()
+		if err != nil {
+			return Nexthop{}, err
+		}
+		// Netlink only reports mpls encaps per nexthop and List decodes ip/ip6
+		// ones itself, so other types would never match what List returns.
+		if e.Type != "mpls" && e.Type != "ip" && e.Type != "ip6" {
+			return Nexthop{}, fmt.Errorf("nexthop encap %s is not supported", e.Type)
+		}
+		out.Encap = &e
+	}
 
 	return out, nil
 }
 
+func (nh Nexthop) key() string {
+	enc := ""
+	if nh.Encap != nil {
+		enc = nh.Encap.String()
+	}
+	return fmt.Sprintf("via=%s,dev=%s,weight=%d,onlink=%t,encap=%s",
+		nh.Gateway, nh.Device, nh.Weight, nh.Onlink, enc)
+}
+
 // Key returns a deterministic identity string for a route.
 // Two routes with the same key are considered the same entry for diffing purposes.
 //
 // This is intentionally a "full key" (set semantics):
 // it supports multiple routes to the same destination (e.g. different gateways/metrics)
 // by treating them as distinct entries.
+//
+// Encapsulated routes get an extra "|encap=" suffix, multipath routes a
+// "|nexthops=" suffix in canonical nexthop order, routes with metrics a
+// "|metrics=" suffix, MPLS routes a "|family=mpls|as=" suffix, routes using
+// a nexthop object a "|nhid=" suffix, VRF routes a "|vrf=" suffix and routes
+// with a TOS a "|tos=" suffix and onlink routes an "|onlink" suffix; other
+// keys are unchanged.
 func (r Route) Key() (string, error) {
 	n, err := r.Normalize()
 	if err != nil {
@@ -95,8 +363,37 @@ func (r Route) Key() (string, error) {
 	}
 
 	// Keep format stable and explicit.
-	return fmt.Sprintf(
+	k := fmt.Sprintf(
 		"dst=%s|gw=%s|dev=%s|table=%d|metric=%d|src=%s|scope=%s|type=%s|proto=%s",
 		n.Dst, n.Gateway, n.Device, n.Table, n.Metric, n.Src, n.Scope, n.Type, n.Proto,
-	), nil
+	)
+	if n.Encap != nil {
+		k += "|encap=" + n.Encap.String()
+	}
+	if len(n.Nexthops) > 0 {
+		hops := make([]string, 0, len(n.Nexthops))
+		for _, nh := range n.Nexthops {
+			hops = append(hops, "["+nh.key()+"]")
+		}
+		k += "|nexthops=" + strings.Join(hops, "")
+	}
+	if n.Metrics != nil {
+		k += "|metrics=" + n.Metrics.String()
+	}
+	if n.Family != "" {
+		k += "|family=" + n.Family + "|as=" + formatMPLSLabels(n.NewDst)
+	}
+	if n.NexthopID != 0 {
+		k += fmt.Sprintf("|nhid=%d", n.NexthopID)
+	}
+	if n.VRF != "" {
+		k += "|vrf=" + n.VRF
+	}
+	if n.TOS != 0 {
+		k += fmt.Sprintf("|tos=%d", n.TOS)
+	}
+	if n.Onlink {
+		k += "|onlink"
+	}
+	return k, nil
 }
@@ -0,0 +1,268 @@
+package linuxroute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Rule describes a policy routing rule in a mostly "ip rule" compatible form.
+//
+// Notes:
+//   - Priority (pref) is required, so rules have a stable identity across runs.
+//   - From/To are optional prefixes; a bare IP means a host prefix. Family is derived
+//     from them and defaults to "inet" when neither is set.
+//   - Action defaults to "lookup" when Table is set. In JSON, Table may also be a
+//     name from rt_tables.
+//   - FwMask defaults to 0xffffffff when FwMark is set, as the kernel does.
+//   - SPort/DPort/UIDRange accept "N" or "N-M".
+type Rule struct {
+	Priority int    `json:"priority"`
+	Family   string `json:"family,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	FwMark   uint32 `json:"fwmark,omitempty"`
+	FwMask   uint32 `json:"fwmask,omitempty"`
+	IIF      string `json:"iif,omitempty"`
+	OIF      string `json:"oif,omitempty"`
+	Table    int    `json:"table,omitempty"`
+	Action   string `json:"action,omitempty"`
+	Goto     int    `json:"goto,omitempty"`
+	UIDRange string `json:"uidrange,omitempty"`
+	IPProto  string `json:"ipproto,omitempty"`
+	SPort    string `json:"sport,omitempty"`
+	DPort    string `json:"dport,omitempty"`
+	Invert   bool   `json:"not,omitempty"`
+}
+
+// UnmarshalJSON accepts "table" as a number or an iproute2 table name.
+func (r *Rule) UnmarshalJSON(b []byte) error {
+	type plain Rule
+	aux := struct {
+		*plain
+		Table json.RawMessage `json:"table,omitempty"`
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	t, err := unmarshalTable(aux.Table)
+	if err != nil {
+		return fmt.Errorf("rule.table: %w", err)
+	}
+	r.Table = t
+	return nil
+}
+
+// Normalize canonicalizes fields so diffing is stable.
+// It returns a copy of r.
+func (r Rule) Normalize() (Rule, error) {
+	out := r
+
+	out.Family = strings.ToLower(strings.TrimSpace(out.Family))
+	out.From = strings.TrimSpace(out.From)
+	out.To = strings.TrimSpace(out.To)
+	out.IIF = strings.TrimSpace(out.IIF)
+	out.OIF = strings.TrimSpace(out.OIF)
+	out.Action = strings.ToLower(strings.TrimSpace(out.Action))
+	out.IPProto = strings.ToLower(strings.TrimSpace(out.IPProto))
+
+	if out.Priority <= 0 {
+		return Rule{}, fmt.Errorf("rule.priority must be > 0")
+	}
+	if out.Table < 0 {
+		return Rule{}, fmt.Errorf("rule.table must be >= 0")
+	}
+
+	var err error
+	var fromFamily, toFamily string
+	if out.From, fromFamily, err = normalizeRulePrefix(out.From); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule.from: %w", err)
+	}
+	if out.To, toFamily, err = normalizeRulePrefix(out.To); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule.to: %w", err)
+	}
+	switch out.Family {
+	case "", "inet", "inet6":
+	case "ipv4":
+		out.Family = "inet"
+	case "ipv6":
+		out.Family = "inet6"
+	default:
+		return Rule{}, fmt.Errorf("unsupported rule.family %q", r.Family)
+	}
+	for _, f := range []string{fromFamily, toFamily} {
+		if f == "" {
+			continue
+		}
+		if out.Family != "" && out.Family != f {
+			return Rule{}, fmt.Errorf("rule.from/to family mismatch")
+		}
+		out.Family = f
+	}
+	if out.Family == "" {
+		out.Family = "inet"
+	}
+
+	if out.FwMark != 0 && out.FwMask == 0 {
+		out.FwMask = 0xffffffff
+	}
+
+	if out.Action == "" && out.Table != 0 {
+		out.Action = "lookup"
+	}
+	switch out.Action {
+	case "lookup":
+		if out.Table == 0 {
+			return Rule{}, fmt.Errorf("rule action lookup requires rule.table")
+		}
+	case "goto":
+		if out.Goto <= 0 {
+			return Rule{}, fmt.Errorf("rule action goto requires rule.goto")
+		}
+	case "nop", "blackhole", "unreachable", "prohibit":
+	case "":
+		return Rule{}, fmt.Errorf("rule requires table or action")
+	default:
+		return Rule{}, fmt.Errorf("unsupported rule.action %q", r.Action)
+	}
+	if out.Action != "goto" && out.Goto != 0 {
+		return Rule{}, fmt.Errorf("rule.goto requires action goto")
+	}
+
+	if out.UIDRange, err = normalizeRange(out.UIDRange, 0xffffffff); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule.uidrange: %w", err)
+	}
+	if out.SPort, err = normalizeRange(out.SPort, 0xffff); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule.sport: %w", err)
+	}
+	if out.DPort, err = normalizeRange(out.DPort, 0xffff); err != nil {
+		return Rule{}, fmt.Errorf("invalid rule.dport: %w", err)
+	}
+
+	if out.IPProto != "" {
+		p, ok := parseIPProto(out.IPProto)
+		if !ok {
+			return Rule{}, fmt.Errorf("unsupported rule.ipproto %q", r.IPProto)
+		}
+		out.IPProto = ipProtoString(p)
+	}
+
+	return out, nil
+}
+
+// Key returns a deterministic identity string for a rule (full-key semantics, like Route.Key).
+func (r Rule) Key() (string, error) {
+	n, err := r.Normalize()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"pref=%d|family=%s|not=%t|from=%s|to=%s|fwmark=%#x/%#x|iif=%s|oif=%s|uidrange=%s|ipproto=%s|sport=%s|dport=%s|action=%s|table=%d|goto=%d",
+		n.Priority, n.Family, n.Invert, n.From, n.To, n.FwMark, n.FwMask, n.IIF, n.OIF,
+		n.UIDRange, n.IPProto, n.SPort, n.DPort, n.Action, n.Table, n.Goto,
+	), nil
+}
+
+// normalizeRulePrefix canonicalizes a rule selector prefix and returns its family.
+// "all" and "" mean no selector.
+func normalizeRulePrefix(s string) (string, string, error) {
+	s = strings.ToLower(s)
+	if s == "" || s == "all" {
+		return "", "", nil
+	}
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", "", fmt.Errorf("%q is not an IP or CIDR", s)
+		}
+		if ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return "", "", err
+	}
+	if ipNet.IP.To4() != nil {
+		return ipNet.String(), "inet", nil
+	}
+	return ipNet.String(), "inet6", nil
+}
+
+// normalizeRange canonicalizes "N" / "N-M" ranges; a single value is written as "N".
+func normalizeRange(s string, max uint64) (string, error) {
+	start, end, ok, err := parseRange(s, max)
+	if err != nil || !ok {
+		return "", err
+	}
+	if start == end {
+		return strconv.FormatUint(start, 10), nil
+	}
+	return fmt.Sprintf("%d-%d", start, end), nil
+}
+
+// parseRange parses "N" / "N-M"; ok is false for an empty string.
+func parseRange(s string, max uint64) (start, end uint64, ok bool, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, false, nil
+	}
+	lo, hi, found := strings.Cut(s, "-")
+	start, err = strconv.ParseUint(strings.TrimSpace(lo), 10, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("%q: %w", s, err)
+	}
+	end = start
+	if found {
+		end, err = strconv.ParseUint(strings.TrimSpace(hi), 10, 64)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("%q: %w", s, err)
+		}
+	}
+	if start > end || end > max {
+		return 0, 0, false, fmt.Errorf("%q is out of range", s)
+	}
+	return start, end, true, nil
+}
+
+func parseIPProto(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 0 && n <= 255
+	}
+	switch s {
+	case "icmp":
+		return 1, true
+	case "tcp":
+		return 6, true
+	case "udp":
+		return 17, true
+	case "ipv6-icmp", "icmpv6":
+		return 58, true
+	case "sctp":
+		return 132, true
+	default:
+		return 0, false
+	}
+}
+
+func ipProtoString(p int) string {
+	switch p {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "ipv6-icmp"
+	case 132:
+		return "sctp"
+	default:
+		return strconv.Itoa(p)
+	}
+}
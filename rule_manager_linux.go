@@ -0,0 +1,199 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// IPRuleManager implements RuleManager using netlink.
+type IPRuleManager struct {
+	// Handle, when set, is used instead of the global netlink handle;
+	// share IPRouteManager.Handle to manage rules in the same network namespace.
+	Handle *netlink.Handle
+}
+
+func (m IPRuleManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPRuleManager) List(ctx context.Context) ([]Rule, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	nlRules, err := m.handle().RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(nlRules))
+	for _, nr := range nlRules {
+		r, err := fromNetlinkRule(nr)
+		if err != nil {
+			// e.g. the kernel's priority 0 "lookup local" rule.
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (m IPRuleManager) Add(ctx context.Context, r Rule) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	nlr, err := toNetlinkRule(r)
+	if err != nil {
+		return err
+	}
+	err = m.handle().RuleAdd(nlr)
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+func (m IPRuleManager) Delete(ctx context.Context, r Rule) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	nlr, err := toNetlinkRule(r)
+	if err != nil {
+		return err
+	}
+	err = m.handle().RuleDel(nlr)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+func toNetlinkRule(r Rule) (*netlink.Rule, error) {
+	n, err := r.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	nr := netlink.NewRule()
+	nr.Priority = n.Priority
+	nr.Family = unix.AF_INET
+	if n.Family == "inet6" {
+		nr.Family = unix.AF_INET6
+	}
+	nr.Invert = n.Invert
+	if n.From != "" {
+		_, nr.Src, _ = net.ParseCIDR(n.From)
+	}
+	if n.To != "" {
+		_, nr.Dst, _ = net.ParseCIDR(n.To)
+	}
+	if n.FwMark != 0 || n.FwMask != 0 {
+		nr.Mark = n.FwMark
+		mask := n.FwMask
+		nr.Mask = &mask
+	}
+	nr.IifName = n.IIF
+	nr.OifName = n.OIF
+
+	switch n.Action {
+	case "lookup":
+		nr.Table = n.Table
+	case "goto":
+		nr.Goto = n.Goto
+	case "nop":
+		nr.Type = nl.FR_ACT_NOP
+	case "blackhole":
+		nr.Type = nl.FR_ACT_BLACKHOLE
+	case "unreachable":
+		nr.Type = nl.FR_ACT_UNREACHABLE
+	case "prohibit":
+		nr.Type = nl.FR_ACT_PROHIBIT
+	}
+
+	if start, end, ok, _ := parseRange(n.UIDRange, 0xffffffff); ok {
+		nr.UIDRange = netlink.NewRuleUIDRange(uint32(start), uint32(end))
+	}
+	if start, end, ok, _ := parseRange(n.SPort, 0xffff); ok {
+		nr.Sport = netlink.NewRulePortRange(uint16(start), uint16(end))
+	}
+	if start, end, ok, _ := parseRange(n.DPort, 0xffff); ok {
+		nr.Dport = netlink.NewRulePortRange(uint16(start), uint16(end))
+	}
+	if n.IPProto != "" {
+		p, _ := parseIPProto(n.IPProto)
+		nr.IPProto = p
+	}
+
+	return nr, nil
+}
+
+// fromNetlinkRule converts a dumped rule.
+//
+// The netlink library does not report the rule action on dump, so rules that
+// neither look up a table nor jump (blackhole/unreachable/prohibit/nop) are skipped.
+func fromNetlinkRule(nr netlink.Rule) (Rule, error) {
+	r := Rule{
+		Priority: nr.Priority,
+		FwMark:   nr.Mark,
+		IIF:      nr.IifName,
+		OIF:      nr.OifName,
+		Invert:   nr.Invert,
+	}
+	if nr.Family == unix.AF_INET6 {
+		r.Family = "inet6"
+	}
+	if nr.Src != nil {
+		r.From = nr.Src.String()
+	}
+	if nr.Dst != nil {
+		r.To = nr.Dst.String()
+	}
+	if nr.Mask != nil {
+		r.FwMask = *nr.Mask
+	}
+
+	switch {
+	case nr.Goto > 0:
+		r.Action = "goto"
+		r.Goto = nr.Goto
+	case nr.Table > 0:
+		r.Table = nr.Table
+	default:
+		return Rule{}, fmt.Errorf("rule %d: unsupported action", nr.Priority)
+	}
+
+	if nr.UIDRange != nil {
+		r.UIDRange = fmt.Sprintf("%d-%d", nr.UIDRange.Start, nr.UIDRange.End)
+	}
+	if nr.Sport != nil {
+		r.SPort = fmt.Sprintf("%d-%d", nr.Sport.Start, nr.Sport.End)
+	}
+	if nr.Dport != nil {
+		r.DPort = fmt.Sprintf("%d-%d", nr.Dport.Start, nr.Dport.End)
+	}
+	if nr.IPProto > 0 {
+		r.IPProto = ipProtoString(nr.IPProto)
+	}
+
+	return r.Normalize()
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPRuleManager is not supported on non-Linux platforms.
+type IPRuleManager struct {
+	Handle *netlink.Handle
+}
+
+func (m IPRuleManager) List(ctx context.Context) ([]Rule, error) {
+	return nil, fmt.Errorf("IPRuleManager is supported only on linux")
+}
+
+func (m IPRuleManager) Add(ctx context.Context, r Rule) error {
+	return fmt.Errorf("IPRuleManager is supported only on linux")
+}
+
+func (m IPRuleManager) Delete(ctx context.Context, r Rule) error {
+	return fmt.Errorf("IPRuleManager is supported only on linux")
+}
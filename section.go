@@ -0,0 +1,248 @@
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SectionDiff is the plan computed from the old to the desired objects of a
+// snapshot section keyed by kernel identity: nexthop objects, addresses,
+// neighbor entries and devices.
+type SectionDiff[T any] struct {
+	ToAdd     []T         `json:"to_add,omitempty"`
+	ToDel     []T         `json:"to_del,omitempty"`
+	ToReplace []Change[T] `json:"to_replace,omitempty"`
+	Unchanged []T         `json:"unchanged,omitempty"`
+}
+
+// Change pairs an existing object with the desired one replacing it.
+type Change[T any] struct {
+	Old T `json:"old"`
+	New T `json:"new"`
+}
+
+// sectionObject is an object of a snapshot section.
+type sectionObject[T any] interface {
+	Normalize() (T, error)
+	// Key returns the full key; objects with the same identity but another
+	// key are replaced.
+	Key() (string, error)
+	// identity returns the kernel identity of the normalized object.
+	identity() string
+}
+
+// diffSection diffs old against desired by identity, in identity order;
+// oldWhat and newWhat name the slices in errors ("desiredLinks").
+func diffSection[T sectionObject[T]](old, desired []T, oldWhat, newWhat string) (SectionDiff[T], error) {
+	oldMap, err := byIdentity(old, oldWhat)
+	if err != nil {
+		return SectionDiff[T]{}, err
+	}
+	newMap, err := byIdentity(desired, newWhat)
+	if err != nil {
+		return SectionDiff[T]{}, err
+	}
+
+	var res SectionDiff[T]
+	for id, o := range oldMap {
+		n, ok := newMap[id]
+		if !ok {
+			res.ToDel = append(res.ToDel, o)
+			continue
+		}
+		oldKey, _ := o.Key()
+		newKey, _ := n.Key()
+		if oldKey == newKey {
+			res.Unchanged = append(res.Unchanged, o)
+		} else {
+			res.ToReplace = append(res.ToReplace, Change[T]{Old: o, New: n})
+		}
+	}
+	for id, n := range newMap {
+		if _, ok := oldMap[id]; !ok {
+			res.ToAdd = append(res.ToAdd, n)
+		}
+	}
+
+	byID := func(s []T) { sort.Slice(s, func(i, j int) bool { return s[i].identity() < s[j].identity() }) }
+	byID(res.ToAdd)
+	byID(res.ToDel)
+	byID(res.Unchanged)
+	sort.Slice(res.ToReplace, func(i, j int) bool {
+		return res.ToReplace[i].New.identity() < res.ToReplace[j].New.identity()
+	})
+	return res, nil
+}
+
+func byIdentity[T sectionObject[T]](objs []T, what string) (map[string]T, error) {
+	m := make(map[string]T, len(objs))
+	for i, o := range objs {
+		n, err := o.Normalize()
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", what, i, err)
+		}
+		id := n.identity()
+		if prev, ok := m[id]; ok {
+			pk, _ := prev.Key()
+			nk, _ := n.Key()
+			if pk != nk {
+				return nil, fmt.Errorf("%s[%d]: duplicate %s", what, i, id)
+			}
+		}
+		m[id] = n
+	}
+	return m, nil
+}
+
+// objectManager is the part of a section manager (e.g. NexthopManager) a
+// snapshot reconcile uses; Add creates or updates in place.
+type objectManager[T any] interface {
+	Add(ctx context.Context, v T) error
+	Delete(ctx context.Context, v T) error
+}
+
+// section describes a snapshot section for planSection.
+type section[T sectionObject[T]] struct {
+	// noun and nouns name the objects in errors ("address", "addresses").
+	noun, nouns string
+	// load and save access the section baseline; nil if the Store does not support it.
+	load func() ([]T, error)
+	save func([]T) error
+	// compare diffs the baseline against the desired objects.
+	compare func(old, desired []T) (SectionDiff[T], error)
+	// addOrder, when set, orders the objects to add and replace together.
+	addOrder func([]T)
+	// order orders the saved baseline.
+	order   func([]T)
+	manager objectManager[T]
+}
+
+// sectionPlan is a section of a snapshot reconcile.
+type sectionPlan[T sectionObject[T]] struct {
+	section[T]
+	diff   SectionDiff[T]
+	manage bool
+	// applied tracks the objects on the system by identity, for the new baseline.
+	applied map[string]T
+}
+
+// planSection diffs desired against the baseline of s.
+func planSection[T sectionObject[T]](s section[T], desired []T) (*sectionPlan[T], error) {
+	p := &sectionPlan[T]{section: s}
+	var old []T
+	if s.load != nil {
+		var err error
+		old, err = s.load()
+		if err != nil {
+			return nil, fmt.Errorf("load old %s: %w", s.nouns, err)
+		}
+	}
+	diff, err := s.compare(old, desired)
+	if err != nil {
+		return nil, err
+	}
+	p.diff = diff
+	p.manage = len(diff.ToAdd) > 0 || len(diff.ToDel) > 0 || len(diff.ToReplace) > 0
+	if p.manage && s.load == nil {
+		return nil, fmt.Errorf("store does not support %s", s.nouns)
+	}
+	if p.manage && s.manager == nil {
+		return nil, fmt.Errorf("%s manager is nil", s.noun)
+	}
+
+	p.applied = make(map[string]T, len(diff.Unchanged)+len(diff.ToDel)+len(diff.ToReplace))
+	for _, o := range diff.Unchanged {
+		p.applied[o.identity()] = o
+	}
+	for _, o := range diff.ToDel {
+		p.applied[o.identity()] = o
+	}
+	for _, c := range diff.ToReplace {
+		p.applied[c.Old.identity()] = c.Old
+	}
+	return p, nil
+}
+
+// add creates new objects, then replaces changed ones.
+func (p *sectionPlan[T]) add(ctx context.Context) []error {
+	if !p.manage {
+		return nil
+	}
+	add := append([]T(nil), p.diff.ToAdd...)
+	for _, c := range p.diff.ToReplace {
+		add = append(add, c.New)
+	}
+	if p.addOrder != nil {
+		p.addOrder(add)
+	}
+	var errs []error
+	for _, o := range add {
+		if err := p.manager.Add(ctx, o); err != nil {
+			errs = append(errs, fmt.Errorf("add %s (%+v): %w", p.noun, o, err))
+			continue
+		}
+		p.applied[o.identity()] = o
+	}
+	return errs
+}
+
+// delete removes objects that are no longer desired.
+func (p *sectionPlan[T]) delete(ctx context.Context) []error {
+	if !p.manage {
+		return nil
+	}
+	var errs []error
+	for _, o := range p.diff.ToDel {
+		if err := p.manager.Delete(ctx, o); err != nil {
+			errs = append(errs, fmt.Errorf("delete %s (%+v): %w", p.noun, o, err))
+			continue
+		}
+		delete(p.applied, o.identity())
+	}
+	return errs
+}
+
+// saveApplied stores the objects that are on the system as the new baseline.
+func (p *sectionPlan[T]) saveApplied() error {
+	if !p.manage {
+		return nil
+	}
+	objs := make([]T, 0, len(p.applied))
+	for _, o := range p.applied {
+		objs = append(objs, o)
+	}
+	p.order(objs)
+	if err := p.save(objs); err != nil {
+		return fmt.Errorf("save applied %s: %w", p.nouns, err)
+	}
+	return nil
+}
+
+// normalizeAll normalizes objs before they are persisted; what names them in errors.
+func normalizeAll[T interface{ Normalize() (T, error) }](objs []T, what string) ([]T, error) {
+	out := make([]T, 0, len(objs))
+	for i, o := range objs {
+		n, err := o.Normalize()
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", what, i, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// loadLocked returns a copy of *from under mu.
+func loadLocked[T any](mu *sync.Mutex, from *[]T) []T {
+	mu.Lock()
+	defer mu.Unlock()
+	return append(make([]T, 0, len(*from)), *from...)
+}
+
+// saveLocked replaces *to with a copy of objs under mu.
+func saveLocked[T any](mu *sync.Mutex, to *[]T, objs []T) {
+	mu.Lock()
+	defer mu.Unlock()
+	*to = append([]T(nil), objs...)
+}
@@ -0,0 +1,431 @@
+// Package simroute provides an in-memory linuxroute.RouteManager that follows
+// the Linux FIB rules and error codes, to test code driving a linuxroute.Controller
+// deterministically and without root.
+package simroute
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	linuxroute "github.com/jursonmo/linux_route"
+)
+
+const (
+	tableMain = 254
+
+	protoKernel = "2"
+	protoBoot   = "3"
+
+	scopeUniverse = "0"
+	scopeLink     = "253"
+
+	// ipv6Metric is the metric the kernel gives IPv6 routes without one.
+	ipv6Metric = 1024
+	// ipv6PrefixMetric is the metric of the prefix route of an IPv6 address.
+	ipv6PrefixMetric = 256
+)
+
+// SimulatedManager is a linuxroute.RouteManager and linuxroute.RouteReplacer
+// holding the routing table of a simulated network namespace, with the
+// semantics of "ip route add/del/replace":
+//   - Add fails with EEXIST when a route with the same kernel identity (see
+//     linuxroute.KernelIdentity) exists, and Delete with ESRCH when none matches.
+//     IPRouteManager is more forgiving: it adds with replace semantics and
+//     ignores missing routes on delete.
+//   - Routes are reported the way the kernel fills them in: table 0 becomes main
+//     (254), IPv6 metric 0 becomes 1024, proto defaults to boot and scope to universe.
+//   - Devices must exist (ENODEV otherwise); a gateway must be reachable through a
+//     route without a gateway in the route's table or main, of scope link or host
+//     for IPv4 (ENETUNREACH, or EHOSTUNREACH for IPv6, otherwise).
+//   - Delete matches the fields set on the route, as the kernel does, so an empty
+//     field (or metric 0) matches any value.
+//
+// Nexthop objects are not simulated: routes using one fail with EINVAL.
+// Errors are syscall.Errno values, like the ones netlink returns.
+// A SimulatedManager is safe for concurrent use.
+type SimulatedManager struct {
+	mu      sync.Mutex
+	devices map[string]bool
+	vrfs    map[string]int
+	// routes holds the installed routes by key.
+	routes map[string]linuxroute.Route
+}
+
+// New returns a SimulatedManager with an empty routing table and the given
+// devices, plus lo.
+func New(devices ...string) *SimulatedManager {
+	m := &SimulatedManager{
+		devices: map[string]bool{"lo": true},
+		vrfs:    make(map[string]int),
+		routes:  make(map[string]linuxroute.Route),
+	}
+	for _, d := range devices {
+		m.devices[d] = true
+	}
+	return m
+}
+
+// AddDevice adds a network device.
+func (m *SimulatedManager) AddDevice(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices[name] = true
+}
+
+// AddVRF adds a VRF device bound to table.
+func (m *SimulatedManager) AddVRF(name string, table int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices[name] = true
+	m.vrfs[name] = table
+}
+
+// DeleteDevice removes a device and, as the kernel does, every route using it
+// (and, for a VRF, every route in its table).
+func (m *SimulatedManager) DeleteDevice(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.devices, name)
+	delete(m.vrfs, name)
+	for k, r := range m.routes {
+		if r.Device == name || r.VRF == name || usesDevice(r.Nexthops, name) {
+			delete(m.routes, k)
+		}
+	}
+}
+
+func usesDevice(nhs []linuxroute.Nexthop, name string) bool {
+	for _, nh := range nhs {
+		if nh.Device == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAddress adds the prefix route the kernel creates for an interface address
+// ("10.0.0.5/24" on eth0 adds "10.0.0.0/24 dev eth0 proto kernel scope link src 10.0.0.5"),
+// which makes gateways in the prefix reachable. Host prefixes add none.
+func (m *SimulatedManager) AddAddress(device, address string) error {
+	ip, prefix, err := net.ParseCIDR(address)
+	if err != nil {
+		return syscall.EINVAL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.devices[device] {
+		return syscall.ENODEV
+	}
+	ones, bits := prefix.Mask.Size()
+	if ones == bits {
+		return nil
+	}
+
+	r := linuxroute.Route{Dst: prefix.String(), Device: device, Table: tableMain, Proto: protoKernel, Scope: scopeLink}
+	if ip.To4() == nil {
+		r.Metric, r.Scope = ipv6PrefixMetric, scopeUniverse
+	} else {
+		r.Src = ip.String()
+	}
+	if m.conflict(r) == "" {
+		k, _ := r.Key()
+		m.routes[k] = r
+	}
+	return nil
+}
+
+// List returns the installed routes, sorted by key.
+func (m *SimulatedManager) List(ctx context.Context) ([]linuxroute.Route, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.routes))
+	for k := range m.routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	routes := make([]linuxroute.Route, 0, len(keys))
+	for _, k := range keys {
+		r, _ := m.routes[k].Normalize() // a copy
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// Add installs r like "ip route add".
+func (m *SimulatedManager) Add(ctx context.Context, r linuxroute.Route) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.fill(r)
+	if err != nil {
+		return err
+	}
+	if m.conflict(n) != "" {
+		return syscall.EEXIST
+	}
+	k, _ := n.Key()
+	m.routes[k] = n
+	return nil
+}
+
+// Replace installs new like "ip route replace", replacing the route with the
+// same kernel identity if any, then deletes old if it has another identity
+// (a missing old is not an error, as with IPRouteManager).
+func (m *SimulatedManager) Replace(ctx context.Context, old, new linuxroute.Route) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.fill(new)
+	if err != nil {
+		return err
+	}
+	if k := m.conflict(n); k != "" {
+		delete(m.routes, k)
+	}
+	k, _ := n.Key()
+	m.routes[k] = n
+
+	o, err := old.Normalize()
+	if err != nil {
+		return err
+	}
+	if linuxroute.KernelIdentity(o) == linuxroute.KernelIdentity(n) {
+		return nil
+	}
+	if err := m.delete(o); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// Delete removes the first route, by key, matching r like "ip route del".
+func (m *SimulatedManager) Delete(ctx context.Context, r linuxroute.Route) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := r.Normalize()
+	if err != nil {
+		return syscall.EINVAL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.delete(n)
+}
+
+func (m *SimulatedManager) delete(spec linuxroute.Route) error {
+	keys := make([]string, 0, len(m.routes))
+	for k := range m.routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if matches(spec, m.routes[k]) {
+			delete(m.routes, k)
+			return nil
+		}
+	}
+	return syscall.ESRCH
+}
+
+// matches reports whether the normalized delete request spec selects the installed route r.
+func matches(spec, r linuxroute.Route) bool {
+	// The kernel matches the TOS exactly, even when not given.
+	if spec.Family != r.Family || spec.Dst != r.Dst || spec.VRF != r.VRF || spec.TOS != r.TOS {
+		return false
+	}
+	if spec.VRF == "" && table(spec.Table) != table(r.Table) {
+		return false
+	}
+	if spec.Metric != 0 && spec.Metric != r.Metric {
+		return false
+	}
+	for _, f := range [][2]string{
+		{spec.Gateway, r.Gateway}, {spec.Device, r.Device}, {spec.Src, r.Src},
+		{spec.Proto, r.Proto}, {spec.Scope, r.Scope}, {spec.Type, r.Type},
+	} {
+		if f[0] != "" && f[0] != f[1] {
+			return false
+		}
+	}
+	if len(spec.Nexthops) > 0 {
+		sk, _ := linuxroute.Route{Dst: "default", Nexthops: spec.Nexthops}.Key()
+		rk, _ := linuxroute.Route{Dst: "default", Nexthops: r.Nexthops}.Key()
+		if sk != rk {
+			return false
+		}
+	}
+	return true
+}
+
+// conflict returns the key of the installed route with the kernel identity of
+// n, or "" if there is none.
+func (m *SimulatedManager) conflict(n linuxroute.Route) string {
+	id := linuxroute.KernelIdentity(n)
+	for k, r := range m.routes {
+		if linuxroute.KernelIdentity(r) == id {
+			return k
+		}
+	}
+	return ""
+}
+
+// fill validates r against the simulated namespace and returns it as the kernel
+// would install it.
+func (m *SimulatedManager) fill(r linuxroute.Route) (linuxroute.Route, error) {
+	n, err := r.Normalize()
+	if err != nil {
+		return linuxroute.Route{}, syscall.EINVAL
+	}
+	if n.NexthopID != 0 {
+		return linuxroute.Route{}, syscall.EINVAL
+	}
+
+	if n.VRF != "" {
+		if _, ok := m.vrfs[n.VRF]; !ok {
+			return linuxroute.Route{}, syscall.ENODEV
+		}
+	} else if n.Family == "" {
+		n.Table = table(n.Table)
+	}
+	if n.Family == "" && n.Metric == 0 && isIPv6(n) {
+		n.Metric = ipv6Metric
+	}
+	if n.Proto == "" {
+		n.Proto = protoBoot
+	}
+	if n.Scope == "" {
+		n.Scope = scopeUniverse
+	}
+
+	if n.Device != "" && !m.devices[n.Device] {
+		return linuxroute.Route{}, syscall.ENODEV
+	}
+	for _, nh := range n.Nexthops {
+		if nh.Device != "" && !m.devices[nh.Device] {
+			return linuxroute.Route{}, syscall.ENODEV
+		}
+	}
+	if n.Type == "" && n.Gateway == "" && n.Device == "" && len(n.Nexthops) == 0 {
+		// A unicast route needs a nexthop.
+		return linuxroute.Route{}, syscall.EINVAL
+	}
+
+	if n.Gateway != "" && !n.Onlink {
+		if err := m.reach(n, n.Gateway, n.Device); err != nil {
+			return linuxroute.Route{}, err
+		}
+	}
+	for _, nh := range n.Nexthops {
+		if nh.Gateway != "" && !nh.Onlink {
+			if err := m.reach(n, nh.Gateway, nh.Device); err != nil {
+				return linuxroute.Route{}, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// reach checks that gw of the route r, pinned to dev if set, is reachable:
+// IPv6 link-local gateways through dev, others through a route without a
+// gateway covering them, in r's table (or VRF), then main.
+func (m *SimulatedManager) reach(r linuxroute.Route, gw, dev string) error {
+	ip := net.ParseIP(gw)
+	v6 := ip.To4() == nil
+	if v6 && ip.IsLinkLocalUnicast() {
+		if dev == "" {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+
+	type slot struct {
+		table int
+		vrf   string
+	}
+	slots := []slot{{r.Table, r.VRF}, {tableMain, ""}}
+	switch {
+	case r.Family != "":
+		// The via address of an MPLS route is resolved in main.
+		slots = slots[1:]
+	case r.VRF != "":
+		slots = slots[:1]
+	}
+	for _, s := range slots {
+		for _, c := range m.routes {
+			if c.Table != s.table || c.VRF != s.vrf || (dev != "" && c.Device != dev) {
+				continue
+			}
+			if onlink(c, v6) && prefix(c).Contains(ip) {
+				return nil
+			}
+		}
+	}
+	if v6 {
+		return syscall.EHOSTUNREACH
+	}
+	return syscall.ENETUNREACH
+}
+
+// onlink reports whether the installed route c can resolve gateways of the
+// given family: IPv4 ignores routes of universe scope.
+func onlink(c linuxroute.Route, v6 bool) bool {
+	if c.Family != "" || c.Type != "" || c.Gateway != "" || len(c.Nexthops) > 0 || c.Device == "" || isIPv6(c) != v6 {
+		return false
+	}
+	return v6 || c.Scope != scopeUniverse
+}
+
+// prefix returns the destination of a normalized IP route, whose "default" is IPv4.
+func prefix(r linuxroute.Route) *net.IPNet {
+	dst := r.Dst
+	if dst == "default" {
+		dst = "0.0.0.0/0"
+	}
+	_, p, _ := net.ParseCIDR(dst)
+	return p
+}
+
+func isIPv6(r linuxroute.Route) bool {
+	if r.Family != "" {
+		return false
+	}
+	if strings.Contains(r.Dst, ":") || strings.Contains(r.Gateway, ":") || strings.Contains(r.Src, ":") {
+		return true
+	}
+	for _, nh := range r.Nexthops {
+		if strings.Contains(nh.Gateway, ":") {
+			return true
+		}
+	}
+	return false
+}
+
+func table(t int) int {
+	if t == 0 {
+		return tableMain
+	}
+	return t
+}
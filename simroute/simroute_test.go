@@ -0,0 +1,181 @@
+package simroute
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+
+	linuxroute "github.com/jursonmo/linux_route"
+)
+
+func TestSimulatedManager(t *testing.T) {
+	ctx := context.Background()
+
+	type op struct {
+		kind string // add, del or replace
+		old  linuxroute.Route
+		r    linuxroute.Route
+		err  error
+	}
+	cases := []struct {
+		name string
+		ops  []op
+		// want lists the key prefixes of the routes left besides the eth0 prefix route.
+		want []string
+	}{
+		{
+			name: "table defaults to main and proto to boot",
+			ops:  []op{{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1"}}},
+			want: []string{"dst=10.1.0.0/24|gw=10.0.0.1|dev=|table=254|metric=0|src=|scope=0|type=|proto=3"},
+		},
+		{
+			name: "duplicate add",
+			ops: []op{
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1"}},
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.2", Table: 254}, err: syscall.EEXIST},
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.2", Metric: 10}},
+			},
+			want: []string{"dst=10.1.0.0/24|gw=10.0.0.1|", "dst=10.1.0.0/24|gw=10.0.0.2|"},
+		},
+		{
+			name: "missing delete",
+			ops: []op{
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1", Metric: 10}},
+				{kind: "del", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.2"}, err: syscall.ESRCH},
+				{kind: "del", r: linuxroute.Route{Dst: "10.1.0.0/24", Table: 100}, err: syscall.ESRCH},
+				{kind: "del", r: linuxroute.Route{Dst: "10.1.0.0/24"}},
+				{kind: "del", r: linuxroute.Route{Dst: "10.1.0.0/24"}, err: syscall.ESRCH},
+			},
+		},
+		{
+			name: "replace",
+			ops: []op{
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1"}},
+				{kind: "add", r: linuxroute.Route{Dst: "10.2.0.0/24", Gateway: "10.0.0.1"}},
+				// Same identity: replaced in place.
+				{kind: "replace", old: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1"}, r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.2"}},
+				// Another identity: added, and the old route deleted.
+				{kind: "replace", old: linuxroute.Route{Dst: "10.2.0.0/24", Gateway: "10.0.0.1"}, r: linuxroute.Route{Dst: "10.2.0.0/24", Gateway: "10.0.0.1", Metric: 5}},
+			},
+			want: []string{"dst=10.1.0.0/24|gw=10.0.0.2|", "dst=10.2.0.0/24|gw=10.0.0.1|dev=|table=254|metric=5|"},
+		},
+		{
+			name: "gateway reachability",
+			ops: []op{
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.9.0.1"}, err: syscall.ENETUNREACH},
+				{kind: "add", r: linuxroute.Route{Dst: "10.9.0.0/24", Device: "eth1"}},
+				// A device route of universe scope does not reach IPv4 gateways.
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.9.0.1"}, err: syscall.ENETUNREACH},
+				{kind: "add", r: linuxroute.Route{Dst: "10.8.0.0/24", Device: "eth1", Scope: "link"}},
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.8.0.1", Device: "eth0"}, err: syscall.ENETUNREACH},
+				{kind: "add", r: linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.8.0.1", Table: 100}},
+				{kind: "add", r: linuxroute.Route{Dst: "10.2.0.0/24", Nexthops: []linuxroute.Nexthop{{Gateway: "10.7.0.1", Device: "eth1", Onlink: true}}}},
+				{kind: "add", r: linuxroute.Route{Dst: "2001:db8::/32", Gateway: "2001:db8:1::1"}, err: syscall.EHOSTUNREACH},
+				{kind: "add", r: linuxroute.Route{Dst: "2001:db8::/32", Gateway: "fe80::1"}, err: syscall.EINVAL},
+				{kind: "add", r: linuxroute.Route{Dst: "2001:db8::/32", Gateway: "fe80::1", Device: "eth1"}},
+				{kind: "add", r: linuxroute.Route{Dst: "10.3.0.0/24", Device: "eth9"}, err: syscall.ENODEV},
+			},
+			want: []string{
+				"dst=10.1.0.0/24|gw=10.8.0.1|dev=|table=100|", "dst=10.2.0.0/24|", "dst=10.8.0.0/24|",
+				"dst=10.9.0.0/24|", "dst=2001:db8::/32|gw=fe80::1|dev=eth1|table=254|metric=1024|",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		m := New("eth0", "eth1")
+		if err := m.AddAddress("eth0", "10.0.0.5/24"); err != nil {
+			t.Fatalf("%s: AddAddress() error: %v", tc.name, err)
+		}
+		for i, o := range tc.ops {
+			var err error
+			switch o.kind {
+			case "add":
+				err = m.Add(ctx, o.r)
+			case "del":
+				err = m.Delete(ctx, o.r)
+			case "replace":
+				err = m.Replace(ctx, o.old, o.r)
+			}
+			if !errors.Is(err, o.err) {
+				t.Fatalf("%s: op[%d] %s %+v error = %v, want %v", tc.name, i, o.kind, o.r, err, o.err)
+			}
+		}
+
+		routes, _ := m.List(ctx)
+		var got []string
+		for _, r := range routes {
+			if k, _ := r.Key(); !strings.HasPrefix(k, "dst=10.0.0.0/24|") {
+				got = append(got, k)
+			}
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: routes=%v, want %v", tc.name, got, tc.want)
+		}
+		for i, w := range tc.want {
+			if !strings.HasPrefix(got[i], w) {
+				t.Fatalf("%s: route[%d]=%q, want prefix %q", tc.name, i, got[i], w)
+			}
+		}
+	}
+
+	// Removing a device flushes its routes and the routes of its VRF.
+	m := New("eth0")
+	m.AddVRF("blue", 10)
+	m.AddAddress("eth0", "10.0.0.5/24")
+	if err := m.Add(ctx, linuxroute.Route{Dst: "10.1.0.0/24", Gateway: "10.0.0.1"}); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if err := m.Add(ctx, linuxroute.Route{Dst: "10.2.0.0/24", Device: "eth0", VRF: "blue"}); err != nil {
+		t.Fatalf("Add() VRF route error: %v", err)
+	}
+	m.DeleteDevice("blue")
+	if routes, _ := m.List(ctx); len(routes) != 2 {
+		t.Fatalf("after deleting the VRF routes=%+v", routes)
+	}
+	m.DeleteDevice("eth0")
+	if routes, _ := m.List(ctx); len(routes) != 1 || routes[0].Dst != "10.1.0.0/24" {
+		t.Fatalf("after deleting eth0 routes=%+v", routes)
+	}
+}
+
+func TestSimulatedManager_Controller(t *testing.T) {
+	ctx := context.Background()
+
+	m := New("eth0", "eth1")
+	c := linuxroute.Controller{Manager: m, Store: &linuxroute.MemoryStore{}}
+	first := []linuxroute.Route{
+		{Dst: "10.8.0.0/24", Device: "eth0", Scope: "link"},
+		{Dst: "10.1.0.0/24", Gateway: "10.8.0.1"},
+	}
+	if _, err := c.Reconcile(ctx, first); err != nil {
+		t.Fatalf("Reconcile() error: %v", err)
+	}
+
+	// The route via 10.9.0.1 can only be added after the on-link route to
+	// 10.9.0.0/24, and the 10.1.0.0/24 slot is taken until its old route goes.
+	second := []linuxroute.Route{
+		{Dst: "10.1.0.0/24", Gateway: "10.9.0.1"},
+		{Dst: "10.9.0.0/24", Device: "eth1", Scope: "link"},
+	}
+	for _, s := range []linuxroute.ApplyStrategy{linuxroute.DeleteFirst, linuxroute.AddFirst, linuxroute.ReplaceWhenPossible} {
+		c.ApplyStrategy = s
+		if _, err := c.Reconcile(ctx, second); err != nil {
+			t.Fatalf("%q: Reconcile() error: %v", s, err)
+		}
+		live, _ := m.List(ctx)
+		if len(live) != len(second) || live[0].Gateway != "10.9.0.1" || live[1].Device != "eth1" {
+			t.Fatalf("%q: live routes %+v, want %+v", s, live, second)
+		}
+		if _, err := c.Reconcile(ctx, first); err != nil {
+			t.Fatalf("%q: Reconcile() back error: %v", s, err)
+		}
+	}
+
+	// The controller reports the kernel error of a route it cannot add.
+	if _, err := c.Reconcile(ctx, []linuxroute.Route{{Dst: "10.1.0.0/24", Gateway: "10.7.0.1"}}); !errors.Is(err, syscall.ENETUNREACH) {
+		t.Fatalf("Reconcile() error = %v, want ENETUNREACH", err)
+	}
+}
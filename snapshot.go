@@ -0,0 +1,261 @@
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ReconcileSnapshot reconciles routes, network devices, interface addresses, neighbor entries,
+// nexthop objects and policy rules together from one full snapshot:
+// - adds and updates devices (parents and masters first), so everything else can use them
+// - adds and replaces addresses, so route sources and on-link gateways are usable
+// - adds and replaces neighbor entries, so gateways resolve as soon as routes use them
+// - deletes rules that should no longer exist (so traffic leaves a table before it is emptied)
+// - adds and replaces nexthop objects (group members before groups), so routes can reference them
+// - reconciles routes exactly like Reconcile (safety guards are checked before anything is applied)
+// - deletes nexthop objects that should no longer exist (groups before members), once no route uses them
+// - deletes neighbor entries, addresses and then devices that should no longer exist
+// - adds rules that are missing (once the tables they point to are populated)
+//
+// Each section's baseline is saved separately and records what actually succeeded,
+// so a failure in one section never loses track of another. Transactional only
+// covers route operations; other failures are skipped and reported. If the routes
+// were rolled back, the deleted rules are added back instead of the new ones, and
+// old nexthop objects, neighbors, addresses and devices are not deleted.
+func (c Controller) ReconcileSnapshot(ctx context.Context, snap Snapshot) (ReconcileResult, error) {
+	if c.Manager == nil {
+		return ReconcileResult{}, fmt.Errorf("manager is nil")
+	}
+	if c.Store == nil {
+		return ReconcileResult{}, fmt.Errorf("store is nil")
+	}
+
+	p, err := c.planSnapshot(ctx, snap)
+	if err != nil {
+		return p.result(), err
+	}
+	if err := c.ensureVRFs(ctx, p.diff); err != nil {
+		return p.result(), err
+	}
+	applied := make(map[string]Rule, len(p.rules.Unchanged)+len(p.rules.ToDel)+len(p.rules.ToAdd))
+	for _, r := range p.rules.Unchanged {
+		k, _ := r.Key()
+		applied[k] = r
+	}
+	for _, r := range p.rules.ToDel {
+		k, _ := r.Key()
+		applied[k] = r
+	}
+
+	applyErrs := p.links.add(ctx)
+	applyErrs = append(applyErrs, p.addresses.add(ctx)...)
+	applyErrs = append(applyErrs, p.neighbors.add(ctx)...)
+	var deletedRules []Rule
+	for _, r := range p.rules.ToDel {
+		if err := c.RuleManager.Delete(ctx, r); err != nil {
+			applyErrs = append(applyErrs, fmt.Errorf("delete rule (%+v): %w", r, err))
+			continue
+		}
+		k, _ := r.Key()
+		delete(applied, k)
+		deletedRules = append(deletedRules, r)
+	}
+
+	applyErrs = append(applyErrs, p.nexthops.add(ctx)...)
+
+	res := p.result()
+	err = c.applyDiff(ctx, p.diff, p.ops)
+	if err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+
+	// Routes were rolled back: new rules would point at tables that are not populated,
+	// and old routes may still use the nexthop objects, neighbors, addresses and devices to delete.
+	// The old rules deleted above are restored along with the old routes.
+	var txErr *TransactionError
+	addRules := p.rules.ToAdd
+	if errors.As(err, &txErr) {
+		addRules = deletedRules
+	} else {
+		applyErrs = append(applyErrs, p.nexthops.delete(ctx)...)
+		applyErrs = append(applyErrs, p.neighbors.delete(ctx)...)
+		applyErrs = append(applyErrs, p.addresses.delete(ctx)...)
+		applyErrs = append(applyErrs, p.links.delete(ctx)...)
+	}
+	if err := p.nexthops.saveApplied(); err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+	if err := p.addresses.saveApplied(); err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+	if err := p.neighbors.saveApplied(); err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+	if err := p.links.saveApplied(); err != nil {
+		applyErrs = append(applyErrs, err)
+	}
+	for _, r := range addRules {
+		if err := c.RuleManager.Add(ctx, r); err != nil {
+			applyErrs = append(applyErrs, fmt.Errorf("add rule (%+v): %w", r, err))
+			continue
+		}
+		k, _ := r.Key()
+		applied[k] = r
+	}
+
+	if p.manageRules {
+		appliedRules := make([]Rule, 0, len(applied))
+		for _, r := range applied {
+			appliedRules = append(appliedRules, r)
+		}
+		sortRules(appliedRules)
+		if err := p.ruleStore.SaveRules(appliedRules); err != nil {
+			applyErrs = append(applyErrs, fmt.Errorf("save applied rules: %w", err))
+		}
+	}
+
+	return res, errors.Join(applyErrs...)
+}
+
+// PlanSnapshot computes what ReconcileSnapshot would do with snap, checking the
+// safety guards, gateways and every section, without changing the system.
+func (c Controller) PlanSnapshot(ctx context.Context, snap Snapshot) (ReconcileResult, error) {
+	if c.Manager == nil {
+		return ReconcileResult{}, fmt.Errorf("manager is nil")
+	}
+	if c.Store == nil {
+		return ReconcileResult{}, fmt.Errorf("store is nil")
+	}
+	p, err := c.planSnapshot(ctx, snap)
+	return p.result(), err
+}
+
+// snapshotPlan is the plan of every section of a snapshot reconcile.
+type snapshotPlan struct {
+	diff DiffResult
+	ops  []RouteOp
+
+	ruleStore   RuleStore
+	rules       RuleDiffResult
+	manageRules bool
+
+	nexthops  *sectionPlan[NexthopObject]
+	addresses *sectionPlan[Address]
+	neighbors *sectionPlan[Neighbor]
+	links     *sectionPlan[Link]
+}
+
+// planSnapshot plans every section of snap; on error, the plan holds the
+// sections planned so far.
+func (c Controller) planSnapshot(ctx context.Context, snap Snapshot) (*snapshotPlan, error) {
+	p := &snapshotPlan{}
+	snap, err := c.names().ResolveSnapshot(snap)
+	if err != nil {
+		return p, err
+	}
+	p.diff, p.ops, err = c.plan(ctx, snap.Routes, routeDeps{nexthops: snap.Nexthops, addresses: snap.Addresses})
+	if err != nil {
+		return p, err
+	}
+
+	p.ruleStore, _ = c.Store.(RuleStore)
+	var oldRules []Rule
+	if p.ruleStore != nil {
+		oldRules, err = p.ruleStore.LoadRules()
+		if err != nil {
+			return p, fmt.Errorf("load old rules: %w", err)
+		}
+	}
+	if p.rules, err = DiffRules(oldRules, snap.Rules); err != nil {
+		return p, err
+	}
+	p.manageRules = len(p.rules.ToAdd) > 0 || len(p.rules.ToDel) > 0
+	if p.manageRules && p.ruleStore == nil {
+		return p, fmt.Errorf("store does not support rules")
+	}
+	if p.manageRules && c.RuleManager == nil {
+		return p, fmt.Errorf("rule manager is nil")
+	}
+
+	if p.nexthops, err = c.planNexthops(snap.Nexthops); err != nil {
+		return p, err
+	}
+	if p.addresses, err = c.planAddresses(snap.Addresses); err != nil {
+		return p, err
+	}
+	if p.neighbors, err = c.planNeighbors(snap.Neighbors); err != nil {
+		return p, err
+	}
+	if p.links, err = c.planLinks(snap.Links); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// result reports the plan as a ReconcileResult.
+func (p *snapshotPlan) result() ReconcileResult {
+	return ReconcileResult{Diff: p.diff, Rules: p.rules, Nexthops: planned(p.nexthops),
+		Addresses: planned(p.addresses), Neighbors: planned(p.neighbors), Links: planned(p.links)}
+}
+
+// planned returns the diff of a section plan, empty if it was not planned.
+func planned[T sectionObject[T]](p *sectionPlan[T]) SectionDiff[T] {
+	if p == nil {
+		return SectionDiff[T]{}
+	}
+	return p.diff
+}
+
+// planNexthops diffs desired against the nexthop object baseline.
+func (c Controller) planNexthops(desired []NexthopObject) (*sectionPlan[NexthopObject], error) {
+	s := section[NexthopObject]{noun: "nexthop", nouns: "nexthops", compare: DiffNexthops,
+		order: sortNexthopsForAdd, manager: c.NexthopManager}
+	if st, ok := c.Store.(NexthopStore); ok {
+		s.load, s.save = st.LoadNexthops, st.SaveNexthops
+	}
+	p, err := planSection(s, desired)
+	if err != nil {
+		return nil, err
+	}
+	// The kernel refuses to replace a nexthop with a group or a group with a
+	// nexthop, and deleting the object first would drop the routes using it.
+	for _, nc := range p.diff.ToReplace {
+		if (len(nc.Old.Group) > 0) != (len(nc.New.Group) > 0) {
+			return nil, fmt.Errorf("nexthop %d cannot change between a single nexthop and a group in place; use a new id", nc.New.ID)
+		}
+	}
+	return p, nil
+}
+
+// planAddresses diffs desired against the address baseline.
+func (c Controller) planAddresses(desired []Address) (*sectionPlan[Address], error) {
+	s := section[Address]{noun: "address", nouns: "addresses", compare: DiffAddresses,
+		order: sortAddresses, manager: c.AddressManager}
+	if st, ok := c.Store.(AddressStore); ok {
+		s.load, s.save = st.LoadAddresses, st.SaveAddresses
+	}
+	return planSection(s, desired)
+}
+
+// planNeighbors diffs desired against the neighbor baseline.
+func (c Controller) planNeighbors(desired []Neighbor) (*sectionPlan[Neighbor], error) {
+	s := section[Neighbor]{noun: "neighbor", nouns: "neighbors", compare: DiffNeighbors,
+		order: sortNeighbors, manager: c.NeighborManager}
+	if st, ok := c.Store.(NeighborStore); ok {
+		s.load, s.save = st.LoadNeighbors, st.SaveNeighbors
+	}
+	return planSection(s, desired)
+}
+
+// planLinks diffs desired against the device baseline; devices are added and
+// updated parents and masters first.
+func (c Controller) planLinks(desired []Link) (*sectionPlan[Link], error) {
+	byDeps := func(links []Link) { sortLinksForAdd(links) } // checked by DiffLinks
+	s := section[Link]{noun: "link", nouns: "links", compare: DiffLinks,
+		addOrder: byDeps, order: byDeps, manager: c.LinkManager}
+	if st, ok := c.Store.(LinkStore); ok {
+		s.load, s.save = st.LoadLinks, st.SaveLinks
+	}
+	return planSection(s, desired)
+}
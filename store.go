@@ -1,6 +1,7 @@
 package linuxroute
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,48 +16,214 @@ type RouteStore interface {
 	Save(routes []Route) error
 }
 
+// RuleStore persists the "last applied" full rule set.
+// It is optionally implemented by a RouteStore; Controller.ReconcileSnapshot requires it
+// when rules are managed.
+type RuleStore interface {
+	LoadRules() ([]Rule, error)
+	SaveRules(rules []Rule) error
+}
+
+// NexthopStore persists the "last applied" full nexthop object set.
+// It is optionally implemented by a RouteStore; Controller.ReconcileSnapshot requires it
+// when nexthop objects are managed.
+type NexthopStore interface {
+	LoadNexthops() ([]NexthopObject, error)
+	SaveNexthops(nexthops []NexthopObject) error
+}
+
+// AddressStore persists the "last applied" full interface address set.
+// It is optionally implemented by a RouteStore; Controller.ReconcileSnapshot requires it
+// when addresses are managed.
+type AddressStore interface {
+	LoadAddresses() ([]Address, error)
+	SaveAddresses(addresses []Address) error
+}
+
+// NeighborStore persists the "last applied" full neighbor entry set.
+// It is optionally implemented by a RouteStore; Controller.ReconcileSnapshot requires it
+// when neighbors are managed.
+type NeighborStore interface {
+	LoadNeighbors() ([]Neighbor, error)
+	SaveNeighbors(neighbors []Neighbor) error
+}
+
+// LinkStore persists the "last applied" full link set.
+// It is optionally implemented by a RouteStore; Controller.ReconcileSnapshot requires it
+// when links are managed.
+type LinkStore interface {
+	LoadLinks() ([]Link, error)
+	SaveLinks(links []Link) error
+}
+
+// Snapshot is a full desired state: routes plus the objects they depend on.
+// It is also the on-disk format of FileStore once a section other than routes is used.
+type Snapshot struct {
+	Routes []Route `json:"routes"`
+	Rules  []Rule  `json:"rules,omitempty"`
+
+	Nexthops  []NexthopObject `json:"nexthops,omitempty"`
+	Addresses []Address       `json:"addresses,omitempty"`
+	Neighbors []Neighbor      `json:"neighbors,omitempty"`
+	Links     []Link          `json:"links,omitempty"`
+}
+
+// onlyRoutes reports whether s can be persisted in the legacy route array format.
+func (s Snapshot) onlyRoutes() bool {
+	return len(s.Rules) == 0 && len(s.Nexthops) == 0 &&
+		len(s.Addresses) == 0 && len(s.Neighbors) == 0 && len(s.Links) == 0
+}
+
 // FileStore stores routes as JSON on disk (atomic write).
+//
+// A file holding only routes is a JSON array of routes; once other sections
+// (e.g. rules) are saved, the file holds a JSON Snapshot object instead.
+// Both formats are accepted by Load.
 type FileStore struct {
 	Path string
 }
 
 func (s FileStore) Load() ([]Route, error) {
+	snap, err := s.load()
+	return snap.Routes, err
+}
+
+func (s FileStore) Save(routes []Route) error {
+	// Normalize before persisting to avoid key churn across runs.
+	return saveSection(s, routes, "routes", func(snap *Snapshot, rr []Route) { snap.Routes = rr })
+}
+
+func (s FileStore) LoadRules() ([]Rule, error) {
+	snap, err := s.load()
+	return snap.Rules, err
+}
+
+func (s FileStore) SaveRules(rules []Rule) error {
+	return saveSection(s, rules, "rules", func(snap *Snapshot, rr []Rule) { snap.Rules = rr })
+}
+
+func (s FileStore) LoadNexthops() ([]NexthopObject, error) {
+	snap, err := s.load()
+	return snap.Nexthops, err
+}
+
+func (s FileStore) SaveNexthops(nexthops []NexthopObject) error {
+	return saveSection(s, nexthops, "nexthops", func(snap *Snapshot, nhs []NexthopObject) { snap.Nexthops = nhs })
+}
+
+func (s FileStore) LoadAddresses() ([]Address, error) {
+	snap, err := s.load()
+	return snap.Addresses, err
+}
+
+func (s FileStore) SaveAddresses(addresses []Address) error {
+	return saveSection(s, addresses, "addresses", func(snap *Snapshot, aa []Address) { snap.Addresses = aa })
+}
+
+func (s FileStore) LoadNeighbors() ([]Neighbor, error) {
+	snap, err := s.load()
+	return snap.Neighbors, err
+}
+
+func (s FileStore) SaveNeighbors(neighbors []Neighbor) error {
+	return saveSection(s, neighbors, "neighbors", func(snap *Snapshot, nn []Neighbor) { snap.Neighbors = nn })
+}
+
+func (s FileStore) LoadLinks() ([]Link, error) {
+	snap, err := s.load()
+	return snap.Links, err
+}
+
+func (s FileStore) SaveLinks(links []Link) error {
+	return saveSection(s, links, "links", func(snap *Snapshot, ll []Link) { snap.Links = ll })
+}
+
+// LoadSnapshot returns every section of the baseline.
+func (s FileStore) LoadSnapshot() (Snapshot, error) {
+	return s.load()
+}
+
+// SaveSnapshot replaces every section of the baseline with snap's.
+func (s FileStore) SaveSnapshot(snap Snapshot) error {
+	var norm Snapshot
+	var err error
+	if norm.Routes, err = normalizeAll(snap.Routes, "routes"); err != nil {
+		return err
+	}
+	if norm.Rules, err = normalizeAll(snap.Rules, "rules"); err != nil {
+		return err
+	}
+	if norm.Nexthops, err = normalizeAll(snap.Nexthops, "nexthops"); err != nil {
+		return err
+	}
+	if norm.Addresses, err = normalizeAll(snap.Addresses, "addresses"); err != nil {
+		return err
+	}
+	if norm.Neighbors, err = normalizeAll(snap.Neighbors, "neighbors"); err != nil {
+		return err
+	}
+	if norm.Links, err = normalizeAll(snap.Links, "links"); err != nil {
+		return err
+	}
+	return s.save(norm)
+}
+
+// saveSection normalizes objs and stores them with set, keeping the other sections.
+func saveSection[T interface{ Normalize() (T, error) }](s FileStore, objs []T, what string, set func(*Snapshot, []T)) error {
+	norm, err := normalizeAll(objs, what)
+	if err != nil {
+		return err
+	}
+	snap, err := s.load()
+	if err != nil {
+		return err
+	}
+	set(&snap, norm)
+	return s.save(snap)
+}
+
+func (s FileStore) load() (Snapshot, error) {
 	if s.Path == "" {
-		return nil, fmt.Errorf("filestore path is empty")
+		return Snapshot{}, fmt.Errorf("filestore path is empty")
 	}
 	b, err := os.ReadFile(s.Path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return nil, nil
+			return Snapshot{}, nil
 		}
-		return nil, err
+		return Snapshot{}, err
 	}
+	b = bytes.TrimSpace(b)
 	if len(b) == 0 {
-		return nil, nil
+		return Snapshot{}, nil
+	}
+	var snap Snapshot
+	if b[0] == '[' {
+		err = json.Unmarshal(b, &snap.Routes)
+	} else {
+		err = json.Unmarshal(b, &snap)
 	}
-	var routes []Route
-	if err := json.Unmarshal(b, &routes); err != nil {
-		return nil, err
+	if err != nil {
+		return Snapshot{}, err
 	}
-	return routes, nil
+	// Hand-written files may use iproute2 names.
+	return DefaultNameResolver().ResolveSnapshot(snap)
 }
 
-func (s FileStore) Save(routes []Route) error {
+func (s FileStore) save(snap Snapshot) error {
 	if s.Path == "" {
 		return fmt.Errorf("filestore path is empty")
 	}
 
-	// Normalize before persisting to avoid key churn across runs.
-	norm := make([]Route, 0, len(routes))
-	for i, r := range routes {
-		n, err := r.Normalize()
-		if err != nil {
-			return fmt.Errorf("routes[%d]: %w", i, err)
+	var v any = snap
+	if snap.onlyRoutes() {
+		routes := snap.Routes
+		if routes == nil {
+			routes = []Route{}
 		}
-		norm = append(norm, n)
+		v = routes
 	}
-
-	b, err := json.MarshalIndent(norm, "", "  ")
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
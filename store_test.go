@@ -0,0 +1,113 @@
+package linuxroute
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStore_Sections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	s := FileStore{Path: path}
+
+	routes := []Route{{Dst: "default", Nexthops: []Nexthop{
+		{Gateway: "10.0.0.2", Device: "eth1", Weight: 2},
+		{Gateway: "10.0.0.1", Device: "eth0"},
+	}}}
+	if err := s.Save(routes); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(b), "[") {
+		t.Fatalf("routes-only baseline should stay a JSON array, got %s", b)
+	}
+
+	if err := s.SaveRules([]Rule{{Priority: 100, Table: 100}}); err != nil {
+		t.Fatalf("SaveRules() error: %v", err)
+	}
+	gotRoutes, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	gotRules, err := s.LoadRules()
+	if err != nil {
+		t.Fatalf("LoadRules() error: %v", err)
+	}
+	if len(gotRules) != 1 || len(gotRoutes) != 1 || len(gotRoutes[0].Nexthops) != 2 {
+		t.Fatalf("sections not preserved, routes=%+v rules=%+v", gotRoutes, gotRules)
+	}
+	if gotRoutes[0].Nexthops[0].Gateway != "10.0.0.1" {
+		t.Fatalf("nexthops not canonicalized, got %+v", gotRoutes[0].Nexthops)
+	}
+}
+
+func TestStores_SectionsRoundTrip(t *testing.T) {
+	want := Snapshot{
+		Routes:    []Route{{Dst: "10.1.0.0/16", NexthopID: 1}},
+		Rules:     []Rule{{Priority: 100, Table: 100}},
+		Nexthops:  []NexthopObject{{ID: 1, Gateway: "10.0.0.1", Device: "eth0"}},
+		Addresses: []Address{{IP: "10.0.0.5/24", Device: "eth0"}},
+		Neighbors: []Neighbor{{IP: "10.0.0.1", LLAddr: "52:54:00:00:00:01", Device: "eth0"}},
+		Links:     []Link{{Name: "br0", Kind: "bridge"}},
+	}
+	type store interface {
+		RouteStore
+		RuleStore
+		NexthopStore
+		AddressStore
+		NeighborStore
+		LinkStore
+	}
+	for name, s := range map[string]store{
+		"file":   FileStore{Path: filepath.Join(t.TempDir(), "baseline.json")},
+		"memory": &MemoryStore{},
+	} {
+		// Each section is saved on its own and must keep the others.
+		for _, save := range []func() error{
+			func() error { return s.Save(want.Routes) },
+			func() error { return s.SaveRules(want.Rules) },
+			func() error { return s.SaveNexthops(want.Nexthops) },
+			func() error { return s.SaveAddresses(want.Addresses) },
+			func() error { return s.SaveNeighbors(want.Neighbors) },
+			func() error { return s.SaveLinks(want.Links) },
+		} {
+			if err := save(); err != nil {
+				t.Fatalf("%s: save error: %v", name, err)
+			}
+		}
+
+		var got Snapshot
+		got.Routes, _ = s.Load()
+		got.Rules, _ = s.LoadRules()
+		got.Nexthops, _ = s.LoadNexthops()
+		got.Addresses, _ = s.LoadAddresses()
+		got.Neighbors, _ = s.LoadNeighbors()
+		got.Links, _ = s.LoadLinks()
+		for section, n := range map[string][2]int{
+			"routes":    {len(got.Routes), len(want.Routes)},
+			"rules":     {len(got.Rules), len(want.Rules)},
+			"nexthops":  {len(got.Nexthops), len(want.Nexthops)},
+			"addresses": {len(got.Addresses), len(want.Addresses)},
+			"neighbors": {len(got.Neighbors), len(want.Neighbors)},
+			"links":     {len(got.Links), len(want.Links)},
+		} {
+			if n[0] != n[1] {
+				t.Fatalf("%s: %s not preserved, got %+v", name, section, got)
+			}
+		}
+	}
+
+	fs := FileStore{Path: filepath.Join(t.TempDir(), "snapshot.json")}
+	if err := fs.SaveSnapshot(want); err != nil {
+		t.Fatalf("SaveSnapshot() error: %v", err)
+	}
+	if got, err := fs.LoadSnapshot(); err != nil || len(got.Routes) != 1 || len(got.Rules) != 1 || len(got.Nexthops) != 1 ||
+		len(got.Addresses) != 1 || len(got.Neighbors) != 1 || len(got.Links) != 1 || got.Links[0].State != "up" {
+		t.Fatalf("LoadSnapshot() = %+v, %v", got, err)
+	}
+
+	if err := (FileStore{Path: filepath.Join(t.TempDir(), "b.json")}).SaveLinks([]Link{{Name: "x", Kind: "veth"}}); err == nil || !strings.Contains(err.Error(), "links[0]") {
+		t.Fatalf("expected an invalid link to be rejected, got %v", err)
+	}
+}
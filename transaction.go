@@ -0,0 +1,49 @@
+package linuxroute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TransactionError is returned by a Transactional reconcile when an op failed.
+// The ops applied before the failure have been undone in reverse order
+// and the old baseline has been kept.
+type TransactionError struct {
+	// Failed is the op that failed and Err its error.
+	Failed RouteOp
+	Err    error
+	// RolledBack lists the undo ops that succeeded, in the order they were applied.
+	RolledBack []RouteOp
+	// RollbackErrs holds undo ops that failed; the system may be left in a mixed state.
+	RollbackErrs []error
+}
+
+func (e *TransactionError) Error() string {
+	msg := fmt.Sprintf("%s: %v; rolled back %d operation(s)", e.Failed, e.Err, len(e.RolledBack))
+	if len(e.RollbackErrs) > 0 {
+		msg += fmt.Sprintf(", %d rollback failure(s): %v", len(e.RollbackErrs), errors.Join(e.RollbackErrs...))
+	}
+	return msg
+}
+
+func (e *TransactionError) Unwrap() []error {
+	return append([]error{e.Err}, e.RollbackErrs...)
+}
+
+// rollback undoes done in reverse order after failed returned err.
+func (c Controller) rollback(ctx context.Context, failed RouteOp, err error, done []RouteOp) *TransactionError {
+	// Undo even if the reconcile context was canceled.
+	ctx = context.WithoutCancel(ctx)
+
+	txErr := &TransactionError{Failed: failed, Err: err}
+	for i := len(done) - 1; i >= 0; i-- {
+		undo := done[i].inverse()
+		if err := c.apply(ctx, undo); err != nil {
+			txErr.RollbackErrs = append(txErr.RollbackErrs, fmt.Errorf("%s: %w", undo, err))
+			continue
+		}
+		txErr.RolledBack = append(txErr.RolledBack, undo)
+	}
+	return txErr
+}
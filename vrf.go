@@ -0,0 +1,120 @@
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VRF describes a VRF device ("ip link add vrf-blue type vrf table 10").
+type VRF struct {
+	Name  string `json:"name"`
+	Table int    `json:"table"`
+}
+
+// Normalize validates v. It returns a copy of v.
+func (v VRF) Normalize() (VRF, error) {
+	out := v
+	out.Name = strings.TrimSpace(out.Name)
+	if out.Name == "" {
+		return VRF{}, fmt.Errorf("vrf.name is required")
+	}
+	if out.Table <= 0 {
+		return VRF{}, fmt.Errorf("vrf.table must be > 0")
+	}
+	return out, nil
+}
+
+// ensureVRFs makes sure the VRF devices used by the routes diff adds or
+// replaces exist before it is applied: missing devices listed in VRFs are created, and an existing device
+// with another table or a route naming an unknown VRF is an error.
+// It does nothing without VRFManager.
+func (c Controller) ensureVRFs(ctx context.Context, diff DiffResult) error {
+	if c.VRFManager == nil {
+		return nil
+	}
+	live, err := c.VRFManager.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list vrfs: %w", err)
+	}
+	tables := make(map[string]int, len(live)+len(c.VRFs))
+	for _, v := range live {
+		tables[v.Name] = v.Table
+	}
+
+	for i, v := range c.VRFs {
+		n, err := v.Normalize()
+		if err != nil {
+			return fmt.Errorf("vrfs[%d]: %w", i, err)
+		}
+		if t, ok := tables[n.Name]; ok {
+			if t != n.Table {
+				return fmt.Errorf("vrf %s has table %d, want %d", n.Name, t, n.Table)
+			}
+			continue
+		}
+		if err := c.VRFManager.Add(ctx, n); err != nil {
+			return fmt.Errorf("add vrf %s: %w", n.Name, err)
+		}
+		tables[n.Name] = n.Table
+	}
+
+	routes := append([]Route(nil), diff.ToAdd...)
+	for _, rc := range diff.ToReplace {
+		routes = append(routes, rc.New)
+	}
+	for _, r := range routes {
+		if _, ok := tables[r.VRF]; r.VRF != "" && !ok {
+			return fmt.Errorf("route %s: vrf %s does not exist", r.Dst, r.VRF)
+		}
+	}
+	return nil
+}
+
+// setVRF reports a route in a VRF table by VRF name instead of table.
+func setVRF(r *Route, vrfs map[int]string) {
+	if r.Family != "" || r.Table == 0 {
+		return
+	}
+	if name, ok := vrfs[r.Table]; ok {
+		r.VRF, r.Table = name, 0
+	}
+}
+
+// vrfNames returns the VRF device name of each VRF table known to c: the ones
+// listed in VRFs and, with VRFManager, the devices on the system.
+func (c Controller) vrfNames(ctx context.Context) (map[int]string, error) {
+	vrfs := make(map[int]string, len(c.VRFs))
+	if c.VRFManager != nil {
+		live, err := c.VRFManager.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list vrfs: %w", err)
+		}
+		for _, v := range live {
+			vrfs[v.Table] = v.Name
+		}
+	}
+	for i, v := range c.VRFs {
+		n, err := v.Normalize()
+		if err != nil {
+			return nil, fmt.Errorf("vrfs[%d]: %w", i, err)
+		}
+		vrfs[n.Table] = n.Name
+	}
+	return vrfs, nil
+}
+
+// inVRFs returns a copy of routes with the ones in a VRF table spelled by VRF
+// name, the way RouteManager.List reports them, so that "table 10" and
+// "vrf blue" are the same route when blue uses table 10.
+func inVRFs(routes []Route, vrfs map[int]string) []Route {
+	if len(vrfs) == 0 {
+		return routes
+	}
+	out := make([]Route, len(routes))
+	for i, r := range routes {
+		setVRF(&r, vrfs)
+		out[i] = r
+	}
+	return out
+}
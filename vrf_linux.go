@@ -0,0 +1,62 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPVRFManager implements VRFManager using the netlink library.
+type IPVRFManager struct {
+	// Handle, when set, is used for every netlink call instead of the global handle.
+	Handle *netlink.Handle
+}
+
+func (m IPVRFManager) handle() *netlink.Handle {
+	if m.Handle != nil {
+		return m.Handle
+	}
+	return &netlink.Handle{}
+}
+
+func (m IPVRFManager) List(ctx context.Context) ([]VRF, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	tables, err := vrfTables(m.handle())
+	if err != nil {
+		return nil, err
+	}
+	vrfs := make([]VRF, 0, len(tables))
+	for table, name := range tables {
+		vrfs = append(vrfs, VRF{Name: name, Table: table})
+	}
+	return vrfs, nil
+}
+
+func (m IPVRFManager) Add(ctx context.Context, v VRF) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	n, err := v.Normalize()
+	if err != nil {
+		return err
+	}
+	link := &netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: n.Name}, Table: uint32(n.Table)}
+	if err := m.handle().LinkAdd(link); err != nil {
+		return err
+	}
+	if err := m.handle().LinkSetUp(link); err != nil {
+		return fmt.Errorf("set %s up: %w", n.Name, err)
+	}
+	return nil
+}
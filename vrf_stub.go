@@ -0,0 +1,23 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// IPVRFManager is not supported on non-Linux platforms.
+type IPVRFManager struct {
+	Handle *netlink.Handle
+}
+
+func (m IPVRFManager) List(ctx context.Context) ([]VRF, error) {
+	return nil, fmt.Errorf("IPVRFManager is supported only on linux")
+}
+
+func (m IPVRFManager) Add(ctx context.Context, v VRF) error {
+	return fmt.Errorf("IPVRFManager is supported only on linux")
+}
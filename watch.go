@@ -0,0 +1,125 @@
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultWatchDebounce is used by Controller.Run when WatchDebounce is 0.
+const defaultWatchDebounce = time.Second
+
+// RouteEvent is a change notification delivered by a RouteWatcher.
+type RouteEvent struct {
+	// Route is the route that was added/changed, or removed if Deleted is set.
+	Route   Route
+	Deleted bool
+
+	// Link is set instead of Route for a device state change.
+	Link   string
+	LinkUp bool
+}
+
+// RouteWatcher is optionally implemented by a RouteManager that can stream
+// kernel route and link changes (e.g. via a netlink subscription).
+// The returned channel is closed when ctx is done or the subscription fails.
+type RouteWatcher interface {
+	Watch(ctx context.Context) (<-chan RouteEvent, error)
+}
+
+// Run turns the controller into a self-healing agent: it subscribes to kernel
+// route/link events through the manager's RouteWatcher and, whenever an owned
+// route disappears or a device comes up, re-applies the last desired routes
+// (the Store baseline saved by the last successful Reconcile) via ReconcileLive.
+//
+// Events are debounced by WatchDebounce, so a link flap that flushes many routes
+// triggers a single repair. A repair also runs once at startup.
+// Repair errors are passed to OnRepairError and do not stop the loop.
+// Run returns when ctx is done or the subscription ends.
+func (c Controller) Run(ctx context.Context) error {
+	if c.Manager == nil {
+		return fmt.Errorf("manager is nil")
+	}
+	if c.Store == nil {
+		return fmt.Errorf("store is nil")
+	}
+	w, ok := c.Manager.(RouteWatcher)
+	if !ok {
+		return fmt.Errorf("manager does not support watching routes")
+	}
+
+	debounce := c.WatchDebounce
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := w.Watch(ctx)
+	if err != nil {
+		return fmt.Errorf("watch routes: %w", err)
+	}
+
+	c.repair(ctx)
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("route watch closed")
+			}
+			if c.relevant(ev) {
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			c.repair(ctx)
+		}
+	}
+}
+
+// relevant reports whether ev may require a repair.
+func (c Controller) relevant(ev RouteEvent) bool {
+	if ev.Link != "" {
+		return ev.LinkUp
+	}
+	if !ev.Deleted {
+		return false
+	}
+	if c.ownerProto() != "" {
+		n, err := ev.Route.Normalize()
+		return err == nil && c.ownedByProto(n)
+	}
+	baseline, err := c.Store.Load()
+	if err != nil {
+		return true
+	}
+	n, err := ev.Route.Normalize()
+	if err != nil {
+		return false
+	}
+	for _, b := range baseline {
+		if nb, err := b.Normalize(); err == nil && nb.Dst == n.Dst {
+			return true
+		}
+	}
+	return false
+}
+
+// repair re-applies the Store baseline against the live routing table.
+func (c Controller) repair(ctx context.Context) {
+	desired, err := c.Store.Load()
+	if err == nil {
+		_, err = c.ReconcileLive(ctx, desired)
+	}
+	if err != nil && ctx.Err() == nil && c.OnRepairError != nil {
+		c.OnRepairError(err)
+	}
+}
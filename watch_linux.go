@@ -0,0 +1,87 @@
+//go:build linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Watch implements RouteWatcher with netlink route and link subscriptions,
+// in the manager's network namespace.
+func (m IPRouteManager) Watch(ctx context.Context) (<-chan RouteEvent, error) {
+	done := make(chan struct{})
+	routeCh := make(chan netlink.RouteUpdate, 64)
+	linkCh := make(chan netlink.LinkUpdate, 64)
+
+	err := netlink.RouteSubscribeWithOptions(routeCh, done, netlink.RouteSubscribeOptions{Namespace: m.ns})
+	if err != nil {
+		close(done)
+		return nil, fmt.Errorf("subscribe routes: %w", err)
+	}
+	err = netlink.LinkSubscribeWithOptions(linkCh, done, netlink.LinkSubscribeOptions{Namespace: m.ns})
+	if err != nil {
+		close(done)
+		go drain(routeCh)
+		return nil, fmt.Errorf("subscribe links: %w", err)
+	}
+
+	out := make(chan RouteEvent)
+	go func() {
+		defer close(out)
+		defer func() {
+			// Stop the subscriptions and unblock their senders.
+			close(done)
+			go drain(routeCh)
+			go drain(linkCh)
+		}()
+
+		for {
+			var ev RouteEvent
+			select {
+			case <-ctx.Done():
+				return
+			case u, ok := <-routeCh:
+				if !ok {
+					return
+				}
+				r, err := fromNetlinkRoute(m.handle(), u.Route)
+				if err != nil {
+					continue
+				}
+				if r.Table != tableMain {
+					if vrfs, err := vrfTables(m.handle()); err == nil {
+						setVRF(&r, vrfs)
+					}
+				}
+				ev = RouteEvent{Route: r, Deleted: u.Type == unix.RTM_DELROUTE}
+			case u, ok := <-linkCh:
+				if !ok {
+					return
+				}
+				attrs := u.Attrs()
+				if attrs == nil {
+					continue
+				}
+				ev = RouteEvent{Link: attrs.Name, LinkUp: attrs.Flags&net.FlagUp != 0}
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func drain[T any](ch <-chan T) {
+	for range ch {
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package linuxroute
+
+import (
+	"context"
+	"fmt"
+)
+
+func (m IPRouteManager) Watch(ctx context.Context) (<-chan RouteEvent, error) {
+	return nil, fmt.Errorf("IPRouteManager is supported only on linux")
+}